@@ -0,0 +1,127 @@
+// Package cassette records the HTTP traffic of the API clients to golden
+// files, and replays it, so that integration tests can capture the real API's
+// behaviour once and run deterministically, offline, from then on.
+//
+// A RecordingTransport or ReplayTransport plugs in underneath either client:
+//
+//	rec := cassette.NewRecordingTransport("testdata/apps.json", nil, toks)
+//	defer rec.Save()
+//
+//	client := fly.NewClientFromOptions(fly.ClientOptions{
+//		Tokens:    toks,
+//		Transport: &fly.Transport{UnderlyingTransport: rec},
+//	})
+//	flapsClient, err := flaps.NewWithOptions(ctx, flaps.NewClientOpts{
+//		Tokens:    toks,
+//		Transport: rec,
+//	})
+//
+// Credentials never reach the cassette: authorization and cookie headers are
+// replaced, as is every token in the tokens.Tokens the recorder is given,
+// wherever it appears. A ReplayTransport given the same tokens scrubs the
+// requests it's sent in the same way before matching them.
+package cassette
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// Redacted replaces scrubbed values in recorded interactions.
+const Redacted = "REDACTED"
+
+// Cassette is a recording of HTTP interactions, in the order they happened.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a request and the response it got.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body is a request or response body. Text bodies are stored as strings, so
+// that cassettes diff well, and anything else as base64.
+type Body []byte
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(b)})
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+
+	var encoded struct {
+		Base64 string `json:"base64"`
+	}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return fmt.Errorf("invalid cassette body: %w", err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded.Base64)
+	if err != nil {
+		return fmt.Errorf("invalid cassette body: %w", err)
+	}
+	*b = decoded
+
+	return nil
+}
+
+// Load reads a cassette from a file.
+func Load(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	c := new(Cassette)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to decode cassette %s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+// Save writes a cassette to a file, creating its directory if needed.
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	data = append(data, '\n')
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to save cassette: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to save cassette: %w", err)
+	}
+
+	return nil
+}
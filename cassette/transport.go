@@ -0,0 +1,286 @@
+package cassette
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/superfly/fly-go/tokens"
+)
+
+// scrubbedHeaders are replaced wholesale in recorded interactions.
+var scrubbedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// RecordingTransport is an http.RoundTripper that passes requests through to
+// another one and records them, with their responses, for Save to write out.
+type RecordingTransport struct {
+	// Scrub, if set, is called on every interaction after the built-in
+	// scrubbing, to remove anything else that shouldn't be recorded.
+	Scrub func(*Interaction)
+
+	path       string
+	underlying http.RoundTripper
+	tokens     *tokens.Tokens
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecordingTransport returns a transport that records to the cassette
+// file at path. Requests are made with underlying, or http.DefaultTransport if
+// it's nil. Every token in toks, which may be nil, is scrubbed from the
+// recording.
+func NewRecordingTransport(path string, underlying http.RoundTripper, toks *tokens.Tokens) *RecordingTransport {
+	if underlying == nil {
+		underlying = http.DefaultTransport
+	}
+
+	return &RecordingTransport{
+		path:       path,
+		underlying: underlying,
+		tokens:     toks,
+	}
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record request: %w", err)
+	}
+	if reqBody != nil {
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := t.underlying.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := readBody(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record response: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	i := Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: req.Header.Clone(),
+			Body:   reqBody,
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header.Clone(),
+			Body:       respBody,
+		},
+	}
+	t.scrub(&i)
+
+	t.mu.Lock()
+	t.cassette.Interactions = append(t.cassette.Interactions, i)
+	t.mu.Unlock()
+
+	return resp, nil
+}
+
+// Save writes everything recorded so far to the cassette file.
+func (t *RecordingTransport) Save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.cassette.Save(t.path)
+}
+
+func (t *RecordingTransport) scrub(i *Interaction) {
+	scrub(i, t.tokens)
+	if t.Scrub != nil {
+		t.Scrub(i)
+	}
+}
+
+// scrub replaces the credentials in an interaction, and every token in toks,
+// which may be nil, wherever it appears.
+func scrub(i *Interaction, toks *tokens.Tokens) {
+	for _, h := range []http.Header{i.Request.Header, i.Response.Header} {
+		for _, name := range scrubbedHeaders {
+			for j := range h[name] {
+				h[name][j] = Redacted
+			}
+		}
+	}
+
+	secrets := tokenSecrets(toks)
+	if len(secrets) == 0 {
+		return
+	}
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, s := range secrets {
+		pairs = append(pairs, s, Redacted)
+	}
+	r := strings.NewReplacer(pairs...)
+
+	i.Request.URL = r.Replace(i.Request.URL)
+	for _, h := range []http.Header{i.Request.Header, i.Response.Header} {
+		for _, values := range h {
+			for j, v := range values {
+				values[j] = r.Replace(v)
+			}
+		}
+	}
+	if i.Request.Body != nil {
+		i.Request.Body = Body(r.Replace(string(i.Request.Body)))
+	}
+	if i.Response.Body != nil {
+		i.Response.Body = Body(r.Replace(string(i.Response.Body)))
+	}
+}
+
+// tokenSecrets returns the individual tokens to scrub, longest first so that
+// no token is left half-replaced because it contains another.
+func tokenSecrets(toks *tokens.Tokens) []string {
+	if toks == nil {
+		return nil
+	}
+
+	var out []string
+	for _, tok := range slices.Concat(toks.GetMacaroonTokens(), toks.GetUserTokens()) {
+		if tok = tokens.StripAuthorizationScheme(tok); tok != "" {
+			out = append(out, tok)
+		}
+	}
+	slices.SortFunc(out, func(a, b string) int { return cmp.Compare(len(b), len(a)) })
+
+	return slices.Compact(out)
+}
+
+// ErrNoInteraction is returned by a ReplayTransport for a request the
+// cassette has no recording of.
+var ErrNoInteraction = errors.New("no recorded interaction matches request")
+
+// ReplayTransport is an http.RoundTripper that answers requests from a
+// cassette instead of the network. Each recorded interaction is replayed at
+// most once, in the order they were recorded, so a cassette of repeated
+// requests, such as polling, replays the same sequence of responses.
+//
+// Requests are scrubbed the same way as they were when they were recorded
+// before they're matched, so the tokens they carry and the Redacted ones in
+// the cassette match up.
+type ReplayTransport struct {
+	// Match reports whether a request, scrubbed, matches a recorded one. By
+	// default, the method, URL and body must be the same.
+	Match func(req Request, recorded Request) bool
+
+	// Scrub, if set, is called on every request after the built-in
+	// scrubbing, and should match the recorder's.
+	Scrub func(*Interaction)
+
+	tokens *tokens.Tokens
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewReplayTransport returns a transport that replays the cassette file at
+// path. toks, which may be nil, should be the tokens the requests carry, so
+// that they're scrubbed like they were when they were recorded.
+func NewReplayTransport(path string, toks *tokens.Tokens) (*ReplayTransport, error) {
+	c, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReplayTransport{
+		tokens:       toks,
+		interactions: c.Interactions,
+		used:         make([]bool, len(c.Interactions)),
+	}, nil
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request: %w", err)
+	}
+
+	live := Interaction{Request: Request{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header.Clone(),
+		Body:   body,
+	}}
+	scrub(&live, t.tokens)
+	if t.Scrub != nil {
+		t.Scrub(&live)
+	}
+
+	match := t.Match
+	if match == nil {
+		match = defaultMatch
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for idx, i := range t.interactions {
+		if t.used[idx] || !match(live.Request, i.Request) {
+			continue
+		}
+		t.used[idx] = true
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", i.Response.StatusCode, http.StatusText(i.Response.StatusCode)),
+			StatusCode:    i.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        i.Response.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(i.Response.Body)),
+			ContentLength: int64(len(i.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, req.URL)
+}
+
+// Remaining returns the recorded interactions that haven't been replayed yet.
+// Tests can check it's empty to make sure the code under test made every
+// request it made when the cassette was recorded.
+func (t *ReplayTransport) Remaining() []Interaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var out []Interaction
+	for idx, i := range t.interactions {
+		if !t.used[idx] {
+			out = append(out, i)
+		}
+	}
+
+	return out
+}
+
+func defaultMatch(req Request, recorded Request) bool {
+	return req.Method == recorded.Method &&
+		req.URL == recorded.URL &&
+		bytes.Equal(req.Body, recorded.Body)
+}
+
+// readBody reads and closes a request or response body, which may be nil.
+func readBody(body io.ReadCloser) ([]byte, error) {
+	if body == nil || body == http.NoBody {
+		return nil, nil
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
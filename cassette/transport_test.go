@@ -0,0 +1,229 @@
+package cassette_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/cassette"
+	"github.com/superfly/fly-go/flaps"
+	"github.com/superfly/fly-go/flaps/flapstest"
+	"github.com/superfly/fly-go/flytest"
+	"github.com/superfly/fly-go/tokens"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "testdata", "cassette.json")
+	toks := tokens.Parse("FlyV1 fm2_lorem,fm2_ipsum")
+
+	flapsSrv := flapstest.NewServer()
+	flapsSrv.AddApp("my-app", "personal")
+	graphQLSrv := flytest.NewServer()
+	graphQLSrv.AddApp(fly.App{Name: "my-app"})
+
+	rec := cassette.NewRecordingTransport(path, nil, toks)
+	flapsClient, graphQLClient := newClients(t, flapsSrv.URL, graphQLSrv.URL, toks, rec)
+
+	launched, err := flapsClient.Launch(ctx, "my-app", fly.LaunchMachineInput{
+		Config: &fly.MachineConfig{Image: "nginx"},
+	})
+	if err != nil {
+		t.Fatalf("Launch() error = %v", err)
+	}
+	app, err := graphQLClient.GetApp(ctx, "my-app")
+	if err != nil {
+		t.Fatalf("GetApp() error = %v", err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// Replay against servers that are gone, to be sure nothing reaches the
+	// network.
+	flapsSrv.Close()
+	graphQLSrv.Close()
+
+	replay, err := cassette.NewReplayTransport(path, toks)
+	if err != nil {
+		t.Fatalf("NewReplayTransport() error = %v", err)
+	}
+	flapsClient, graphQLClient = newClients(t, flapsSrv.URL, graphQLSrv.URL, toks, replay)
+
+	replayed, err := flapsClient.Launch(ctx, "my-app", fly.LaunchMachineInput{
+		Config: &fly.MachineConfig{Image: "nginx"},
+	})
+	if err != nil {
+		t.Fatalf("replayed Launch() error = %v", err)
+	}
+	if replayed.ID != launched.ID {
+		t.Fatalf("replayed Launch() = %s, want %s", replayed.ID, launched.ID)
+	}
+	replayedApp, err := graphQLClient.GetApp(ctx, "my-app")
+	if err != nil {
+		t.Fatalf("replayed GetApp() error = %v", err)
+	}
+	if replayedApp.ID != app.ID {
+		t.Fatalf("replayed GetApp() = %s, want %s", replayedApp.ID, app.ID)
+	}
+	if remaining := replay.Remaining(); len(remaining) != 0 {
+		t.Fatalf("Remaining() = %+v, want every interaction replayed", remaining)
+	}
+
+	if _, err := flapsClient.Get(ctx, "my-app", launched.ID); !errors.Is(err, cassette.ErrNoInteraction) {
+		t.Fatalf("Get() of an unrecorded request error = %v, want ErrNoInteraction", err)
+	}
+}
+
+func newClients(t *testing.T, flapsURL, graphQLURL string, toks *tokens.Tokens, transport http.RoundTripper) (*flaps.Client, *fly.Client) {
+	t.Helper()
+
+	flapsClient, err := flaps.NewWithOptions(context.Background(), flaps.NewClientOpts{
+		BaseURL:   flapsURL,
+		Tokens:    toks,
+		Transport: transport,
+	})
+	if err != nil {
+		t.Fatalf("flaps.NewWithOptions() error = %v", err)
+	}
+
+	graphQLClient := fly.NewClientFromOptions(fly.ClientOptions{
+		BaseURL:   graphQLURL,
+		Tokens:    toks,
+		Transport: &fly.Transport{UnderlyingTransport: transport, Tokens: toks},
+	})
+
+	return flapsClient, graphQLClient
+}
+
+func TestRecordingScrubsTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	toks := tokens.Parse("FlyV1 fm2_lorem,fm2_ipsum,oauth_dolor")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "sit"})
+		w.Header().Set("X-Echo", r.Header.Get("Authorization"))
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	rec := cassette.NewRecordingTransport(path, nil, toks)
+	rec.Scrub = func(i *cassette.Interaction) {
+		i.Response.Header.Del("Date")
+	}
+	client := &http.Client{Transport: rec}
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/tokens?token=oauth_dolor", strings.NewReader(`{"tokens":["fm2_lorem","fm2_ipsum"]}`))
+	req.Header.Set("Authorization", toks.GraphQLHeader())
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "fm2_lorem") {
+		t.Fatalf("response body = %s, want it passed through unscrubbed", body)
+	}
+
+	if err := rec.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"lorem", "ipsum", "dolor", "sit"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains %q:\n%s", secret, data)
+		}
+	}
+	if strings.Contains(string(data), `"Date"`) {
+		t.Errorf("cassette contains the Date header removed by Scrub:\n%s", data)
+	}
+
+	c, err := cassette.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(c.Interactions) != 1 {
+		t.Fatalf("Load() = %d interactions, want 1", len(c.Interactions))
+	}
+	i := c.Interactions[0]
+	if got := i.Request.Header.Get("Authorization"); got != cassette.Redacted {
+		t.Errorf("recorded Authorization = %q, want %q", got, cassette.Redacted)
+	}
+	if want := srv.URL + "/tokens?token=" + cassette.Redacted; i.Request.URL != want {
+		t.Errorf("recorded URL = %q, want %q", i.Request.URL, want)
+	}
+	if want := `{"tokens":["REDACTED","REDACTED"]}`; string(i.Response.Body) != want {
+		t.Errorf("recorded response body = %s, want %s", i.Response.Body, want)
+	}
+}
+
+func TestReplayMatchesScrubbedRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	toks := tokens.Parse("FlyV1 fm2_lorem")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/check?token=fm2_lorem", strings.NewReader(`{"token":"fm2_lorem"}`))
+		req.Header.Set("Authorization", toks.GraphQLHeader())
+		return req
+	}
+
+	rec := cassette.NewRecordingTransport(path, nil, toks)
+	resp, err := (&http.Client{Transport: rec}).Do(newRequest())
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+	if err := rec.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	srv.Close()
+
+	replay, err := cassette.NewReplayTransport(path, toks)
+	if err != nil {
+		t.Fatalf("NewReplayTransport() error = %v", err)
+	}
+	resp, err = (&http.Client{Transport: replay}).Do(newRequest())
+	if err != nil {
+		t.Fatalf("replayed Do() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Fatalf("replayed body = %q, want %q", body, "ok")
+	}
+}
+
+func TestBinaryBodies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	want := cassette.Cassette{Interactions: []cassette.Interaction{{
+		Request:  cassette.Request{Method: http.MethodGet, URL: "https://example.com/"},
+		Response: cassette.Response{StatusCode: http.StatusOK, Body: cassette.Body{0xff, 0x00, 0xfe}},
+	}}}
+	if err := want.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	got, err := cassette.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if string(got.Interactions[0].Response.Body) != string(want.Interactions[0].Response.Body) {
+		t.Fatalf("Load() body = %x, want %x", got.Interactions[0].Response.Body, want.Interactions[0].Response.Body)
+	}
+}
@@ -89,6 +89,23 @@ func retryQueryOnConnReset(ctx context.Context, isQuery bool, op func() error) e
 	}, backoff.WithContext(backoff.WithMaxRetries(b, 2), ctx))
 }
 
+// retryQuery runs op the way policy says to. A nil policy keeps the default
+// retries; otherwise queries are marked idempotent and left to the policy's
+// transport.
+func retryQuery(ctx context.Context, policy *RetryPolicy, isQuery bool, op func(context.Context) error) error {
+	if policy == nil {
+		return retryQueryOnConnReset(ctx, isQuery, func() error {
+			return op(ctx)
+		})
+	}
+
+	if isQuery {
+		ctx = WithIdempotent(ctx)
+	}
+
+	return op(ctx)
+}
+
 var (
 	baseURL          string
 	errorLog         bool
@@ -140,6 +157,8 @@ type Client struct {
 	genqClient genq.Client
 	tokens     *tokens.Tokens
 	logger     Logger
+
+	retryPolicy *RetryPolicy
 }
 
 func (c *Client) Authenticated() bool {
@@ -172,6 +191,9 @@ type ClientOptions struct {
 	FlyForceInstanceID *string
 	Transport          *Transport
 	ClientSignals      *clientsignals.Signals
+
+	// optional, replaces the default retries
+	RetryPolicy *RetryPolicy
 }
 
 func (opts ClientOptions) tokens() *tokens.Tokens {
@@ -241,15 +263,16 @@ func NewClientFromOptions(opts ClientOptions) *Client {
 	}
 	transport.setDefaults(&opts)
 
-	httpClient, _ := NewHTTPClient(opts.Logger, transport)
+	httpClient, _ := NewHTTPClientWithRetryPolicy(opts.Logger, transport, opts.RetryPolicy)
 	url := fmt.Sprintf("%s/graphql", opts.BaseURL)
 	client := graphql.NewClient(url, graphql.WithHTTPClient(httpClient))
 	genqClient := genq.NewClient(url, httpClient)
 	tracingGenqClient := &tracingGenqlientClient{
-		client: genqClient,
+		client:      genqClient,
+		retryPolicy: opts.RetryPolicy,
 	}
 
-	return &Client{httpClient, client, tracingGenqClient, opts.tokens(), opts.Logger}
+	return &Client{httpClient, client, tracingGenqClient, opts.tokens(), opts.Logger, opts.RetryPolicy}
 }
 
 // NewRequest - creates a new GraphQL request
@@ -296,7 +319,7 @@ func (c *Client) RunWithContext(ctx context.Context, req *graphql.Request) (Quer
 
 	var resp Query
 	isQuery := c.getRequestType(req) == "query"
-	err := retryQueryOnConnReset(ctx, isQuery, func() error {
+	err := retryQuery(ctx, c.retryPolicy, isQuery, func(ctx context.Context) error {
 		resp = Query{}
 		return c.client.Run(ctx, req, &resp)
 	})
@@ -418,7 +441,8 @@ func (t *Transport) addAuthorization(req *http.Request) {
 
 // tracingGenqlientClient wraps a genqlient client to add OTEL tracing with operation names
 type tracingGenqlientClient struct {
-	client genq.Client
+	client      genq.Client
+	retryPolicy *RetryPolicy
 }
 
 func (c *tracingGenqlientClient) MakeRequest(ctx context.Context, req *genq.Request, resp *genq.Response) error {
@@ -442,7 +466,7 @@ func (c *tracingGenqlientClient) MakeRequest(ctx context.Context, req *genq.Requ
 
 	isQuery := graphQLOperationKind(req.Query) == "query"
 
-	return retryQueryOnConnReset(ctx, isQuery, func() error {
+	return retryQuery(ctx, c.retryPolicy, isQuery, func(ctx context.Context) error {
 		return c.client.MakeRequest(ctx, req, resp)
 	})
 }
@@ -3,8 +3,9 @@ package flaps
 import "context"
 
 type (
-	machineIDCtxKey struct{}
-	actionCtxKey    struct{}
+	machineIDCtxKey      struct{}
+	actionCtxKey         struct{}
+	idempotencyKeyCtxKey struct{}
 )
 
 func contextWithMachineID(ctx context.Context, id string) context.Context {
@@ -32,3 +33,15 @@ func actionFromContext(ctx context.Context) flapsAction {
 
 	return value.(flapsAction)
 }
+
+// WithIdempotencyKey returns a context that sends key as the Idempotency-Key
+// of the requests made with it, so that the API can tell a retry from a new
+// request and the request can be retried safely.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtxKey{}, key)
+}
+
+func idempotencyKeyFromContext(ctx context.Context) string {
+	value, _ := ctx.Value(idempotencyKeyCtxKey{}).(string)
+	return value
+}
@@ -35,6 +35,8 @@ type Client struct {
 	httpClient         *http.Client
 	userAgent          string
 	flyForceInstanceID string
+	retryPolicy        *fly.RetryPolicy
+	rateLimiter        *rateLimiter
 }
 
 type NewClientOpts struct {
@@ -54,11 +56,25 @@ type NewClientOpts struct {
 	// optional; if non-nil, attaches the Fly-Client-* headers/UA suffix
 	// derived from these signals
 	ClientSignals *clientsignals.Signals
+
+	// optional, overrides FLY_FLAPS_BASE_URL
+	BaseURL string
+
+	// optional, replaces the default retries. It also limits and paces the
+	// client's own retries, such as waiting for a lease, and sets the
+	// intervals of its polling.
+	RetryPolicy *fly.RetryPolicy
+
+	// optional, limits the rate and concurrency of requests
+	RateLimit *RateLimit
 }
 
 func NewWithOptions(ctx context.Context, opts NewClientOpts) (*Client, error) {
 	var err error
-	flapsBaseURL := os.Getenv("FLY_FLAPS_BASE_URL")
+	flapsBaseURL := opts.BaseURL
+	if flapsBaseURL == "" {
+		flapsBaseURL = os.Getenv("FLY_FLAPS_BASE_URL")
+	}
 	if flapsBaseURL == "" {
 		flapsBaseURL = "https://api.machines.dev"
 	}
@@ -86,7 +102,12 @@ func NewWithOptions(ctx context.Context, opts NewClientOpts) (*Client, error) {
 		opts.Logger.Debugf("flaps: client signals: disabled")
 	}
 	otelTransport := otelhttp.NewTransport(transport)
-	httpClient, err := fly.NewHTTPClient(opts.Logger, otelTransport)
+	rateLimiter := newRateLimiter(opts.RateLimit)
+	var limitedTransport http.RoundTripper = otelTransport
+	if rateLimiter != nil {
+		limitedTransport = rateLimiter.transport(otelTransport)
+	}
+	httpClient, err := fly.NewHTTPClientWithRetryPolicy(opts.Logger, limitedTransport, opts.RetryPolicy)
 	if err != nil {
 		return nil, fmt.Errorf("flaps: can't setup HTTP client to %s: %w", flapsUrl.String(), err)
 	}
@@ -113,6 +134,8 @@ func NewWithOptions(ctx context.Context, opts NewClientOpts) (*Client, error) {
 		httpClient:         httpClient,
 		userAgent:          userAgent,
 		flyForceInstanceID: flyForceInstanceID,
+		retryPolicy:        opts.RetryPolicy,
+		rateLimiter:        rateLimiter,
 	}, nil
 }
 
@@ -144,7 +167,7 @@ func (f *Client) _sendRequest(ctx context.Context, method, endpoint string, in,
 	// timing := instrument.Flaps.Begin()
 	// defer timing.End()
 
-	safeToRetry := method == http.MethodGet
+	safeToRetry := method == http.MethodGet || idempotencyKeyFromContext(ctx) != ""
 	resp, err := f.do(ctx, method, endpoint, in, headers, safeToRetry)
 	if err != nil {
 		tracing.RecordError(span, err, "failed to do request")
@@ -188,9 +211,15 @@ func (f *Client) _sendRequest(ctx context.Context, method, endpoint string, in,
 // do issues a single HTTP request. When safeToRetry is true, transient network
 // failures are retried.
 //
-// Retry lives here rather than in the shared rehttp transport so in the future
-// we can opt-in other non-GET endpoints that are known to be idempotent.
+// Retry lives here rather than in the shared rehttp transport because only
+// the client knows which non-GET requests are idempotent: those made with an
+// idempotency key. With a RetryPolicy, the request is marked instead and the
+// policy's transport retries it.
 func (f *Client) do(ctx context.Context, method, endpoint string, in interface{}, headers map[string][]string, safeToRetry bool) (*http.Response, error) {
+	if f.retryPolicy != nil && safeToRetry {
+		ctx = fly.WithIdempotent(ctx)
+	}
+
 	send := func() (*http.Response, error) {
 		req, err := f.NewRequest(ctx, method, endpoint, in, headers)
 		if err != nil {
@@ -202,7 +231,7 @@ func (f *Client) do(ctx context.Context, method, endpoint string, in interface{}
 		return f.httpClient.Do(req)
 	}
 
-	if !safeToRetry {
+	if !safeToRetry || f.retryPolicy != nil {
 		return send()
 	}
 
@@ -271,6 +300,9 @@ func (f *Client) NewRequest(ctx context.Context, method, path string, in interfa
 	if f.flyForceInstanceID != "" {
 		req.Header.Set("Fly-Force-Instance-Id", f.flyForceInstanceID)
 	}
+	if key := idempotencyKeyFromContext(ctx); key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
 
 	return req, nil
 }
@@ -302,23 +334,28 @@ func (f *Client) getCaveatNames() ([]string, error) {
 }
 
 // handleAPIError returns an error based on the status code and response body.
+// Requests rejected as invalid get a *ValidationError.
 func handleAPIError(statusCode int, responseBody []byte) error {
 	switch statusCode / 100 {
 	case 1, 3:
 		return fmt.Errorf("API returned unexpected status, %d", statusCode)
 	case 4, 5:
-		apiErr := struct {
-			Error   string `json:"error"`
-			Message string `json:"message,omitempty"`
-		}{}
+		var apiErr errorResponse
+		var err error
 		jsonErr := json.Unmarshal(responseBody, &apiErr)
 		if jsonErr != nil {
-			return fmt.Errorf("request returned non-2xx status: %d: %s", statusCode, string(responseBody))
+			err = fmt.Errorf("request returned non-2xx status: %d: %s", statusCode, string(responseBody))
 		} else if apiErr.Message != "" {
-			return fmt.Errorf("%s", apiErr.Message)
+			err = fmt.Errorf("%s", apiErr.Message)
+		} else {
+			err = errors.New(apiErr.Error)
 		}
 
-		return errors.New(apiErr.Error)
+		if statusCode == http.StatusBadRequest || statusCode == http.StatusUnprocessableEntity {
+			return &ValidationError{Field: apiErr.Field, Message: err.Error()}
+		}
+
+		return err
 	default:
 		return errors.New("something went terribly wrong")
 	}
@@ -141,5 +141,5 @@ func (f *Client) WaitForApp(ctx context.Context, name string) error {
 		return backoff.Permanent(err)
 	}
 
-	return Retry(ctx, op)
+	return f.retry(ctx, op)
 }
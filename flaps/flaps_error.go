@@ -9,6 +9,23 @@ import (
 
 var ErrFlapsNotFound = &FlapsError{ResponseStatusCode: http.StatusNotFound}
 
+// Errors for the common ways a Machines API request fails. A FlapsError
+// matches the one its response classifies as with errors.Is:
+//
+//	if errors.Is(err, flaps.ErrLeaseConflict) {
+//		// someone else is working on the machine; try again later
+//	}
+var (
+	ErrLeaseConflict          = errors.New("machine lease is held by someone else")
+	ErrMachineNotFound        = errors.New("machine not found")
+	ErrAppNotFound            = errors.New("app not found")
+	ErrInvalidStateTransition = errors.New("machine is not in a state that allows this")
+	ErrInsufficientCapacity   = errors.New("insufficient capacity to place machine")
+	ErrRateLimited            = errors.New("rate limited")
+	ErrUnauthorized           = errors.New("unauthorized")
+	ErrValidation             = errors.New("invalid request")
+)
+
 type StatusCode string
 
 const (
@@ -19,7 +36,27 @@ const (
 
 type errorResponse struct {
 	Error      string     `json:"error"`
+	Message    string     `json:"message,omitempty"`
 	StatusCode StatusCode `json:"status"`
+	Field      string     `json:"field,omitempty"`
+}
+
+// ValidationError is a request the API rejected as invalid. It's the
+// OriginalError of the FlapsError for the response, so it can be retrieved
+// with errors.As, and matches ErrValidation.
+type ValidationError struct {
+	// Field is the path of the invalid field, such as "config.guest.cpus",
+	// when the API names one.
+	Field   string
+	Message string
+}
+
+func (ve *ValidationError) Error() string {
+	return ve.Message
+}
+
+func (ve *ValidationError) Is(target error) bool {
+	return target == ErrValidation
 }
 
 type FlapsError struct {
@@ -126,7 +163,64 @@ func (fe *FlapsError) Is(target error) bool {
 		return fe.ResponseStatusCode == other.ResponseStatusCode
 	}
 
-	return false
+	return target != nil && target == fe.kind()
+}
+
+// kind classifies the failure as one of the sentinel errors, or returns nil
+// if it's none of them.
+//
+// Like IsNameTakenError, it goes by the status the API sets and the HTTP
+// status code. Some HTTP status codes cover more than one kind of failure,
+// such as a 404 for a machine, an app or a lease, and the message is read to
+// tell those apart only when the API hasn't classified the failure itself.
+func (fe *FlapsError) kind() error {
+	var resp errorResponse
+	_ = json.Unmarshal(fe.ResponseBody, &resp)
+
+	if resp.StatusCode == regionOOCapacity {
+		return ErrInsufficientCapacity
+	}
+
+	switch fe.ResponseStatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return ErrValidation
+	}
+
+	if resp.StatusCode != "" && resp.StatusCode != unknown {
+		return nil
+	}
+	msg := strings.ToLower(resp.Error + " " + resp.Message)
+
+	switch fe.ResponseStatusCode {
+	case http.StatusNotFound:
+		// Leases have their own not-found response, for a machine that
+		// isn't leased, which isn't a missing machine.
+		switch {
+		case strings.Contains(msg, "lease"):
+			return nil
+		case strings.Contains(msg, "machine"):
+			return ErrMachineNotFound
+		case strings.Contains(msg, "app"):
+			return ErrAppNotFound
+		}
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		switch {
+		case strings.Contains(msg, "lease"):
+			return ErrLeaseConflict
+		case fe.ResponseStatusCode == http.StatusPreconditionFailed && strings.Contains(msg, "insufficient"):
+			// A machine that can't be placed on its host, predating the
+			// insufficient_capacity status.
+			return ErrInsufficientCapacity
+		case fe.ResponseStatusCode == http.StatusPreconditionFailed, strings.Contains(msg, "state"):
+			return ErrInvalidStateTransition
+		}
+	}
+
+	return nil
 }
 
 func (fe *FlapsError) Unwrap() error {
@@ -105,3 +105,68 @@ func TestFlapsErrorSuggestion(t *testing.T) {
 		t.Errorf("Suggestion() = %q for an error with no status code, want empty", got)
 	}
 }
+
+func TestFlapsErrorClassification(t *testing.T) {
+	sentinels := []error{
+		ErrLeaseConflict,
+		ErrMachineNotFound,
+		ErrAppNotFound,
+		ErrInvalidStateTransition,
+		ErrInsufficientCapacity,
+		ErrRateLimited,
+		ErrUnauthorized,
+		ErrValidation,
+	}
+
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"lease held", http.StatusConflict, `{"error":"lease currently held by someone@example.com"}`, ErrLeaseConflict},
+		{"lease nonce mismatch", http.StatusPreconditionFailed, `{"error":"lease nonce mismatch"}`, ErrLeaseConflict},
+		{"machine not found", http.StatusNotFound, `{"error":"machine not found"}`, ErrMachineNotFound},
+		{"app not found", http.StatusNotFound, `{"error":"app not found"}`, ErrAppNotFound},
+		{"lease not found", http.StatusNotFound, `{"error":"lease not found"}`, nil},
+		{"invalid state", http.StatusPreconditionFailed, `{"error":"unable to stop machine from current state: 'created'"}`, ErrInvalidStateTransition},
+		{"capacity status code", http.StatusServiceUnavailable, `{"error":"out of capacity","status":"insufficient_capacity"}`, ErrInsufficientCapacity},
+		{"placement failure", http.StatusPreconditionFailed, `{"error":"could not reserve resource for machine: insufficient memory available to fulfill request"}`, ErrInsufficientCapacity},
+		{"rate limited", http.StatusTooManyRequests, `{"error":"rate limit exceeded"}`, ErrRateLimited},
+		{"unauthorized", http.StatusUnauthorized, `{"error":"unauthorized"}`, ErrUnauthorized},
+		{"forbidden", http.StatusForbidden, `{"error":"unauthorized"}`, ErrUnauthorized},
+		{"invalid config", http.StatusUnprocessableEntity, `{"error":"invalid cpu count","field":"config.guest.cpus"}`, ErrValidation},
+		{"bad request", http.StatusBadRequest, `not json`, ErrValidation},
+		{"server error", http.StatusInternalServerError, `{"error":"oops"}`, nil},
+		{"capacity in the message of a server error", http.StatusInternalServerError, `{"error":"insufficient capacity to reach the database"}`, nil},
+		{"capacity in the message of a bad request", http.StatusBadRequest, `{"error":"volume capacity must be at least 1GB"}`, ErrValidation},
+		{"unknown status", http.StatusNotFound, `{"error":"machine not found","status":"unknown"}`, ErrMachineNotFound},
+		{"classified by status", http.StatusConflict, `{"error":"name is in a conflicting state","status":"name_taken"}`, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := fmt.Errorf("failed to do the thing: %w", flapsErrorWithBody(tc.status, tc.body))
+			for _, sentinel := range sentinels {
+				if got, want := errors.Is(err, sentinel), sentinel == tc.want; got != want {
+					t.Errorf("errors.Is(err, %q) = %v, want %v", sentinel, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := fmt.Errorf("failed to launch VM: %w", flapsErrorWithBody(http.StatusUnprocessableEntity, `{"error":"invalid cpu count","field":"config.guest.cpus"}`))
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("errors.As(%v) found no *ValidationError", err)
+	}
+	if verr.Field != "config.guest.cpus" || verr.Message != "invalid cpu count" {
+		t.Errorf("ValidationError = %+v, want invalid cpu count at config.guest.cpus", verr)
+	}
+	if got, want := err.Error(), "failed to launch VM: invalid cpu count"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,266 @@
+package flaps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	fly "github.com/superfly/fly-go"
+)
+
+// defaultLeaseRefreshInterval is how often a lease is refreshed when the API
+// doesn't say when it expires.
+const defaultLeaseRefreshInterval = 10 * time.Second
+
+// minLeaseRefreshInterval keeps a lease whose expiry is already in the past
+// from being refreshed in a tight loop.
+const minLeaseRefreshInterval = 100 * time.Millisecond
+
+// WithLease runs fn while holding a lease on the machine, passing it the lease
+// nonce for use with Update, Start, Stop, Cordon and friends.
+//
+// The lease is refreshed in the background before it expires, and released
+// when fn returns, including when it panics. If a refresh fails, the context
+// passed to fn is cancelled and the refresh error is returned alongside
+// whatever fn returned. A ttl of zero uses the API's default lease TTL.
+func (f *Client) WithLease(ctx context.Context, appName, machineID string, ttl int, fn func(ctx context.Context, nonce string) error) (err error) {
+	var ttlp *int
+	if ttl > 0 {
+		ttlp = &ttl
+	}
+
+	lease, err := f.AcquireLease(ctx, appName, machineID, ttlp)
+	if err != nil {
+		return err
+	}
+	if lease.Data == nil {
+		return fmt.Errorf("failed to get lease on VM %s: no lease in response", machineID)
+	}
+	nonce := lease.Data.Nonce
+
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		f.keepLeases(leaseCtx, cancel, appName, ttlp, map[string]*fly.MachineLeaseData{machineID: lease.Data})
+	}()
+
+	defer func() {
+		cancel(nil)
+		wg.Wait()
+
+		// The caller's context may well be done by now, but the lease still
+		// has to go.
+		if rerr := f.ReleaseLease(context.WithoutCancel(ctx), appName, machineID, nonce); rerr != nil && err == nil {
+			err = fmt.Errorf("failed to release lease on VM %s: %w", machineID, rerr)
+		}
+	}()
+
+	err = fn(leaseCtx, nonce)
+
+	// Checking before the deferred cancel tells a lost lease apart from the
+	// caller's own cancellation.
+	if ctx.Err() == nil {
+		if cause := context.Cause(leaseCtx); cause != nil {
+			err = errors.Join(err, cause)
+		}
+	}
+
+	return err
+}
+
+// keepLeases refreshes a set of leases, keyed by machine ID, shortly before
+// the first of them expires until ctx is done. They're all refreshed
+// together. A refresh that fails cancels ctx with the error as the cause.
+func (f *Client) keepLeases(ctx context.Context, cancel context.CancelCauseFunc, appName string, ttl *int, leases map[string]*fly.MachineLeaseData) {
+	ids := slices.Sorted(maps.Keys(leases))
+
+	for {
+		interval := defaultLeaseRefreshInterval
+		for _, data := range leases {
+			interval = min(interval, leaseRefreshInterval(data))
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		for _, machineID := range ids {
+			var lease *fly.MachineLease
+			err := f.retry(ctx, func() error {
+				var err error
+				lease, err = f.RefreshLease(ctx, appName, machineID, ttl, leases[machineID].Nonce)
+				switch {
+				case err == nil:
+					return nil
+				case isTransient(err):
+					return err
+				default:
+					return backoff.Permanent(err)
+				}
+			})
+			if err != nil {
+				if ctx.Err() == nil {
+					cancel(fmt.Errorf("failed to refresh lease on VM %s: %w", machineID, err))
+				}
+				return
+			}
+
+			if lease.Data != nil {
+				leases[machineID] = lease.Data
+			}
+		}
+	}
+}
+
+// leaseRefreshInterval is half the time left on a lease, which leaves the
+// other half for a refresh that needs retrying.
+func leaseRefreshInterval(data *fly.MachineLeaseData) time.Duration {
+	if data.ExpiresAt == 0 {
+		return defaultLeaseRefreshInterval
+	}
+
+	return max(minLeaseRefreshInterval, time.Until(time.Unix(data.ExpiresAt, 0))/2)
+}
+
+// MachineLeases is a set of leases acquired together with AcquireLeases. The
+// leases are refreshed in the background until Release is called.
+type MachineLeases struct {
+	client  *Client
+	appName string
+	ids     []string
+	nonces  map[string]string
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+
+	releaseOnce sync.Once
+	releaseErr  error
+}
+
+// AcquireLeases leases every one of the machines, refreshing the leases
+// together until Release is called.
+//
+// Machines are leased in order of their IDs, so two callers leasing
+// overlapping sets can't each end up holding a lease the other is waiting
+// for. If any lease can't be acquired, the leases already taken are released
+// before the error is returned. A lease that's held elsewhere isn't waited
+// for: its 409 fails AcquireLeases straight away, while server errors are
+// retried. A ttl of zero uses the API's default lease TTL.
+func (f *Client) AcquireLeases(ctx context.Context, appName string, machineIDs []string, ttl int) (*MachineLeases, error) {
+	var ttlp *int
+	if ttl > 0 {
+		ttlp = &ttl
+	}
+
+	ids := slices.Clone(machineIDs)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	leases := make(map[string]*fly.MachineLeaseData, len(ids))
+	nonces := make(map[string]string, len(ids))
+	for i, id := range ids {
+		var lease *fly.MachineLease
+		err := f.retry(ctx, func() error {
+			var err error
+			lease, err = f.acquireLease(ctx, appName, id, ttlp)
+			switch {
+			case err == nil:
+				return nil
+			case isTransient(err):
+				return err
+			default:
+				return backoff.Permanent(err)
+			}
+		})
+		if err == nil && lease.Data == nil {
+			err = fmt.Errorf("failed to get lease on VM %s: no lease in response", id)
+		}
+		if err != nil {
+			rerr := f.releaseLeases(context.WithoutCancel(ctx), appName, ids[:i], nonces)
+			return nil, errors.Join(err, rerr)
+		}
+
+		leases[id] = lease.Data
+		nonces[id] = lease.Data.Nonce
+	}
+
+	l := &MachineLeases{
+		client:  f,
+		appName: appName,
+		ids:     ids,
+		nonces:  nonces,
+	}
+	l.ctx, l.cancel = context.WithCancelCause(context.WithoutCancel(ctx))
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		f.keepLeases(l.ctx, l.cancel, appName, ttlp, leases)
+	}()
+
+	return l, nil
+}
+
+// MachineIDs returns the IDs of the leased machines, in the order they were
+// leased.
+func (l *MachineLeases) MachineIDs() []string {
+	return slices.Clone(l.ids)
+}
+
+// Nonce returns the lease nonce for a machine, or "" if it isn't one of the
+// leased machines.
+func (l *MachineLeases) Nonce(machineID string) string {
+	return l.nonces[machineID]
+}
+
+// Done returns a channel that's closed when the leases can no longer be
+// relied on: a refresh failed, or they were released.
+func (l *MachineLeases) Done() <-chan struct{} {
+	return l.ctx.Done()
+}
+
+// Err returns why the leases were lost, if a refresh failed, or nil.
+func (l *MachineLeases) Err() error {
+	if cause := context.Cause(l.ctx); !errors.Is(cause, context.Canceled) {
+		return cause
+	}
+
+	return nil
+}
+
+// Release stops refreshing the leases and releases every one of them. It's
+// safe to call more than once.
+func (l *MachineLeases) Release() error {
+	l.releaseOnce.Do(func() {
+		l.cancel(context.Canceled)
+		l.wg.Wait()
+		l.releaseErr = l.client.releaseLeases(context.Background(), l.appName, l.ids, l.nonces)
+	})
+
+	return l.releaseErr
+}
+
+// releaseLeases releases the leases on the machines, in reverse order of
+// acquisition, carrying on past failures. A machine that's gone has no lease
+// left to release.
+func (f *Client) releaseLeases(ctx context.Context, appName string, ids []string, nonces map[string]string) error {
+	var errs []error
+	for _, id := range slices.Backward(ids) {
+		if err := f.ReleaseLease(ctx, appName, id, nonces[id]); err != nil && !errors.Is(err, ErrFlapsNotFound) {
+			errs = append(errs, fmt.Errorf("failed to release lease on VM %s: %w", id, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,282 @@
+package flaps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+)
+
+// leaseServer is a Machines API that only knows about leases. Leases last
+// for ttl, whatever the client asks for.
+type leaseServer struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	held     map[string]string // machine ID -> nonce
+	refresh  map[string]int
+	acquired []string
+	released []string
+	// failRefresh makes refreshes fail with this status when set.
+	failRefresh int
+	// conflict makes acquiring a lease on these machines fail with a 409.
+	conflict map[string]bool
+}
+
+func newLeaseServer(t *testing.T, ttl time.Duration) (*leaseServer, *Client) {
+	t.Helper()
+
+	ls := &leaseServer{
+		ttl:      ttl,
+		held:     make(map[string]string),
+		refresh:  make(map[string]int),
+		conflict: make(map[string]bool),
+	}
+	server := httptest.NewServer(ls)
+	t.Cleanup(server.Close)
+
+	t.Setenv("FLY_FLAPS_BASE_URL", server.URL)
+	client, err := NewWithOptions(context.Background(), NewClientOpts{})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+
+	return ls, client
+}
+
+func (ls *leaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	// /v1/apps/<app>/machines/<id>/lease
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 6 || parts[5] != "lease" {
+		http.Error(w, "unexpected path", http.StatusNotFound)
+		return
+	}
+	id := parts[4]
+	nonce := r.Header.Get(NonceHeader)
+
+	switch {
+	case r.Method == http.MethodDelete:
+		if ls.held[id] != nonce {
+			http.Error(w, `{"error":"lease nonce mismatch"}`, http.StatusConflict)
+			return
+		}
+		delete(ls.held, id)
+		ls.released = append(ls.released, id)
+	case r.Method == http.MethodPost && nonce != "":
+		if ls.failRefresh != 0 {
+			http.Error(w, `{"error":"refresh failed"}`, ls.failRefresh)
+			return
+		}
+		ls.refresh[id]++
+		ls.writeLease(w, nonce)
+	case r.Method == http.MethodPost:
+		if _, ok := ls.held[id]; ok || ls.conflict[id] {
+			http.Error(w, `{"error":"lease currently held"}`, http.StatusConflict)
+			return
+		}
+		ls.held[id] = "nonce-" + id
+		ls.acquired = append(ls.acquired, id)
+		ls.writeLease(w, ls.held[id])
+	default:
+		http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+	}
+}
+
+func (ls *leaseServer) writeLease(w http.ResponseWriter, nonce string) {
+	_ = json.NewEncoder(w).Encode(fly.MachineLease{
+		Status: "success",
+		Data: &fly.MachineLeaseData{
+			Nonce:     nonce,
+			ExpiresAt: time.Now().Add(ls.ttl).Unix(),
+		},
+	})
+}
+
+func (ls *leaseServer) refreshes(id string) int {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.refresh[id]
+}
+
+func (ls *leaseServer) isHeld(id string) bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	_, ok := ls.held[id]
+	return ok
+}
+
+func TestWithLease(t *testing.T) {
+	ls, client := newLeaseServer(t, time.Second)
+
+	err := client.WithLease(context.Background(), "my-app", "m1", 0, func(ctx context.Context, nonce string) error {
+		if nonce != "nonce-m1" {
+			return fmt.Errorf("nonce = %q, want %q", nonce, "nonce-m1")
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for ls.refreshes("m1") < 2 {
+			if time.Now().After(deadline) {
+				return errors.New("lease was not refreshed")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithLease() error = %v", err)
+	}
+
+	if ls.isHeld("m1") {
+		t.Fatal("lease was not released")
+	}
+}
+
+func TestWithLeaseReleasesOnPanic(t *testing.T) {
+	ls, client := newLeaseServer(t, time.Minute)
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("recover() = %v, want %q", r, "boom")
+			}
+		}()
+
+		_ = client.WithLease(context.Background(), "my-app", "m1", 0, func(ctx context.Context, nonce string) error {
+			panic("boom")
+		})
+	}()
+
+	if ls.isHeld("m1") {
+		t.Fatal("lease was not released")
+	}
+}
+
+func TestWithLeaseCancelsOnRefreshFailure(t *testing.T) {
+	ls, client := newLeaseServer(t, time.Second)
+	ls.failRefresh = http.StatusConflict
+
+	err := client.WithLease(context.Background(), "my-app", "m1", 0, func(ctx context.Context, nonce string) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return errors.New("context was not cancelled")
+		}
+	})
+	if err == nil || !strings.Contains(err.Error(), "failed to refresh lease on VM m1") {
+		t.Fatalf("WithLease() error = %v, want a refresh failure", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("WithLease() error = %v, want it to carry the callback's error", err)
+	}
+	if ls.isHeld("m1") {
+		t.Fatal("lease was not released")
+	}
+}
+
+func TestAcquireLeases(t *testing.T) {
+	ls, client := newLeaseServer(t, time.Second)
+
+	leases, err := client.AcquireLeases(context.Background(), "my-app", []string{"m3", "m1", "m2", "m1"}, 0)
+	if err != nil {
+		t.Fatalf("AcquireLeases() error = %v", err)
+	}
+
+	want := []string{"m1", "m2", "m3"}
+	if got := leases.MachineIDs(); !slices.Equal(got, want) {
+		t.Fatalf("MachineIDs() = %v, want %v", got, want)
+	}
+	for _, id := range want {
+		if got := leases.Nonce(id); got != "nonce-"+id {
+			t.Fatalf("Nonce(%q) = %q, want %q", id, got, "nonce-"+id)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ls.refreshes("m1") == 0 || ls.refreshes("m2") == 0 || ls.refreshes("m3") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("leases were not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := leases.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if err := leases.Release(); err != nil {
+		t.Fatalf("second Release() error = %v", err)
+	}
+	if err := leases.Err(); err != nil {
+		t.Fatalf("Err() = %v after Release, want nil", err)
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if !slices.Equal(ls.acquired, want) {
+		t.Fatalf("acquired = %v, want %v", ls.acquired, want)
+	}
+	if want := []string{"m3", "m2", "m1"}; !slices.Equal(ls.released, want) {
+		t.Fatalf("released = %v, want %v", ls.released, want)
+	}
+}
+
+func TestAcquireLeasesRollsBackOnConflict(t *testing.T) {
+	ls, client := newLeaseServer(t, time.Minute)
+	ls.conflict["m3"] = true
+
+	start := time.Now()
+	_, err := client.AcquireLeases(context.Background(), "my-app", []string{"m1", "m2", "m3", "m4"}, 0)
+	if !errors.Is(err, &FlapsError{ResponseStatusCode: http.StatusConflict}) {
+		t.Fatalf("AcquireLeases() error = %v, want a lease conflict on m3", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("AcquireLeases() took %s, want the conflict not retried", elapsed)
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if len(ls.held) != 0 {
+		t.Fatalf("held = %v, want every lease rolled back", ls.held)
+	}
+	if want := []string{"m1", "m2"}; !slices.Equal(ls.acquired, want) {
+		t.Fatalf("acquired = %v, want %v", ls.acquired, want)
+	}
+	if want := []string{"m2", "m1"}; !slices.Equal(ls.released, want) {
+		t.Fatalf("released = %v, want %v", ls.released, want)
+	}
+}
+
+func TestAcquireLeasesReportsLostLeases(t *testing.T) {
+	ls, client := newLeaseServer(t, time.Second)
+	ls.failRefresh = http.StatusConflict
+
+	leases, err := client.AcquireLeases(context.Background(), "my-app", []string{"m1", "m2"}, 0)
+	if err != nil {
+		t.Fatalf("AcquireLeases() error = %v", err)
+	}
+	defer leases.Release()
+
+	select {
+	case <-leases.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done() was not closed after a failed refresh")
+	}
+	if err := leases.Err(); err == nil || !strings.Contains(err.Error(), "failed to refresh lease on VM m1") {
+		t.Fatalf("Err() = %v, want a refresh failure", err)
+	}
+}
@@ -0,0 +1,369 @@
+package flaps
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+)
+
+// defaultResyncInterval is how often a MachineCache lists the app's machines
+// again unless told otherwise.
+const defaultResyncInterval = time.Minute
+
+// MachineCacheOptions configure a MachineCache.
+type MachineCacheOptions struct {
+	// ResyncInterval is how often the cache lists the app's machines again,
+	// which is how it learns of new machines and of changes that don't
+	// change a machine's state, such as to its metadata. It defaults to a
+	// minute.
+	ResyncInterval time.Duration
+}
+
+// MachineCache keeps the machines of an app in memory, so they can be looked
+// up without a request each time.
+//
+// It lists the machines once, then watches each of them as WatchMachine
+// does, and lists them again every ResyncInterval. The machines it returns
+// are shared, and must not be modified.
+type MachineCache struct {
+	client  *Client
+	appName string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.RWMutex
+	machines map[string]*fly.Machine
+	watches  map[string]context.CancelFunc
+	err      error
+	// queue holds the changes yet to be delivered, in the order they
+	// happened, and delivering is set while they're being delivered.
+	queue      []queuedChange
+	delivering bool
+
+	subsMu sync.Mutex
+	subs   map[*func(MachineChange)]struct{}
+}
+
+// MachineChange is a change to a machine in a MachineCache. Old is nil for a
+// machine that was added, and New is nil for one that was removed.
+type MachineChange struct {
+	Old, New *fly.Machine
+}
+
+// NewMachineCache lists the app's machines and keeps them up to date in the
+// background until Close is called.
+func (f *Client) NewMachineCache(ctx context.Context, appName string, opts MachineCacheOptions) (*MachineCache, error) {
+	machines, err := f.List(ctx, appName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &MachineCache{
+		client:   f,
+		appName:  appName,
+		machines: make(map[string]*fly.Machine),
+		watches:  make(map[string]context.CancelFunc),
+		subs:     make(map[*func(MachineChange)]struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	c.sync(machines)
+
+	resync := opts.ResyncInterval
+	if resync <= 0 {
+		resync = defaultResyncInterval
+	}
+	c.wg.Go(func() { c.resyncEvery(resync) })
+
+	return c, nil
+}
+
+// Close stops keeping the machines up to date.
+func (c *MachineCache) Close() {
+	c.cancel()
+	c.wg.Wait()
+}
+
+// Get returns the machine with the given ID, if the cache has it.
+func (c *MachineCache) Get(machineID string) (*fly.Machine, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	m, ok := c.machines[machineID]
+	return m, ok
+}
+
+// List returns the machines that match filter, ordered by ID.
+func (c *MachineCache) List(filter MachineFilter) []*fly.Machine {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var out []*fly.Machine
+	for _, id := range slices.Sorted(maps.Keys(c.machines)) {
+		if m := c.machines[id]; filter.Match(m) {
+			out = append(out, m)
+		}
+	}
+
+	return out
+}
+
+// Err returns the error of the last resync, or nil if it succeeded.
+func (c *MachineCache) Err() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.err
+}
+
+// Subscribe calls fn with each change to the machines from now on, until the
+// returned function is called. Changes are delivered one at a time, in the
+// order they happened, on a goroutine of their own, so fn shouldn't block for
+// long. fn may use the cache, Close it, and subscribe and unsubscribe, itself
+// included.
+func (c *MachineCache) Subscribe(fn func(MachineChange)) (unsubscribe func()) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	key := &fn
+	c.subs[key] = struct{}{}
+
+	return func() {
+		c.subsMu.Lock()
+		defer c.subsMu.Unlock()
+		delete(c.subs, key)
+	}
+}
+
+// MachineFilter selects machines by the Machine helpers. The zero value
+// matches every machine.
+type MachineFilter struct {
+	// ProcessGroup matches machines in the process group.
+	ProcessGroup string
+	// Region matches machines in the region.
+	Region string
+	// States matches machines in any of the states.
+	States []string
+	// Metadata matches machines that have all of the metadata.
+	Metadata map[string]string
+	// Active matches only machines that aren't being destroyed, per
+	// Machine.IsActive.
+	Active bool
+	// Platform matches only machines managed by the platform, per
+	// Machine.IsFlyAppsPlatform.
+	Platform bool
+}
+
+// Match reports whether m matches the filter.
+func (f MachineFilter) Match(m *fly.Machine) bool {
+	switch {
+	case f.ProcessGroup != "" && !m.HasProcessGroup(f.ProcessGroup):
+		return false
+	case f.Region != "" && m.Region != f.Region:
+		return false
+	case len(f.States) > 0 && !slices.Contains(f.States, m.State):
+		return false
+	case f.Active && !m.IsActive():
+		return false
+	case f.Platform && !m.IsFlyAppsPlatform():
+		return false
+	}
+	for k, v := range f.Metadata {
+		if m.GetMetadataByKey(k) != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *MachineCache) resyncEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		machines, err := c.client.List(c.ctx, c.appName, "")
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		if err == nil {
+			c.sync(machines)
+		}
+	}
+}
+
+// sync replaces the cached machines with a fresh list of them, watching the
+// new ones, and the ones whose watch has ended, and no longer watching the
+// ones that are gone. A listed machine older than the cached one, which a
+// watch updated while the list was made, is left as cached.
+func (c *MachineCache) sync(machines []*fly.Machine) {
+	listed := make(map[string]*fly.Machine, len(machines))
+	for _, m := range machines {
+		if m.State != fly.MachineStateDestroyed {
+			listed[m.ID] = m
+		}
+	}
+
+	for id := range c.snapshotIDs() {
+		if _, ok := listed[id]; !ok {
+			c.set(id, nil, nil)
+		}
+	}
+	for id, m := range listed {
+		c.set(id, m, nil)
+
+		c.mu.Lock()
+		if _, ok := c.watches[id]; !ok && c.ctx.Err() == nil {
+			ctx, cancel := context.WithCancel(c.ctx)
+			c.watches[id] = cancel
+			c.wg.Go(func() { c.watch(ctx, id, m) })
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *MachineCache) snapshotIDs() map[string]struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ids := make(map[string]struct{}, len(c.machines))
+	for id := range c.machines {
+		ids[id] = struct{}{}
+	}
+
+	return ids
+}
+
+func (c *MachineCache) watch(ctx context.Context, id string, m *fly.Machine) {
+	err := c.client.watchMachine(ctx, c.appName, id, m, func(m *fly.Machine, _ []*fly.MachineEvent) error {
+		if m != nil && m.State == fly.MachineStateDestroyed {
+			m = nil
+		}
+		c.set(id, m, ctx)
+		return ctx.Err()
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		return
+	}
+	// The watch is over, for good or because it failed. The next resync
+	// starts another if the machine is still around.
+	if cancel, ok := c.watches[id]; ok {
+		cancel()
+		delete(c.watches, id)
+	}
+}
+
+// set stores the latest version of a machine, or removes it if m is nil,
+// and tells the subscribers if it changed. An update from a watch that has
+// since been cancelled is dropped, as is a listed machine that's older than
+// the cached one.
+func (c *MachineCache) set(id string, m *fly.Machine, watch context.Context) {
+	c.mu.Lock()
+	if watch != nil && watch.Err() != nil {
+		c.mu.Unlock()
+		return
+	}
+	old := c.machines[id]
+	if watch == nil && m != nil && old != nil && olderThan(m, old) {
+		c.mu.Unlock()
+		return
+	}
+	if m == nil {
+		delete(c.machines, id)
+		if cancel, ok := c.watches[id]; ok && watch == nil {
+			cancel()
+			delete(c.watches, id)
+		}
+	} else {
+		c.machines[id] = m
+	}
+
+	if old == nil && m == nil || reflect.DeepEqual(old, m) {
+		c.mu.Unlock()
+		return
+	}
+	c.subsMu.Lock()
+	subs := slices.Collect(maps.Keys(c.subs))
+	c.subsMu.Unlock()
+	if len(subs) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	c.queue = append(c.queue, queuedChange{change: MachineChange{Old: old, New: m}, subs: subs})
+	start := !c.delivering
+	c.delivering = true
+	c.mu.Unlock()
+
+	// The changes are delivered on a goroutine of their own, outside the
+	// wait group, so that a subscriber can use the cache, and even Close
+	// it, without waiting on itself.
+	if start {
+		go c.deliver()
+	}
+}
+
+// queuedChange is a change, and the subscribers there were when it happened.
+type queuedChange struct {
+	change MachineChange
+	subs   []*func(MachineChange)
+}
+
+// deliver calls the subscribers with each queued change, in order, until the
+// queue is empty. A subscriber that has unsubscribed since a change happened
+// isn't called with it.
+func (c *MachineCache) deliver() {
+	for {
+		c.mu.Lock()
+		if len(c.queue) == 0 {
+			c.delivering = false
+			c.mu.Unlock()
+			return
+		}
+		q := c.queue[0]
+		c.queue = c.queue[1:]
+		c.mu.Unlock()
+
+		for _, fn := range q.subs {
+			c.subsMu.Lock()
+			_, ok := c.subs[fn]
+			c.subsMu.Unlock()
+			if ok {
+				(*fn)(q.change)
+			}
+		}
+	}
+}
+
+// olderThan reports whether m was last updated before cached was.
+func olderThan(m, cached *fly.Machine) bool {
+	updated, err := time.Parse(time.RFC3339Nano, m.UpdatedAt)
+	if err != nil {
+		return false
+	}
+	cachedUpdated, err := time.Parse(time.RFC3339Nano, cached.UpdatedAt)
+	if err != nil {
+		return false
+	}
+
+	return updated.Before(cachedUpdated)
+}
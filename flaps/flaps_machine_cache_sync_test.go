@@ -0,0 +1,32 @@
+package flaps
+
+import (
+	"context"
+	"testing"
+
+	fly "github.com/superfly/fly-go"
+)
+
+func TestMachineCacheSyncKeepsNewerMachines(t *testing.T) {
+	c := &MachineCache{
+		machines: make(map[string]*fly.Machine),
+		watches:  make(map[string]context.CancelFunc),
+		subs:     make(map[*func(MachineChange)]struct{}),
+	}
+	// With the cache closed, syncing doesn't start any watches.
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.cancel()
+
+	c.set("m1", &fly.Machine{ID: "m1", State: fly.MachineStateStopped, UpdatedAt: "2024-05-01T12:00:05Z"}, nil)
+
+	// The list was made before the watch saw the machine stop.
+	c.sync([]*fly.Machine{{ID: "m1", State: fly.MachineStateStarted, UpdatedAt: "2024-05-01T12:00:00Z"}})
+	if m, _ := c.Get("m1"); m.State != fly.MachineStateStopped {
+		t.Fatalf("Get() after a stale list = %s, want the cached stopped machine", m.State)
+	}
+
+	c.sync([]*fly.Machine{{ID: "m1", State: fly.MachineStateStarted, UpdatedAt: "2024-05-01T12:00:10Z"}})
+	if m, _ := c.Get("m1"); m.State != fly.MachineStateStarted {
+		t.Fatalf("Get() after a newer list = %s, want started", m.State)
+	}
+}
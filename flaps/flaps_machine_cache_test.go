@@ -0,0 +1,219 @@
+package flaps_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/flaps"
+	"github.com/superfly/fly-go/flaps/flapstest"
+)
+
+func TestMachineCache(t *testing.T) {
+	srv := flapstest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddApp("my-app", "personal")
+
+	web := srv.AddMachine("my-app", &fly.Machine{Region: "ord", Config: &fly.MachineConfig{
+		Metadata: map[string]string{fly.MachineConfigMetadataKeyFlyProcessGroup: "web"},
+	}})
+	worker := srv.AddMachine("my-app", &fly.Machine{Region: "ams", Config: &fly.MachineConfig{
+		Metadata: map[string]string{fly.MachineConfigMetadataKeyFlyProcessGroup: "worker"},
+	}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := srv.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	cache, err := client.NewMachineCache(ctx, "my-app", flaps.MachineCacheOptions{ResyncInterval: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewMachineCache() error = %v", err)
+	}
+	defer cache.Close()
+
+	if got := ids(cache.List(flaps.MachineFilter{})); len(got) != 2 {
+		t.Fatalf("List() = %v, want both machines", got)
+	}
+	if got := ids(cache.List(flaps.MachineFilter{ProcessGroup: "web"})); len(got) != 1 || got[0] != web.ID {
+		t.Errorf("List(web) = %v, want [%s]", got, web.ID)
+	}
+	if got := ids(cache.List(flaps.MachineFilter{Region: "ams", States: []string{fly.MachineStateStarted}})); len(got) != 1 || got[0] != worker.ID {
+		t.Errorf("List(ams, started) = %v, want [%s]", got, worker.ID)
+	}
+
+	changes := make(chan flaps.MachineChange, 16)
+	unsubscribe := cache.Subscribe(func(c flaps.MachineChange) { changes <- c })
+	defer unsubscribe()
+
+	// A state change is picked up by the machine's watch.
+	srv.SetMachineState("my-app", worker.ID, fly.MachineStateStopped)
+	c := nextChange(t, changes)
+	if c.Old == nil || c.New == nil || c.New.ID != worker.ID || c.New.State != fly.MachineStateStopped {
+		t.Fatalf("change = %+v, want %s stopped", c, worker.ID)
+	}
+	if m, ok := cache.Get(worker.ID); !ok || m.State != fly.MachineStateStopped {
+		t.Errorf("Get(%s) = %+v, %v, want stopped", worker.ID, m, ok)
+	}
+	if got := ids(cache.List(flaps.MachineFilter{States: []string{fly.MachineStateStarted}})); len(got) != 1 || got[0] != web.ID {
+		t.Errorf("List(started) = %v, want [%s]", got, web.ID)
+	}
+
+	// A new machine is picked up by a resync.
+	added := srv.AddMachine("my-app", &fly.Machine{Region: "ord"})
+	c = nextChange(t, changes)
+	if c.Old != nil || c.New == nil || c.New.ID != added.ID {
+		t.Fatalf("change = %+v, want %s added", c, added.ID)
+	}
+
+	// A destroyed machine is dropped.
+	srv.SetMachineState("my-app", web.ID, fly.MachineStateDestroyed)
+	c = nextChange(t, changes)
+	if c.Old == nil || c.Old.ID != web.ID || c.New != nil {
+		t.Fatalf("change = %+v, want %s removed", c, web.ID)
+	}
+	if _, ok := cache.Get(web.ID); ok {
+		t.Errorf("Get(%s) found a destroyed machine", web.ID)
+	}
+	if err := cache.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+}
+
+func TestMachineFilterMatch(t *testing.T) {
+	m := &fly.Machine{
+		State:  fly.MachineStateStarted,
+		Region: "ord",
+		Config: &fly.MachineConfig{Metadata: map[string]string{
+			fly.MachineConfigMetadataKeyFlyPlatformVersion: fly.MachineFlyPlatformVersion2,
+			fly.MachineConfigMetadataKeyFlyProcessGroup:    "web",
+			"role": "primary",
+		}},
+	}
+
+	tests := []struct {
+		name   string
+		filter flaps.MachineFilter
+		want   bool
+	}{
+		{"zero", flaps.MachineFilter{}, true},
+		{"process group", flaps.MachineFilter{ProcessGroup: "web"}, true},
+		{"other process group", flaps.MachineFilter{ProcessGroup: "worker"}, false},
+		{"other region", flaps.MachineFilter{Region: "ams"}, false},
+		{"states", flaps.MachineFilter{States: []string{"stopped", "started"}}, true},
+		{"other states", flaps.MachineFilter{States: []string{"stopped"}}, false},
+		{"metadata", flaps.MachineFilter{Metadata: map[string]string{"role": "primary"}}, true},
+		{"other metadata", flaps.MachineFilter{Metadata: map[string]string{"role": "replica"}}, false},
+		{"active", flaps.MachineFilter{Active: true}, true},
+		{"platform", flaps.MachineFilter{Platform: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(m); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMachineCacheSubscribeFromCallback(t *testing.T) {
+	srv := flapstest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddApp("my-app", "personal")
+	m := srv.AddMachine("my-app", &fly.Machine{Region: "ord"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := srv.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	cache, err := client.NewMachineCache(ctx, "my-app", flaps.MachineCacheOptions{})
+	if err != nil {
+		t.Fatalf("NewMachineCache() error = %v", err)
+	}
+	defer cache.Close()
+
+	// The first subscriber hands over to a second one, from inside its
+	// callback, after the first change.
+	first := make(chan flaps.MachineChange, 16)
+	second := make(chan flaps.MachineChange, 16)
+	var unsubscribe func()
+	unsubscribe = cache.Subscribe(func(c flaps.MachineChange) {
+		first <- c
+		unsubscribe()
+		cache.Subscribe(func(c flaps.MachineChange) { second <- c })
+	})
+
+	srv.SetMachineState("my-app", m.ID, fly.MachineStateStopped)
+	if c := nextChange(t, first); c.New == nil || c.New.State != fly.MachineStateStopped {
+		t.Fatalf("first change = %+v, want %s stopped", c, m.ID)
+	}
+
+	srv.SetMachineState("my-app", m.ID, fly.MachineStateStarted)
+	if c := nextChange(t, second); c.New == nil || c.New.State != fly.MachineStateStarted {
+		t.Fatalf("second change = %+v, want %s started", c, m.ID)
+	}
+	select {
+	case c := <-first:
+		t.Fatalf("unsubscribed callback got %+v", c)
+	default:
+	}
+}
+
+func TestMachineCacheCloseFromCallback(t *testing.T) {
+	srv := flapstest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddApp("my-app", "personal")
+	m := srv.AddMachine("my-app", &fly.Machine{Region: "ord"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := srv.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	cache, err := client.NewMachineCache(ctx, "my-app", flaps.MachineCacheOptions{})
+	if err != nil {
+		t.Fatalf("NewMachineCache() error = %v", err)
+	}
+
+	closed := make(chan struct{})
+	cache.Subscribe(func(c flaps.MachineChange) {
+		cache.Get(m.ID)
+		cache.Close()
+		close(closed)
+	})
+
+	srv.SetMachineState("my-app", m.ID, fly.MachineStateStopped)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() from a subscriber didn't return")
+	}
+}
+
+func ids(machines []*fly.Machine) []string {
+	var out []string
+	for _, m := range machines {
+		out = append(out, m.ID)
+	}
+	return out
+}
+
+func nextChange(t *testing.T, changes <-chan flaps.MachineChange) flaps.MachineChange {
+	t.Helper()
+
+	select {
+	case c := <-changes:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a change")
+		return flaps.MachineChange{}
+	}
+}
@@ -146,26 +146,15 @@ func (f *Client) GetMany(ctx context.Context, appName string, machineIDs []strin
 	return machines, nil
 }
 
+// List returns the app's machines that haven't been destroyed, only those in
+// state if it's given. See ListWithOptions for other filters.
 func (f *Client) List(ctx context.Context, appName, state string) ([]*fly.Machine, error) {
-	getEndpoint := ""
-
+	var opts ListMachinesOptions
 	if state != "" {
-		getEndpoint = fmt.Sprintf("?%s", state)
+		opts.States = []string{state}
 	}
 
-	out := make([]*fly.Machine, 0)
-	ctx = contextWithAction(ctx, machineList)
-
-	err := f.sendRequestMachines(ctx, appName, http.MethodGet, getEndpoint, nil, &out, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list VMs: %w", err)
-	}
-
-	for _, m := range out {
-		m.RemoveCompatChecks()
-	}
-
-	return out, nil
+	return f.ListWithOptions(ctx, appName, opts)
 }
 
 // ListActive returns only non-destroyed that aren't in a reserved process group.
@@ -278,6 +267,25 @@ func (f *Client) FindLease(ctx context.Context, appName, machineID string) (*fly
 }
 
 func (f *Client) AcquireLease(ctx context.Context, appName, machineID string, ttl *int) (*fly.MachineLease, error) {
+	var out *fly.MachineLease
+	op := func() error {
+		lease, err := f.acquireLease(ctx, appName, machineID, ttl)
+		if err != nil {
+			return err
+		}
+		out = lease
+
+		return nil
+	}
+	if err := f.retry(ctx, op); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// acquireLease makes a single attempt at acquiring a lease.
+func (f *Client) acquireLease(ctx context.Context, appName, machineID string, ttl *int) (*fly.MachineLease, error) {
 	endpoint := fmt.Sprintf("/%s/lease", machineID)
 
 	if ttl != nil {
@@ -288,19 +296,9 @@ func (f *Client) AcquireLease(ctx context.Context, appName, machineID string, tt
 	ctx = contextWithAction(ctx, machineAcquireLease)
 	ctx = contextWithMachineID(ctx, machineID)
 
-	op := func() error {
-		err := f.sendRequestMachines(ctx, appName, http.MethodPost, endpoint, nil, out, nil)
-		if err != nil {
-			return fmt.Errorf("failed to get lease on VM %s: %w", machineID, err)
-		}
-		if ferr, ok := err.(*FlapsError); ok && slices.Contains([]int{409}, ferr.ResponseStatusCode) {
-			return err
-		}
-
-		return backoff.Permanent(err)
-	}
-	if err := Retry(ctx, op); err != nil {
-		return nil, err
+	err := f.sendRequestMachines(ctx, appName, http.MethodPost, endpoint, nil, out, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lease on VM %s: %w", machineID, err)
 	}
 
 	return out, nil
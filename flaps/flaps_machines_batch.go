@@ -0,0 +1,139 @@
+package flaps
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	fly "github.com/superfly/fly-go"
+)
+
+// defaultBatchConcurrency is how many requests a batch makes at once unless
+// told otherwise.
+const defaultBatchConcurrency = 10
+
+// BatchOptions configure an operation on many machines at once.
+type BatchOptions struct {
+	// Concurrency is the most requests in flight at once. It defaults to 10.
+	// A client's RateLimit still applies on top of it.
+	Concurrency int
+
+	// Nonce returns the lease nonce to use for a machine, or "" for none.
+	// MachineLeases.Nonce fits, for machines leased with AcquireLeases.
+	Nonce func(machineID string) string
+}
+
+// BatchResult is the outcome of a batch operation on one machine.
+type BatchResult[T any] struct {
+	MachineID string
+	Value     T
+	Err       error
+}
+
+// GetBatch gets the machines. Unlike GetMany, it carries on past machines it
+// can't get.
+//
+// Like the other batch operations, it returns a result for each machine, in
+// the order they were given, and the errors of the machines that failed
+// joined together with errors.Join, or nil if none did.
+func (f *Client) GetBatch(ctx context.Context, appName string, machineIDs []string, opts BatchOptions) ([]BatchResult[*fly.Machine], error) {
+	return runBatch(ctx, machineIDs, identity, opts, func(ctx context.Context, id, _ string) (*fly.Machine, error) {
+		return f.Get(ctx, appName, id)
+	})
+}
+
+// StartBatch starts the machines. See GetBatch.
+func (f *Client) StartBatch(ctx context.Context, appName string, machineIDs []string, opts BatchOptions) ([]BatchResult[*fly.MachineStartResponse], error) {
+	return runBatch(ctx, machineIDs, identity, opts, func(ctx context.Context, id, nonce string) (*fly.MachineStartResponse, error) {
+		return f.Start(ctx, appName, id, nonce)
+	})
+}
+
+// StopBatch stops the machines. See GetBatch.
+func (f *Client) StopBatch(ctx context.Context, appName string, inputs []fly.StopMachineInput, opts BatchOptions) ([]BatchResult[struct{}], error) {
+	return runBatch(ctx, inputs, func(in fly.StopMachineInput) string { return in.ID }, opts, func(ctx context.Context, in fly.StopMachineInput, nonce string) (struct{}, error) {
+		return struct{}{}, f.Stop(ctx, appName, in, nonce)
+	})
+}
+
+// RestartBatch restarts the machines. See GetBatch.
+func (f *Client) RestartBatch(ctx context.Context, appName string, inputs []fly.RestartMachineInput, opts BatchOptions) ([]BatchResult[struct{}], error) {
+	return runBatch(ctx, inputs, func(in fly.RestartMachineInput) string { return in.ID }, opts, func(ctx context.Context, in fly.RestartMachineInput, nonce string) (struct{}, error) {
+		return struct{}{}, f.Restart(ctx, appName, in, nonce)
+	})
+}
+
+// SuspendBatch suspends the machines. See GetBatch.
+func (f *Client) SuspendBatch(ctx context.Context, appName string, machineIDs []string, opts BatchOptions) ([]BatchResult[struct{}], error) {
+	return runBatch(ctx, machineIDs, identity, opts, func(ctx context.Context, id, nonce string) (struct{}, error) {
+		return struct{}{}, f.Suspend(ctx, appName, id, nonce)
+	})
+}
+
+// DestroyBatch destroys the machines. See GetBatch.
+func (f *Client) DestroyBatch(ctx context.Context, appName string, inputs []fly.RemoveMachineInput, opts BatchOptions) ([]BatchResult[struct{}], error) {
+	return runBatch(ctx, inputs, func(in fly.RemoveMachineInput) string { return in.ID }, opts, func(ctx context.Context, in fly.RemoveMachineInput, nonce string) (struct{}, error) {
+		return struct{}{}, f.Destroy(ctx, appName, in, nonce)
+	})
+}
+
+// UpdateBatch updates the machines, each identified by its input's ID. See
+// GetBatch.
+func (f *Client) UpdateBatch(ctx context.Context, appName string, inputs []fly.LaunchMachineInput, opts BatchOptions) ([]BatchResult[*fly.Machine], error) {
+	return runBatch(ctx, inputs, func(in fly.LaunchMachineInput) string { return in.ID }, opts, func(ctx context.Context, in fly.LaunchMachineInput, nonce string) (*fly.Machine, error) {
+		return f.Update(ctx, appName, in, nonce)
+	})
+}
+
+func identity(id string) string {
+	return id
+}
+
+// runBatch runs op on each input, opts.Concurrency at a time. Once ctx is
+// done, the inputs that haven't started fail with its error.
+func runBatch[I, T any](ctx context.Context, inputs []I, machineID func(I) string, opts BatchOptions, op func(ctx context.Context, in I, nonce string) (T, error)) ([]BatchResult[T], error) {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	results := make([]BatchResult[T], len(inputs))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, in := range inputs {
+		id := machineID(in)
+		results[i].MachineID = id
+
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		var nonce string
+		if opts.Nonce != nil {
+			nonce = opts.Nonce(id)
+		}
+
+		wg.Go(func() {
+			defer func() { <-sem }()
+			results[i].Value, results[i].Err = op(ctx, in, nonce)
+		})
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
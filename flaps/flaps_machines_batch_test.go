@@ -0,0 +1,103 @@
+package flaps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+)
+
+func TestBatchOperations(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		// /v1/apps/my-app/machines/{id}[/action]
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/apps/my-app/machines/"), "/")
+		id := parts[0]
+		switch {
+		case id == "missing":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"machine not found"}`)
+		case r.Method != http.MethodGet && r.Header.Get(NonceHeader) != "nonce-"+id:
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"error":"machine is leased by someone else"}`)
+		default:
+			fmt.Fprintf(w, `{"id":%q,"state":"started"}`, id)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewWithOptions(context.Background(), NewClientOpts{BaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+	ctx := context.Background()
+
+	ids := make([]string, 20)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("m%d", i)
+	}
+	ids[7] = "missing"
+
+	results, err := client.GetBatch(ctx, "my-app", ids, BatchOptions{Concurrency: 4})
+	if !errors.Is(err, ErrMachineNotFound) {
+		t.Errorf("GetBatch() error = %v, want ErrMachineNotFound", err)
+	}
+	if got := maxInFlight.Load(); got != 4 {
+		t.Errorf("%d requests were in flight at once, want 4", got)
+	}
+	for i, r := range results {
+		if r.MachineID != ids[i] {
+			t.Errorf("results[%d].MachineID = %s, want %s", i, r.MachineID, ids[i])
+		}
+		if got, want := r.Err != nil, ids[i] == "missing"; got != want {
+			t.Errorf("results[%d].Err = %v", i, r.Err)
+		}
+		if r.Err == nil && r.Value.ID != ids[i] {
+			t.Errorf("results[%d].Value = %+v", i, r.Value)
+		}
+	}
+
+	inputs := []fly.StopMachineInput{{ID: "m1"}, {ID: "m2"}, {ID: "m3"}}
+	nonce := func(id string) string {
+		if id == "m2" {
+			return ""
+		}
+		return "nonce-" + id
+	}
+	stops, err := client.StopBatch(ctx, "my-app", inputs, BatchOptions{Nonce: nonce})
+	if !errors.Is(err, ErrLeaseConflict) {
+		t.Errorf("StopBatch() error = %v, want ErrLeaseConflict", err)
+	}
+	if stops[0].Err != nil || stops[1].Err == nil || stops[2].Err != nil {
+		t.Errorf("StopBatch() results = %+v, want only m2 to fail", stops)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	results, err = client.GetBatch(ctx, "my-app", ids, BatchOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("GetBatch() with a cancelled context error = %v", err)
+	}
+	for _, r := range results {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("result for %s = %v, want context.Canceled", r.MachineID, r.Err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package flaps
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"net/http"
+
+	"github.com/google/go-querystring/query"
+	fly "github.com/superfly/fly-go"
+)
+
+// ListMachinesOptions filter the machines ListWithOptions returns.
+// The zero value lists every machine that hasn't been destroyed.
+type ListMachinesOptions struct {
+	// States only lists machines in one of the states.
+	States []string `url:"state,omitempty"`
+	// Region only lists machines in the region.
+	Region string `url:"region,omitempty"`
+	// Metadata only lists machines that have all of the metadata keys set to
+	// the given values.
+	Metadata map[string]string `url:"-"`
+	// IncludeDeleted lists destroyed machines too.
+	IncludeDeleted bool `url:"include_deleted,omitempty"`
+	// Summary leaves out most of each machine's config and its events, which
+	// makes listing large apps cheaper.
+	Summary bool `url:"summary,omitempty"`
+}
+
+func (opts ListMachinesOptions) querystring() (string, error) {
+	qs, err := query.Values(opts)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range opts.Metadata {
+		qs.Set("metadata."+k, v)
+	}
+	if len(qs) == 0 {
+		return "", nil
+	}
+
+	return "?" + qs.Encode(), nil
+}
+
+// ListWithOptions returns the app's machines that match opts.
+func (f *Client) ListWithOptions(ctx context.Context, appName string, opts ListMachinesOptions) ([]*fly.Machine, error) {
+	qs, err := opts.querystring()
+	if err != nil {
+		return nil, fmt.Errorf("error making query string for list request: %w", err)
+	}
+
+	out := make([]*fly.Machine, 0)
+	ctx = contextWithAction(ctx, machineList)
+
+	if err := f.sendRequestMachines(ctx, appName, http.MethodGet, qs, nil, &out, nil); err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	for _, m := range out {
+		m.RemoveCompatChecks()
+	}
+
+	return out, nil
+}
+
+// ListAll iterates over the app's machines that match opts. The API lists
+// them all at once, so they're fetched with a single request when the
+// iteration starts, and yielded one at a time from there.
+//
+// If the request fails, nothing is yielded, and the returned function reports
+// the error once the iteration is over.
+//
+//	machines, errf := client.ListAll(ctx, appName, flaps.ListMachinesOptions{Region: "ord"})
+//	for m := range machines {
+//		...
+//	}
+//	if err := errf(); err != nil {
+//		...
+//	}
+func (f *Client) ListAll(ctx context.Context, appName string, opts ListMachinesOptions) (iter.Seq[*fly.Machine], func() error) {
+	var err error
+
+	seq := func(yield func(*fly.Machine) bool) {
+		var machines []*fly.Machine
+		machines, err = f.ListWithOptions(ctx, appName, opts)
+		if err != nil {
+			return
+		}
+		for _, m := range machines {
+			if !yield(m) {
+				return
+			}
+		}
+	}
+
+	return seq, func() error { return err }
+}
@@ -0,0 +1,59 @@
+package flaps
+
+import (
+	"context"
+	"net/url"
+	"testing"
+)
+
+func TestListWithOptionsQuery(t *testing.T) {
+	recorder := &roundTripRecorder{body: "[]"}
+	client := newTestClient(t, recorder)
+
+	_, err := client.ListWithOptions(context.Background(), "my-app", ListMachinesOptions{
+		States:         []string{"started", "stopped"},
+		Region:         "ord",
+		Metadata:       map[string]string{"fly_process_group": "web&worker"},
+		IncludeDeleted: true,
+		Summary:        true,
+	})
+	if err != nil {
+		t.Fatalf("ListWithOptions() error = %v", err)
+	}
+
+	if recorder.req.URL.Path != "/v1/apps/my-app/machines" {
+		t.Fatalf("path = %s, want %s", recorder.req.URL.Path, "/v1/apps/my-app/machines")
+	}
+	want := url.Values{
+		"state":                      {"started", "stopped"},
+		"region":                     {"ord"},
+		"metadata.fly_process_group": {"web&worker"},
+		"include_deleted":            {"true"},
+		"summary":                    {"true"},
+	}
+	if got := recorder.req.URL.Query().Encode(); got != want.Encode() {
+		t.Fatalf("query = %s, want %s", got, want.Encode())
+	}
+}
+
+func TestListState(t *testing.T) {
+	tests := []struct {
+		state string
+		want  string
+	}{
+		{"", ""},
+		{"started", "state=started"},
+		{"started&region=ord", "state=started%26region%3Dord"},
+	}
+	for _, tt := range tests {
+		recorder := &roundTripRecorder{body: "[]"}
+		client := newTestClient(t, recorder)
+
+		if _, err := client.List(context.Background(), "my-app", tt.state); err != nil {
+			t.Fatalf("List(%q) error = %v", tt.state, err)
+		}
+		if got := recorder.req.URL.RawQuery; got != tt.want {
+			t.Errorf("List(%q) query = %q, want %q", tt.state, got, tt.want)
+		}
+	}
+}
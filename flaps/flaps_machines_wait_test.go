@@ -5,19 +5,22 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 )
 
 type roundTripRecorder struct {
 	req *http.Request
+	// body is the response body, which is empty if unset.
+	body string
 }
 
 func (r *roundTripRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
 	r.req = req
 	return &http.Response{
 		StatusCode: http.StatusOK,
-		Body:       io.NopCloser(http.NoBody),
+		Body:       io.NopCloser(strings.NewReader(r.body)),
 		Header:     make(http.Header),
 	}, nil
 }
@@ -0,0 +1,183 @@
+package flaps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	fly "github.com/superfly/fly-go"
+)
+
+// watchStates are the states Wait can block on. Each poll waits for any of
+// them except the one the machine is already in, so it returns on the next
+// state change instead of immediately.
+var watchStates = []string{
+	fly.MachineStateStarted,
+	fly.MachineStateStopped,
+	fly.MachineStateSuspended,
+	fly.MachineStateDestroyed,
+}
+
+// watchPollTimeout is how long each of a watch's polls waits for a state
+// change. It's kept short, so that events that don't change the state are
+// picked up soon after they happen.
+const watchPollTimeout = 5 * time.Second
+
+// WatchMachine streams the events of a machine, oldest first, until ctx is
+// cancelled or the machine is destroyed. Events that already happened when the
+// watch starts are not sent.
+//
+// Under the hood this chains long-polling Wait calls, picking up from the last
+// event seen. Each poll returns when the machine changes state, so events that
+// do are sent as they happen; events that don't, such as an exit the machine
+// restarts from or a config update while it's started, are sent when the poll
+// times out after a few seconds. Polls that time out are simply re-issued, and
+// transient failures are retried. Any other failure is sent on the error
+// channel. Both channels are closed when the watch ends; cancelling ctx
+// is not reported as an error.
+func (f *Client) WatchMachine(ctx context.Context, appName, machineID string) (<-chan fly.MachineEvent, <-chan error) {
+	events := make(chan fly.MachineEvent)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(errc)
+		defer close(events)
+
+		err := f.watchMachine(ctx, appName, machineID, nil, func(_ *fly.Machine, newEvents []*fly.MachineEvent) error {
+			for _, event := range newEvents {
+				select {
+				case events <- *event:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			errc <- err
+		}
+	}()
+
+	return events, errc
+}
+
+// watchMachine calls update with the machine, and the events it's had since
+// the last call, oldest first, each time its state changes or a poll times
+// out, until it's destroyed. If it disappears instead, update is called with
+// a nil machine. The watch starts from machine if it's given, and otherwise
+// gets it first.
+func (f *Client) watchMachine(ctx context.Context, appName, machineID string, machine *fly.Machine, update func(*fly.Machine, []*fly.MachineEvent) error) error {
+	if machine == nil {
+		var err error
+		machine, err = f.Get(ctx, appName, machineID)
+		if err != nil {
+			return err
+		}
+	}
+	seen := machineEventKeys(machine.Events)
+
+	for machine.State != fly.MachineStateDestroyed {
+		opts := []WaitOption{
+			WithWaitTimeout(watchPollTimeout),
+			WithWaitStates(slices.DeleteFunc(slices.Clone(watchStates), func(s string) bool {
+				return s == machine.State
+			})...),
+		}
+		if id := latestMachineEventID(machine.Events); id != "" {
+			opts = append(opts, WithWaitFromEventID(id))
+		}
+
+		err := f.retry(ctx, func() error {
+			err := f.Wait(ctx, appName, machineID, opts...)
+			switch {
+			case err == nil, isWaitTimeout(err):
+				return nil
+			case isTransient(err):
+				return err
+			default:
+				return backoff.Permanent(err)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to watch VM %s: %w", machineID, err)
+		}
+
+		machine, err = f.Get(ctx, appName, machineID)
+		switch {
+		case errors.Is(err, ErrFlapsNotFound):
+			// The machine is gone; there is nothing left to watch.
+			return update(nil, nil)
+		case err != nil:
+			return fmt.Errorf("failed to watch VM %s: %w", machineID, err)
+		}
+
+		// Events come newest first.
+		var newEvents []*fly.MachineEvent
+		for i := len(machine.Events) - 1; i >= 0; i-- {
+			event := machine.Events[i]
+			if _, ok := seen[machineEventKey(event)]; !ok {
+				newEvents = append(newEvents, event)
+			}
+		}
+		if err := update(machine, newEvents); err != nil {
+			return err
+		}
+
+		// Only the events in the latest response can show up again, so
+		// there's no need to remember any others.
+		seen = machineEventKeys(machine.Events)
+	}
+
+	return nil
+}
+
+// isWaitTimeout reports whether err is a Wait that ran out of time before the
+// machine reached any of the requested states.
+func isWaitTimeout(err error) bool {
+	return errors.Is(err, &FlapsError{ResponseStatusCode: http.StatusRequestTimeout}) ||
+		errors.Is(err, &FlapsError{ResponseStatusCode: http.StatusGatewayTimeout})
+}
+
+// isTransient reports whether err is worth retrying as-is.
+func isTransient(err error) bool {
+	var ferr *FlapsError
+	if errors.As(err, &ferr) {
+		return ferr.ResponseStatusCode >= 500
+	}
+
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+func latestMachineEventID(events []*fly.MachineEvent) string {
+	for _, e := range events {
+		if e.ID != "" {
+			return e.ID
+		}
+	}
+
+	return ""
+}
+
+// machineEventKey identifies an event, falling back to its contents for
+// events that predate event IDs.
+func machineEventKey(e *fly.MachineEvent) string {
+	if e.ID != "" {
+		return e.ID
+	}
+
+	return fmt.Sprintf("%s/%s/%s/%d", e.Type, e.Status, e.Source, e.Timestamp)
+}
+
+func machineEventKeys(events []*fly.MachineEvent) map[string]struct{} {
+	keys := make(map[string]struct{}, len(events))
+	for _, e := range events {
+		keys[machineEventKey(e)] = struct{}{}
+	}
+
+	return keys
+}
@@ -0,0 +1,130 @@
+package flaps
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+)
+
+func TestWatchMachine(t *testing.T) {
+	snapshots := []*fly.Machine{
+		{ID: "m1", State: "started", Events: []*fly.MachineEvent{
+			{ID: "e1", Type: "start", Timestamp: 1},
+		}},
+		{ID: "m1", State: "stopped", Events: []*fly.MachineEvent{
+			{ID: "e3", Type: "exit", Timestamp: 3},
+			{ID: "e2", Type: "stop", Timestamp: 2},
+			{ID: "e1", Type: "start", Timestamp: 1},
+		}},
+		{ID: "m1", State: "destroyed", Events: []*fly.MachineEvent{
+			{ID: "e4", Type: "destroy", Timestamp: 4},
+			{ID: "e3", Type: "exit", Timestamp: 3},
+			{ID: "e2", Type: "stop", Timestamp: 2},
+		}},
+	}
+
+	var (
+		mu       sync.Mutex
+		current  int
+		timedOut bool
+		waits    []*http.Request
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/v1/apps/my-app/machines/m1":
+			_ = json.NewEncoder(w).Encode(snapshots[current])
+		case "/v1/apps/my-app/machines/m1/wait":
+			waits = append(waits, r)
+			// The first poll runs into the proxy timeout.
+			if !timedOut {
+				timedOut = true
+				w.WriteHeader(http.StatusRequestTimeout)
+				_, _ = w.Write([]byte(`{"error":"deadline_exceeded: machine failed to reach desired state"}`))
+				return
+			}
+			current++
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		default:
+			http.Error(w, "unexpected path", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("FLY_FLAPS_BASE_URL", server.URL)
+	client, err := NewWithOptions(context.Background(), NewClientOpts{})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events, errc := client.WatchMachine(ctx, "my-app", "m1")
+
+	var got []string
+	for e := range events {
+		got = append(got, e.ID)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("WatchMachine() error = %v", err)
+	}
+
+	if want := []string{"e2", "e3", "e4"}; !slices.Equal(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(waits) != 3 {
+		t.Fatalf("wait calls = %d, want 3", len(waits))
+	}
+	if got := waits[0].URL.Query().Get("timeout"); got != "5" {
+		t.Fatalf("timeout = %q, want a short poll of 5s", got)
+	}
+	if got := waits[0].URL.Query()["state"]; slices.Contains(got, "started") {
+		t.Fatalf("state = %v, want the current state left out", got)
+	}
+	if got := waits[2].URL.Query().Get("from_event_id"); got != "e3" {
+		t.Fatalf("from_event_id = %q, want %q", got, "e3")
+	}
+}
+
+func TestWatchMachineStopsOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/apps/my-app/machines/m1":
+			_, _ = w.Write([]byte(`{"id":"m1","state":"started"}`))
+		case "/v1/apps/my-app/machines/m1/wait":
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("FLY_FLAPS_BASE_URL", server.URL)
+	client, err := NewWithOptions(context.Background(), NewClientOpts{})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, errc := client.WatchMachine(ctx, "my-app", "m1")
+	cancel()
+
+	for range events {
+		t.Fatal("unexpected event")
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("WatchMachine() error = %v, want nil after cancel", err)
+	}
+}
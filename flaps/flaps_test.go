@@ -12,8 +12,10 @@ import (
 	"sync"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/superfly/client-signals/go"
+	fly "github.com/superfly/fly-go"
 )
 
 func TestNewWithOptionsSetsCookieJar(t *testing.T) {
@@ -253,6 +255,23 @@ func TestFlaps_PostDoesNotRetry(t *testing.T) {
 	}
 }
 
+func TestFlaps_AcquireLeaseDoesNotRetryInTransport(t *testing.T) {
+	tripper := &scriptedTripper{steps: []step{
+		{err: connReset()},
+	}}
+	client := newTestFlapsClient(t, tripper)
+
+	// The first attempt may have taken the lease, and a retry would only
+	// conflict with it, leaving a lease whose nonce the caller never saw.
+	ctx := contextWithAction(context.Background(), machineAcquireLease)
+	if err := client._sendRequest(ctx, http.MethodPost, "/apps/my-app/machines/m1/lease", nil, nil, nil); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if got := tripper.callCount(); got != 1 {
+		t.Fatalf("call count = %d, want 1 (no retry for acquiring a lease)", got)
+	}
+}
+
 func TestFlaps_NewRequestSetsFlyForceInstanceIDHeaderFromOpts(t *testing.T) {
 	capture := &captureTripper{}
 	client, err := NewWithOptions(context.Background(), NewClientOpts{
@@ -417,3 +436,72 @@ func TestSnakeCase(t *testing.T) {
 		}
 	}
 }
+
+func TestRetryPolicyRetriesExecWithIdempotencyKey(t *testing.T) {
+	var calls int
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"exit_code":0,"stdout":"ok"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewWithOptions(context.Background(), NewClientOpts{
+		BaseURL: server.URL,
+		RetryPolicy: &fly.RetryPolicy{
+			MaxRetries:      2,
+			InitialInterval: time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+
+	// Without a key, an exec isn't safe to repeat.
+	if _, err := client.Exec(context.Background(), "my-app", "m1", &fly.MachineExecRequest{Cmd: "ls"}); err == nil {
+		t.Fatal("Exec() without an idempotency key succeeded, want the 503")
+	}
+
+	calls, keys = 0, nil
+	ctx := WithIdempotencyKey(context.Background(), "exec-1")
+	out, err := client.Exec(ctx, "my-app", "m1", &fly.MachineExecRequest{Cmd: "ls"})
+	if err != nil {
+		t.Fatalf("Exec() error = %v", err)
+	}
+	if out.StdOut != "ok" || calls != 2 {
+		t.Fatalf("Exec() = %+v after %d requests, want ok after 2", out, calls)
+	}
+	for _, key := range keys {
+		if key != "exec-1" {
+			t.Errorf("Idempotency-Key = %q, want exec-1", key)
+		}
+	}
+}
+
+func TestRetryPolicyLimitsOwnRetries(t *testing.T) {
+	client, err := NewWithOptions(context.Background(), NewClientOpts{
+		BaseURL: "http://example.com",
+		RetryPolicy: &fly.RetryPolicy{
+			MaxRetries:      2,
+			InitialInterval: time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+
+	// Without a budget, only MaxRetries stops the retries.
+	var calls int
+	err = client.retry(context.Background(), func() error {
+		calls++
+		return errors.New("lease held")
+	})
+	if err == nil || calls != 3 {
+		t.Fatalf("retry() = %v after %d calls, want an error after 3", err, calls)
+	}
+}
@@ -0,0 +1,171 @@
+package flaps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	fly "github.com/superfly/fly-go"
+)
+
+var (
+	// ErrVolumeDestroyed is returned when a volume waited on is destroyed
+	// instead of reaching the state it was waited for.
+	ErrVolumeDestroyed = errors.New("volume is being destroyed")
+	// ErrSnapshotFailed is returned when a snapshot waited on fails.
+	ErrSnapshotFailed = errors.New("snapshot failed")
+)
+
+const (
+	// VolumeStateCreated is the state of a volume that's ready to be
+	// attached.
+	VolumeStateCreated = "created"
+
+	// SnapshotStatusCreated is the status of a snapshot that can be
+	// restored from.
+	SnapshotStatusCreated = "created"
+	snapshotStatusFailed  = "failed"
+)
+
+// errNotYet is how a poll says the resource isn't there yet.
+var errNotYet = errors.New("not yet")
+
+// ForkVolume creates a volume from a copy of another's data, configured by
+// req. The new volume is hydrating until it reaches VolumeStateCreated, which
+// WaitForVolumeState can wait for.
+func (f *Client) ForkVolume(ctx context.Context, appName, sourceVolumeID string, req fly.CreateVolumeRequest) (*fly.Volume, error) {
+	req.SourceVolumeID = &sourceVolumeID
+	req.SnapshotID = nil
+
+	out, err := f.CreateVolume(ctx, appName, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fork volume %s: %w", sourceVolumeID, err)
+	}
+
+	return out, nil
+}
+
+// RestoreVolumeFromSnapshot creates a volume from a snapshot, configured by
+// req. Like a fork, the new volume is hydrating until it reaches
+// VolumeStateCreated.
+func (f *Client) RestoreVolumeFromSnapshot(ctx context.Context, appName, snapshotID string, req fly.CreateVolumeRequest) (*fly.Volume, error) {
+	req.SnapshotID = &snapshotID
+	req.SourceVolumeID = nil
+
+	out, err := f.CreateVolume(ctx, appName, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore snapshot %s: %w", snapshotID, err)
+	}
+
+	return out, nil
+}
+
+// WaitForVolumeState polls a volume, with backoff, until it's in one of
+// states, VolumeStateCreated if none are given, and returns it.
+//
+// A volume that starts being destroyed fails the wait with
+// ErrVolumeDestroyed, unless it's one of those states that's waited for; a
+// volume that's gone entirely counts as any of them, and the volume as it was
+// last seen is returned, which is nil if it was already gone by the first
+// poll. Transient failures are retried, and the wait lasts until ctx is done.
+func (f *Client) WaitForVolumeState(ctx context.Context, appName, volumeID string, states ...string) (*fly.Volume, error) {
+	if len(states) == 0 {
+		states = []string{VolumeStateCreated}
+	}
+	waitingForDestroy := slices.ContainsFunc(states, func(s string) bool {
+		return slices.Contains(destroyedVolumeStates, s)
+	})
+
+	var volume *fly.Volume
+	err := f.pollWithBackoff(ctx, func() error {
+		v, err := f.GetVolume(ctx, appName, volumeID)
+		switch {
+		case err != nil && waitingForDestroy && errors.Is(err, ErrFlapsNotFound):
+			return nil
+		case err != nil:
+			return err
+		}
+		volume = v
+		switch {
+		case slices.Contains(states, v.State):
+			return nil
+		case slices.Contains(destroyedVolumeStates, v.State):
+			return backoff.Permanent(ErrVolumeDestroyed)
+		}
+		return errNotYet
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for volume %s in %v state: %w", volumeID, states, err)
+	}
+
+	return volume, nil
+}
+
+// WaitForSnapshot polls a volume's snapshots, with backoff, until the snapshot
+// is SnapshotStatusCreated, and returns it. A failed snapshot fails the wait
+// with ErrSnapshotFailed.
+func (f *Client) WaitForSnapshot(ctx context.Context, appName, volumeID, snapshotID string) (*fly.VolumeSnapshot, error) {
+	var snapshot *fly.VolumeSnapshot
+	err := f.pollWithBackoff(ctx, func() error {
+		snapshots, err := f.GetVolumeSnapshots(ctx, appName, volumeID)
+		if err != nil {
+			return err
+		}
+
+		i := slices.IndexFunc(snapshots, func(s fly.VolumeSnapshot) bool { return s.ID == snapshotID })
+		switch {
+		case i < 0:
+			// Snapshots are listed a little after they're taken.
+			return errNotYet
+		case snapshots[i].Status == SnapshotStatusCreated:
+			snapshot = &snapshots[i]
+			return nil
+		case snapshots[i].Status == snapshotStatusFailed:
+			return backoff.Permanent(ErrSnapshotFailed)
+		}
+		return errNotYet
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for snapshot %s of volume %s: %w", snapshotID, volumeID, err)
+	}
+
+	return snapshot, nil
+}
+
+// pollWithBackoff calls poll until it succeeds, backing off between calls
+// while it returns errNotYet or a transient error, until ctx is done. Any
+// other error ends the polling. The client's RetryPolicy sets the intervals
+// between calls, but not how long it lasts.
+func (f *Client) pollWithBackoff(ctx context.Context, poll func() error) error {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = 250 * time.Millisecond
+	bo.MaxInterval = 5 * time.Second
+	if f.retryPolicy != nil && f.retryPolicy.InitialInterval > 0 {
+		bo.InitialInterval = f.retryPolicy.InitialInterval
+	}
+	if f.retryPolicy != nil && f.retryPolicy.MaxInterval > 0 {
+		bo.MaxInterval = f.retryPolicy.MaxInterval
+	}
+	bo.MaxElapsedTime = 0
+	bo.Reset()
+
+	err := backoff.Retry(func() error {
+		err := poll()
+		if err == nil || errors.Is(err, errNotYet) || isTransient(err) {
+			return err
+		}
+		var permanent *backoff.PermanentError
+		if errors.As(err, &permanent) {
+			return err
+		}
+		return backoff.Permanent(err)
+	}, backoff.WithContext(bo, ctx))
+	if errors.Is(err, errNotYet) && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
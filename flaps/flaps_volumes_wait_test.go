@@ -0,0 +1,87 @@
+package flaps_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/flaps"
+	"github.com/superfly/fly-go/flaps/flapstest"
+)
+
+func TestVolumeLifecycleHelpers(t *testing.T) {
+	srv := flapstest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddApp("my-app", "personal")
+	srv.TransitionDelay = 100 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := srv.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	source := srv.AddVolume("my-app", &fly.Volume{Name: "data", SizeGb: 3})
+
+	fork, err := client.ForkVolume(ctx, "my-app", source.ID, fly.CreateVolumeRequest{Name: "data", Region: "ord"})
+	if err != nil {
+		t.Fatalf("ForkVolume() error = %v", err)
+	}
+	if fork.State != "hydrating" || fork.SizeGb != 3 {
+		t.Fatalf("ForkVolume() = %s, %dGB, want a hydrating 3GB volume", fork.State, fork.SizeGb)
+	}
+	fork, err = client.WaitForVolumeState(ctx, "my-app", fork.ID)
+	if err != nil {
+		t.Fatalf("WaitForVolumeState() error = %v", err)
+	}
+	if fork.State != flaps.VolumeStateCreated {
+		t.Fatalf("WaitForVolumeState() state = %s, want created", fork.State)
+	}
+
+	if err := client.CreateVolumeSnapshot(ctx, "my-app", source.ID); err != nil {
+		t.Fatalf("CreateVolumeSnapshot() error = %v", err)
+	}
+	snapshots, err := client.GetVolumeSnapshots(ctx, "my-app", source.ID)
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("GetVolumeSnapshots() = %v, %v, want one snapshot", snapshots, err)
+	}
+	snapshot, err := client.WaitForSnapshot(ctx, "my-app", source.ID, snapshots[0].ID)
+	if err != nil {
+		t.Fatalf("WaitForSnapshot() error = %v", err)
+	}
+	if snapshot.Status != flaps.SnapshotStatusCreated {
+		t.Fatalf("WaitForSnapshot() status = %s, want complete", snapshot.Status)
+	}
+
+	restored, err := client.RestoreVolumeFromSnapshot(ctx, "my-app", snapshot.ID, fly.CreateVolumeRequest{Name: "data"})
+	if err != nil {
+		t.Fatalf("RestoreVolumeFromSnapshot() error = %v", err)
+	}
+	if _, err := client.WaitForVolumeState(ctx, "my-app", restored.ID); err != nil {
+		t.Fatalf("WaitForVolumeState() error = %v", err)
+	}
+
+	if _, err := client.DeleteVolume(ctx, "my-app", fork.ID); err != nil {
+		t.Fatalf("DeleteVolume() error = %v", err)
+	}
+	if _, err := client.WaitForVolumeState(ctx, "my-app", fork.ID, "hydrating"); !errors.Is(err, flaps.ErrVolumeDestroyed) {
+		t.Fatalf("WaitForVolumeState(hydrating) of a destroyed volume error = %v, want ErrVolumeDestroyed", err)
+	}
+	if _, err := client.WaitForVolumeState(ctx, "my-app", fork.ID, "destroying", "pending_destroy"); err != nil {
+		t.Fatalf("WaitForVolumeState(pending_destroy) error = %v", err)
+	}
+	for srv.Volume("my-app", fork.ID) != nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if gone, err := client.WaitForVolumeState(ctx, "my-app", fork.ID, "destroying"); gone != nil || err != nil {
+		t.Fatalf("WaitForVolumeState(destroying) of a volume that's gone = %v, %v, want nil, nil", gone, err)
+	}
+
+	failed := srv.AddVolumeSnapshot("my-app", source.ID, &fly.VolumeSnapshot{Status: "failed"})
+	if _, err := client.WaitForSnapshot(ctx, "my-app", source.ID, failed.ID); !errors.Is(err, flaps.ErrSnapshotFailed) {
+		t.Fatalf("WaitForSnapshot() of a failed snapshot error = %v, want ErrSnapshotFailed", err)
+	}
+}
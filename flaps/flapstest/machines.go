@@ -0,0 +1,568 @@
+package flapstest
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/flaps"
+)
+
+// defaultLeaseTTL is the TTL, in seconds, of a lease acquired without one.
+const defaultLeaseTTL = 30
+
+// AddMachine adds a machine to an app, creating the app if needed, and
+// returns a copy of it as stored. An ID, instance ID and state are filled in
+// if missing.
+func (s *Server) AddMachine(appName string, m *fly.Machine) *fly.Machine {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.addApp(appName, "personal")
+	m = clone(m)
+	if m.ID == "" {
+		m.ID = s.nextID("")
+	}
+	if m.InstanceID == "" {
+		m.InstanceID = s.nextID("")
+	}
+	if m.State == "" {
+		m.State = fly.MachineStateStarted
+	}
+	if m.Config == nil {
+		m.Config = &fly.MachineConfig{}
+	}
+	a.machines[m.ID] = m
+	s.attachVolumes(a, m)
+	s.notify()
+
+	return clone(m)
+}
+
+// Machine returns a copy of a machine, or nil if there's no such machine.
+func (s *Server) Machine(appName, machineID string) *fly.Machine {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.apps[appName]; ok {
+		return clone(a.machines[machineID])
+	}
+
+	return nil
+}
+
+// Machines returns copies of every machine of an app, destroyed ones
+// included, in order of their IDs.
+func (s *Server) Machines(appName string) []*fly.Machine {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.apps[appName]
+	if !ok {
+		return nil
+	}
+
+	return a.sortedMachines(true)
+}
+
+// SetMachineState moves a machine to a new state, as if something other than
+// the API, a crash say, had changed it.
+func (s *Server) SetMachineState(appName, machineID, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.apps[appName]; ok {
+		if m, ok := a.machines[machineID]; ok {
+			s.setState(m, state, "state_changed")
+		}
+	}
+}
+
+// SetMachineChecks replaces the health check statuses reported for a
+// machine.
+func (s *Server) SetMachineChecks(appName, machineID string, checks []*fly.MachineCheckStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.apps[appName]; ok {
+		if m, ok := a.machines[machineID]; ok {
+			m.Checks = *clone(&checks)
+		}
+	}
+}
+
+// Lease returns a copy of the lease held on a machine, or nil if there's
+// none.
+func (s *Server) Lease(appName, machineID string) *fly.MachineLeaseData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.apps[appName]; ok {
+		return clone(a.activeLease(machineID))
+	}
+
+	return nil
+}
+
+func (a *app) sortedMachines(includeDeleted bool) []*fly.Machine {
+	out := []*fly.Machine{}
+	for _, m := range a.machines {
+		if includeDeleted || m.IsActive() {
+			out = append(out, clone(m))
+		}
+	}
+	slices.SortFunc(out, func(x, y *fly.Machine) int {
+		switch {
+		case x.ID < y.ID:
+			return -1
+		case x.ID > y.ID:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	return out
+}
+
+func (a *app) activeLease(machineID string) *fly.MachineLeaseData {
+	lease, ok := a.leases[machineID]
+	if !ok {
+		return nil
+	}
+	if time.Now().Unix() >= lease.ExpiresAt {
+		delete(a.leases, machineID)
+		return nil
+	}
+
+	return lease
+}
+
+// setState moves a machine to a state and records the event that did it. The
+// caller must hold the lock.
+func (s *Server) setState(m *fly.Machine, state, eventType string) {
+	m.State = state
+	m.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	s.recordEvent(m, eventType, state)
+	s.notify()
+}
+
+// transition moves a machine through an intermediate state to its final
+// one. The caller must hold the lock.
+func (s *Server) transition(m *fly.Machine, intermediate, final, eventType string) {
+	delayed := s.TransitionDelay > 0 && intermediate != ""
+	if delayed {
+		s.setState(m, intermediate, eventType)
+	}
+
+	s.after(func() {
+		// Something else moved the machine on in the meantime.
+		if delayed && m.State != intermediate {
+			return
+		}
+		s.setState(m, final, eventType)
+	})
+}
+
+func (s *Server) recordEvent(m *fly.Machine, eventType, status string) {
+	event := &fly.MachineEvent{
+		ID:        s.nextID(""),
+		Type:      eventType,
+		Status:    status,
+		Source:    "flyd",
+		Timestamp: time.Now().UnixMilli(),
+	}
+	// Newest first, like the API.
+	m.Events = append([]*fly.MachineEvent{event}, m.Events...)
+}
+
+// attachVolumes marks the volumes a machine mounts as attached to it. The
+// caller must hold the lock.
+func (s *Server) attachVolumes(a *app, m *fly.Machine) {
+	for _, mount := range m.Config.Mounts {
+		if v, ok := a.volumes[mount.Volume]; ok {
+			v.AttachedMachine = &m.ID
+		}
+	}
+}
+
+func (s *Server) detachVolumes(a *app, m *fly.Machine) {
+	for _, v := range a.volumes {
+		if v.AttachedMachine != nil && *v.AttachedMachine == m.ID {
+			v.AttachedMachine = nil
+		}
+	}
+}
+
+func (s *Server) routeMachines(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/apps/{app}/machines", s.withApp(s.listMachines))
+	mux.HandleFunc("POST /v1/apps/{app}/machines", s.withApp(s.launchMachine))
+	mux.HandleFunc("GET /v1/apps/{app}/machines/{id}", s.withMachine(s.getMachine))
+	mux.HandleFunc("POST /v1/apps/{app}/machines/{id}", s.withLeasedMachine(s.updateMachine))
+	mux.HandleFunc("DELETE /v1/apps/{app}/machines/{id}", s.withLeasedMachine(s.destroyMachine))
+	mux.HandleFunc("POST /v1/apps/{app}/machines/{id}/start", s.withLeasedMachine(s.startMachine))
+	mux.HandleFunc("POST /v1/apps/{app}/machines/{id}/stop", s.withLeasedMachine(s.stopMachine))
+	mux.HandleFunc("POST /v1/apps/{app}/machines/{id}/restart", s.withLeasedMachine(s.restartMachine))
+	mux.HandleFunc("POST /v1/apps/{app}/machines/{id}/suspend", s.withLeasedMachine(s.suspendMachine))
+	mux.HandleFunc("POST /v1/apps/{app}/machines/{id}/signal", s.withMachine(s.signalMachine))
+	mux.HandleFunc("POST /v1/apps/{app}/machines/{id}/cordon", s.withLeasedMachine(s.cordonMachine(true)))
+	mux.HandleFunc("POST /v1/apps/{app}/machines/{id}/uncordon", s.withLeasedMachine(s.cordonMachine(false)))
+	mux.HandleFunc("GET /v1/apps/{app}/machines/{id}/wait", s.waitMachine)
+	mux.HandleFunc("GET /v1/apps/{app}/machines/{id}/lease", s.withMachine(s.findLease))
+	mux.HandleFunc("POST /v1/apps/{app}/machines/{id}/lease", s.withMachine(s.acquireLease))
+	mux.HandleFunc("DELETE /v1/apps/{app}/machines/{id}/lease", s.withMachine(s.releaseLease))
+	mux.HandleFunc("GET /v1/apps/{app}/machines/{id}/metadata", s.withMachine(s.getMetadata))
+	mux.HandleFunc("POST /v1/apps/{app}/machines/{id}/metadata/{key}", s.withMachine(s.setMetadata))
+	mux.HandleFunc("DELETE /v1/apps/{app}/machines/{id}/metadata/{key}", s.withMachine(s.deleteMetadata))
+}
+
+type machineHandler func(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine)
+
+// withMachine looks up the machine named in the request path, and calls h
+// with the server locked.
+func (s *Server) withMachine(h machineHandler) http.HandlerFunc {
+	return s.withApp(func(w http.ResponseWriter, r *http.Request, a *app) {
+		m, ok := a.machines[r.PathValue("id")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "machine not found")
+			return
+		}
+
+		h(w, r, a, m)
+	})
+}
+
+// withLeasedMachine is withMachine for mutations, which are refused when
+// someone else holds a lease on the machine.
+func (s *Server) withLeasedMachine(h machineHandler) http.HandlerFunc {
+	return s.withMachine(func(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+		if lease := a.activeLease(m.ID); lease != nil && lease.Nonce != r.Header.Get(flaps.NonceHeader) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("machine %s is currently leased by %s", m.ID, lease.Owner))
+			return
+		}
+
+		h(w, r, a, m)
+	})
+}
+
+// listMachines lists the machines that match the filters in the query.
+func (s *Server) listMachines(w http.ResponseWriter, r *http.Request, a *app) {
+	q := r.URL.Query()
+	includeDeleted, _ := strconv.ParseBool(q.Get("include_deleted"))
+	summary, _ := strconv.ParseBool(q.Get("summary"))
+
+	out := []*fly.Machine{}
+	for _, m := range a.sortedMachines(includeDeleted) {
+		if !machineMatches(m, q) {
+			continue
+		}
+		if summary {
+			m.Events = nil
+			m.Checks = nil
+			m.Config = &fly.MachineConfig{Metadata: m.Config.Metadata}
+		}
+		out = append(out, m)
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func machineMatches(m *fly.Machine, q url.Values) bool {
+	if states := q["state"]; len(states) > 0 && !slices.Contains(states, m.State) {
+		return false
+	}
+	if region := q.Get("region"); region != "" && m.Region != region {
+		return false
+	}
+	for k, v := range q {
+		if key, ok := strings.CutPrefix(k, "metadata."); ok && m.GetMetadataByKey(key) != v[0] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Server) launchMachine(w http.ResponseWriter, r *http.Request, a *app) {
+	var in fly.LaunchMachineInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	m := &fly.Machine{
+		ID:         s.nextID(""),
+		Name:       in.Name,
+		Region:     in.Region,
+		InstanceID: s.nextID(""),
+		Config:     clone(in.Config),
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if m.Region == "" {
+		m.Region = "iad"
+	}
+	if m.Config == nil {
+		m.Config = &fly.MachineConfig{}
+	}
+	m.ImageRef = fly.MachineImageRef{Repository: m.Config.Image}
+	a.machines[m.ID] = m
+	s.attachVolumes(a, m)
+
+	s.setState(m, fly.MachineStateCreated, "launch")
+	if !in.SkipLaunch {
+		s.transition(m, "starting", fly.MachineStateStarted, "start")
+	}
+
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (s *Server) getMachine(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (s *Server) updateMachine(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	var in fly.LaunchMachineInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+	if !m.IsActive() {
+		writeError(w, http.StatusPreconditionFailed, fmt.Sprintf("machine %s is %s", m.ID, m.State))
+		return
+	}
+
+	s.detachVolumes(a, m)
+	if in.Config != nil {
+		m.Config = clone(in.Config)
+		m.ImageRef = fly.MachineImageRef{Repository: m.Config.Image}
+	}
+	if in.Name != "" {
+		m.Name = in.Name
+	}
+	m.InstanceID = s.nextID("")
+	s.attachVolumes(a, m)
+
+	s.setState(m, m.State, "update")
+	if !in.SkipLaunch {
+		s.transition(m, "starting", fly.MachineStateStarted, "start")
+	}
+
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (s *Server) destroyMachine(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	kill, _ := strconv.ParseBool(r.URL.Query().Get("kill"))
+	if m.State == fly.MachineStateStarted && !kill {
+		writeError(w, http.StatusPreconditionFailed, fmt.Sprintf("unable to destroy machine %s, not currently stopped", m.ID))
+		return
+	}
+
+	delete(a.leases, m.ID)
+	s.detachVolumes(a, m)
+	s.transition(m, fly.MachineStateDestroying, fly.MachineStateDestroyed, "destroy")
+
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func (s *Server) startMachine(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	previous := m.State
+	switch m.State {
+	case fly.MachineStateStarted:
+	case fly.MachineStateStopped, fly.MachineStateSuspended, fly.MachineStateCreated:
+		s.transition(m, "starting", fly.MachineStateStarted, "start")
+	default:
+		writeError(w, http.StatusPreconditionFailed, fmt.Sprintf("unable to start machine from current state: '%s'", m.State))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, fly.MachineStartResponse{Status: "success", PreviousState: previous})
+}
+
+func (s *Server) stopMachine(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	if !m.IsActive() {
+		writeError(w, http.StatusPreconditionFailed, fmt.Sprintf("unable to stop machine from current state: '%s'", m.State))
+		return
+	}
+	if m.State != fly.MachineStateStopped {
+		s.transition(m, "stopping", fly.MachineStateStopped, "stop")
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func (s *Server) restartMachine(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	if m.State != fly.MachineStateStarted {
+		writeError(w, http.StatusPreconditionFailed, fmt.Sprintf("unable to restart machine from current state: '%s'", m.State))
+		return
+	}
+	s.transition(m, "stopping", fly.MachineStateStarted, "restart")
+
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func (s *Server) suspendMachine(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	if m.State != fly.MachineStateStarted {
+		writeError(w, http.StatusPreconditionFailed, fmt.Sprintf("unable to suspend machine from current state: '%s'", m.State))
+		return
+	}
+	s.transition(m, "suspending", fly.MachineStateSuspended, "suspend")
+
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func (s *Server) signalMachine(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	if m.State == fly.MachineStateStarted {
+		s.transition(m, "stopping", fly.MachineStateStopped, "exit")
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func (s *Server) cordonMachine(cordoned bool) machineHandler {
+	return func(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+		m.Cordoned = cordoned
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	}
+}
+
+// waitMachine blocks until the machine reaches one of the requested states,
+// running the requested instance if there is one, and has an event newer
+// than from_event_id if one is given. It times out with a 408, like the API.
+func (s *Server) waitMachine(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	states := q["state"]
+	if len(states) == 0 {
+		states = []string{fly.MachineStateStarted}
+	}
+	timeout := 60 * time.Second
+	if secs, err := strconv.Atoi(q.Get("timeout")); err == nil && secs > 0 {
+		timeout = time.Duration(secs) * time.Second
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		s.mu.Lock()
+		a, ok := s.lookupApp(w, r)
+		if !ok {
+			s.mu.Unlock()
+			return
+		}
+		m, ok := a.machines[r.PathValue("id")]
+		if !ok {
+			s.mu.Unlock()
+			writeError(w, http.StatusNotFound, "machine not found")
+			return
+		}
+
+		done := slices.Contains(states, m.State) &&
+			(q.Get("version") == "" || q.Get("version") == m.InstanceID) &&
+			(q.Get("from_event_id") == "" || (len(m.Events) > 0 && m.Events[0].ID > q.Get("from_event_id")))
+		state := m.State
+		changed := s.changed
+		s.mu.Unlock()
+
+		if done {
+			writeJSON(w, http.StatusOK, map[string]any{"ok": true, "state": state})
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			writeError(w, http.StatusRequestTimeout, "deadline_exceeded: machine failed to reach desired state")
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) findLease(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	lease := a.activeLease(m.ID)
+	if lease == nil {
+		writeError(w, http.StatusNotFound, "lease not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, fly.MachineLease{Status: "success", Data: lease})
+}
+
+func (s *Server) acquireLease(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	ttl := defaultLeaseTTL
+	if v, err := strconv.Atoi(r.URL.Query().Get("ttl")); err == nil && v > 0 {
+		ttl = v
+	}
+	nonce := r.Header.Get(flaps.NonceHeader)
+
+	lease := a.activeLease(m.ID)
+	switch {
+	case lease != nil && lease.Nonce != nonce:
+		writeError(w, http.StatusConflict, fmt.Sprintf("lease currently held by %s, expires at %s",
+			lease.Owner, time.Unix(lease.ExpiresAt, 0).UTC().Format(time.RFC3339)))
+		return
+	case lease == nil && nonce != "":
+		writeError(w, http.StatusNotFound, "lease not found")
+		return
+	case lease == nil:
+		lease = &fly.MachineLeaseData{
+			Nonce: s.nextID(""),
+			Owner: "flapstest",
+		}
+		a.leases[m.ID] = lease
+	}
+	lease.ExpiresAt = time.Now().Add(time.Duration(ttl) * time.Second).Unix()
+	lease.Version = s.nextID("")
+
+	writeJSON(w, http.StatusOK, fly.MachineLease{Status: "success", Data: lease})
+}
+
+func (s *Server) releaseLease(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	lease := a.activeLease(m.ID)
+	if lease == nil {
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+		return
+	}
+	if lease.Nonce != r.Header.Get(flaps.NonceHeader) {
+		writeError(w, http.StatusConflict, "lease nonce mismatch")
+		return
+	}
+	delete(a.leases, m.ID)
+
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func (s *Server) getMetadata(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	out := m.Config.Metadata
+	if out == nil {
+		out = map[string]string{}
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) setMetadata(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	var in struct {
+		Value string `json:"value"`
+	}
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	if m.Config.Metadata == nil {
+		m.Config.Metadata = make(map[string]string)
+	}
+	m.Config.Metadata[r.PathValue("key")] = in.Value
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteMetadata(w http.ResponseWriter, r *http.Request, a *app, m *fly.Machine) {
+	delete(m.Config.Metadata, r.PathValue("key"))
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,150 @@
+package flapstest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+)
+
+// SetSecret sets a secret on an app, creating the app if needed, and returns
+// the new secrets version.
+func (s *Server) SetSecret(appName, name, value string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.addApp(appName, "personal")
+	a.setSecret(name, &value)
+	a.secretsVersion++
+
+	return a.secretsVersion
+}
+
+// Secrets returns the secrets of an app, by name, and their version.
+func (s *Server) Secrets(appName string) (map[string]string, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.apps[appName]
+	if !ok {
+		return map[string]string{}, 0
+	}
+
+	return maps.Clone(a.secrets), a.secretsVersion
+}
+
+// setSecret sets, or with a nil value deletes, a secret. The caller must hold
+// the lock, and bump the secrets version once it's done.
+func (a *app) setSecret(name string, value *string) {
+	if value == nil {
+		delete(a.secrets, name)
+		delete(a.secretsUpdated, name)
+	} else {
+		a.secrets[name] = *value
+		a.secretsUpdated[name] = time.Now().UTC().Format(time.RFC3339)
+	}
+}
+
+func (a *app) secret(name string, showSecrets bool) fly.AppSecret {
+	value := a.secrets[name]
+	// The API's digests are opaque, so these are too: they change with the
+	// value, but aren't a plain hash of it.
+	digest := sha256.Sum256([]byte(name + "\x00" + value))
+	updated := a.secretsUpdated[name]
+
+	out := fly.AppSecret{
+		Name:      name,
+		Digest:    hex.EncodeToString(digest[:8]),
+		CreatedAt: &updated,
+		UpdatedAt: &updated,
+	}
+	if showSecrets {
+		out.Value = &value
+	}
+
+	return out
+}
+
+func (a *app) secretList(showSecrets bool) []fly.AppSecret {
+	out := []fly.AppSecret{}
+	for _, name := range slices.Sorted(maps.Keys(a.secrets)) {
+		out = append(out, a.secret(name, showSecrets))
+	}
+
+	return out
+}
+
+func (s *Server) routeSecrets(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/apps/{app}/secrets", s.withApp(s.listSecrets))
+	mux.HandleFunc("POST /v1/apps/{app}/secrets", s.withApp(s.updateSecrets))
+	mux.HandleFunc("GET /v1/apps/{app}/secrets/{name}", s.withApp(s.getSecret))
+	mux.HandleFunc("POST /v1/apps/{app}/secrets/{name}", s.withApp(s.setSecret))
+	mux.HandleFunc("DELETE /v1/apps/{app}/secrets/{name}", s.withApp(s.deleteSecret))
+}
+
+func showSecrets(r *http.Request) bool {
+	return r.URL.Query().Get("show_secrets") == "true"
+}
+
+func (s *Server) listSecrets(w http.ResponseWriter, r *http.Request, a *app) {
+	writeJSON(w, http.StatusOK, fly.ListAppSecretsResp{Secrets: a.secretList(showSecrets(r))})
+}
+
+func (s *Server) getSecret(w http.ResponseWriter, r *http.Request, a *app) {
+	name := r.PathValue("name")
+	if _, ok := a.secrets[name]; !ok {
+		writeError(w, http.StatusNotFound, "secret not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.secret(name, showSecrets(r)))
+}
+
+func (s *Server) setSecret(w http.ResponseWriter, r *http.Request, a *app) {
+	var in fly.SetAppSecretRequest
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	name := r.PathValue("name")
+	a.setSecret(name, &in.Value)
+	a.secretsVersion++
+
+	writeJSON(w, http.StatusCreated, fly.SetAppSecretResp{
+		AppSecret: a.secret(name, false),
+		Version:   a.secretsVersion,
+	})
+}
+
+func (s *Server) deleteSecret(w http.ResponseWriter, r *http.Request, a *app) {
+	name := r.PathValue("name")
+	if _, ok := a.secrets[name]; !ok {
+		writeError(w, http.StatusNotFound, "secret not found")
+		return
+	}
+	a.setSecret(name, nil)
+	a.secretsVersion++
+
+	writeJSON(w, http.StatusOK, fly.DeleteAppSecretResp{Version: a.secretsVersion})
+}
+
+func (s *Server) updateSecrets(w http.ResponseWriter, r *http.Request, a *app) {
+	var in fly.UpdateAppSecretsRequest
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(in.Values)) {
+		a.setSecret(name, in.Values[name])
+	}
+	a.secretsVersion++
+
+	writeJSON(w, http.StatusOK, fly.UpdateAppSecretsResp{
+		Secrets: a.secretList(false),
+		Version: a.secretsVersion,
+	})
+}
@@ -0,0 +1,282 @@
+// Package flapstest provides an in-memory fake of the Machines API for tests
+// of code that drives a flaps.Client.
+//
+// The fake keeps apps, machines, leases, metadata, volumes, snapshots and
+// secrets in memory, and enforces the parts of the API's behaviour that
+// callers tend to depend on: leases conflict, mutations of a leased machine
+// need the nonce, Wait blocks until the machine reaches the requested state,
+// and machines move through their states as they are started, stopped and
+// destroyed.
+//
+//	srv := flapstest.NewServer()
+//	defer srv.Close()
+//	srv.AddApp("my-app", "personal")
+//	client, err := srv.NewClient(ctx)
+package flapstest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/flaps"
+)
+
+// Server is a fake Machines API. The zero value is not usable; use NewServer.
+type Server struct {
+	*httptest.Server
+
+	// TransitionDelay is how long machines spend in the intermediate states
+	// (starting, stopping, suspending, destroying), volumes spend being
+	// created and snapshots spend running. With no delay, every transition
+	// completes before the request that caused it returns.
+	TransitionDelay time.Duration
+
+	// ExtendNeedsRestart makes extending a volume that's attached to a
+	// started machine report that the machine needs a restart.
+	ExtendNeedsRestart bool
+
+	mu   sync.Mutex
+	apps map[string]*app
+	// changed is closed, and replaced, whenever a machine changes, which
+	// wakes up the Wait calls blocked on it.
+	changed chan struct{}
+	// seq numbers everything the server hands out IDs for.
+	seq int
+}
+
+type app struct {
+	flaps.App
+
+	machines  map[string]*fly.Machine
+	leases    map[string]*fly.MachineLeaseData
+	volumes   map[string]*fly.Volume
+	snapshots map[string][]*fly.VolumeSnapshot
+
+	secrets        map[string]string
+	secretsUpdated map[string]string
+	secretsVersion uint64
+}
+
+// NewServer starts a fake Machines API. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		apps:    make(map[string]*app),
+		changed: make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	s.routeApps(mux)
+	s.routeMachines(mux)
+	s.routeVolumes(mux)
+	s.routeSecrets(mux)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("flapstest: %s %s is not implemented", r.Method, r.URL.Path))
+	})
+
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// NewClient returns a flaps client that talks to the server.
+func (s *Server) NewClient(ctx context.Context) (*flaps.Client, error) {
+	return flaps.NewWithOptions(ctx, flaps.NewClientOpts{BaseURL: s.URL})
+}
+
+// AddApp adds an app, if it doesn't exist yet.
+func (s *Server) AddApp(name, orgSlug string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addApp(name, orgSlug)
+}
+
+func (s *Server) addApp(name, orgSlug string) *app {
+	if a, ok := s.apps[name]; ok {
+		return a
+	}
+
+	a := &app{
+		App: flaps.App{
+			ID:           s.nextID("app_"),
+			Name:         name,
+			Network:      "default",
+			Status:       "pending",
+			Organization: flaps.AppOrganizationInfo{Name: orgSlug, Slug: orgSlug},
+		},
+		machines:       make(map[string]*fly.Machine),
+		leases:         make(map[string]*fly.MachineLeaseData),
+		volumes:        make(map[string]*fly.Volume),
+		snapshots:      make(map[string][]*fly.VolumeSnapshot),
+		secrets:        make(map[string]string),
+		secretsUpdated: make(map[string]string),
+	}
+	s.apps[name] = a
+
+	return a
+}
+
+// nextID returns a new ID with the given prefix. IDs sort in the order they
+// were handed out.
+func (s *Server) nextID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s%014x", prefix, s.seq)
+}
+
+// notify wakes up every Wait call, so they can check on their machines.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// after runs fn with the server locked once TransitionDelay has passed, or
+// right away if there is no delay. The caller must hold the lock.
+func (s *Server) after(fn func()) {
+	if s.TransitionDelay <= 0 {
+		fn()
+		return
+	}
+
+	time.AfterFunc(s.TransitionDelay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		fn()
+	})
+}
+
+// lookupApp returns the app named in the request path, writing a 404 if
+// there's no such app.
+func (s *Server) lookupApp(w http.ResponseWriter, r *http.Request) (*app, bool) {
+	a, ok := s.apps[r.PathValue("app")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "app not found")
+	}
+
+	return a, ok
+}
+
+func (s *Server) routeApps(mux *http.ServeMux) {
+	mux.HandleFunc("POST /v1/apps", s.createApp)
+	mux.HandleFunc("GET /v1/apps", s.listApps)
+	mux.HandleFunc("GET /v1/apps/{app}", s.getApp)
+	mux.HandleFunc("DELETE /v1/apps/{app}", s.deleteApp)
+}
+
+func (s *Server) createApp(w http.ResponseWriter, r *http.Request) {
+	var in flaps.CreateAppRequest
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.apps[in.Name]; ok {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{
+			"error":  "Validation failed: Name has already been taken",
+			"status": "name_taken",
+		})
+		return
+	}
+
+	a := s.addApp(in.Name, in.Org)
+	if in.Network != "" {
+		a.Network = in.Network
+	}
+	a.AppRole = in.AppRoleID
+	writeJSON(w, http.StatusCreated, a.App)
+}
+
+func (s *Server) listApps(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org := r.URL.Query().Get("org_slug")
+	role := r.URL.Query().Get("app_role")
+
+	out := struct {
+		Apps []flaps.App `json:"apps"`
+	}{Apps: []flaps.App{}}
+	for _, a := range s.apps {
+		if (org == "" || a.Organization.Slug == org) && (role == "" || a.AppRole == role) {
+			out.Apps = append(out.Apps, a.summary())
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) getApp(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.lookupApp(w, r); ok {
+		writeJSON(w, http.StatusOK, a.summary())
+	}
+}
+
+func (s *Server) deleteApp(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookupApp(w, r); ok {
+		delete(s.apps, r.PathValue("app"))
+		s.notify()
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (a *app) summary() flaps.App {
+	out := a.App
+	for _, m := range a.machines {
+		if m.IsActive() {
+			out.MachineCount++
+		}
+	}
+	out.VolumeCount = int64(len(a.volumes))
+
+	return out
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// clone deep copies v through its JSON encoding, so callers never share
+// memory with the server's state.
+func clone[T any](v *T) *T {
+	if v == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("flapstest: can't encode %T: %v", v, err))
+	}
+	out := new(T)
+	if err := json.Unmarshal(b, out); err != nil {
+		panic(fmt.Sprintf("flapstest: can't decode %T: %v", v, err))
+	}
+
+	return out
+}
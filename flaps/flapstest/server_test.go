@@ -0,0 +1,247 @@
+package flapstest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/flaps"
+)
+
+func newTestServer(t *testing.T) (*Server, *flaps.Client) {
+	t.Helper()
+
+	srv := NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddApp("my-app", "personal")
+
+	client, err := srv.NewClient(context.Background())
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	return srv, client
+}
+
+func TestMachineLifecycle(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.TransitionDelay = 20 * time.Millisecond
+	ctx := context.Background()
+
+	m, err := client.Launch(ctx, "my-app", fly.LaunchMachineInput{
+		Config: &fly.MachineConfig{Image: "nginx"},
+	})
+	if err != nil {
+		t.Fatalf("Launch() error = %v", err)
+	}
+	if m.State != "starting" {
+		t.Fatalf("Launch() state = %q, want %q", m.State, "starting")
+	}
+
+	if err := client.Wait(ctx, "my-app", m.ID, flaps.WithWaitStates(fly.MachineStateStarted)); err != nil {
+		t.Fatalf("Wait(started) error = %v", err)
+	}
+
+	if err := client.Stop(ctx, "my-app", fly.StopMachineInput{ID: m.ID}, ""); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if err := client.Wait(ctx, "my-app", m.ID, flaps.WithWaitStates(fly.MachineStateStopped)); err != nil {
+		t.Fatalf("Wait(stopped) error = %v", err)
+	}
+
+	if err := client.Destroy(ctx, "my-app", fly.RemoveMachineInput{ID: m.ID}, ""); err != nil {
+		t.Fatalf("Destroy() error = %v", err)
+	}
+	if err := client.Wait(ctx, "my-app", m.ID, flaps.WithWaitStates(fly.MachineStateDestroyed)); err != nil {
+		t.Fatalf("Wait(destroyed) error = %v", err)
+	}
+
+	machines, err := client.ListActive(ctx, "my-app")
+	if err != nil {
+		t.Fatalf("ListActive() error = %v", err)
+	}
+	if len(machines) != 0 {
+		t.Fatalf("ListActive() = %d machines, want none", len(machines))
+	}
+
+	// created, starting, started, stopping, stopped, destroying, destroyed.
+	events := srv.Machine("my-app", m.ID).Events
+	if len(events) != 7 || events[0].Status != fly.MachineStateDestroyed {
+		t.Fatalf("machine has %d events, newest %+v, want 7 ending in destroyed", len(events), events[0])
+	}
+}
+
+func TestWaitTimesOut(t *testing.T) {
+	srv, client := newTestServer(t)
+	m := srv.AddMachine("my-app", &fly.Machine{State: fly.MachineStateStopped})
+
+	err := client.Wait(context.Background(), "my-app", m.ID, flaps.WithWaitTimeout(time.Second))
+	if !errors.Is(err, &flaps.FlapsError{ResponseStatusCode: 408}) {
+		t.Fatalf("Wait() error = %v, want a 408", err)
+	}
+}
+
+func TestListMachines(t *testing.T) {
+	srv, client := newTestServer(t)
+	ctx := context.Background()
+	for i := range 5 {
+		region := "ord"
+		if i%2 == 1 {
+			region = "ams"
+		}
+		srv.AddMachine("my-app", &fly.Machine{Region: region, Config: &fly.MachineConfig{
+			Metadata: map[string]string{"index": strconv.Itoa(i)},
+		}})
+	}
+
+	machines, errf := client.ListAll(ctx, "my-app", flaps.ListMachinesOptions{Region: "ord"})
+	var regions []string
+	for m := range machines {
+		regions = append(regions, m.Region)
+	}
+	if err := errf(); err != nil {
+		t.Fatalf("ListAll() error = %v", err)
+	}
+	if len(regions) != 3 || slices.ContainsFunc(regions, func(r string) bool { return r != "ord" }) {
+		t.Fatalf("ListAll(ord) regions = %v, want 3 in ord", regions)
+	}
+
+	got, err := client.ListWithOptions(ctx, "my-app", flaps.ListMachinesOptions{Metadata: map[string]string{"index": "3"}})
+	if err != nil {
+		t.Fatalf("ListWithOptions() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Config.Metadata["index"] != "3" {
+		t.Fatalf("ListWithOptions(index=3) = %v, want one machine", got)
+	}
+}
+
+func TestLeases(t *testing.T) {
+	srv, client := newTestServer(t)
+	ctx := context.Background()
+	m := srv.AddMachine("my-app", &fly.Machine{})
+
+	ttl := 30
+	lease, err := client.AcquireLease(ctx, "my-app", m.ID, &ttl)
+	if err != nil {
+		t.Fatalf("AcquireLease() error = %v", err)
+	}
+	nonce := lease.Data.Nonce
+
+	if err := client.Cordon(ctx, "my-app", m.ID, "wrong"); !errors.Is(err, &flaps.FlapsError{ResponseStatusCode: 409}) {
+		t.Fatalf("Cordon() with the wrong nonce error = %v, want a 409", err)
+	}
+	if err := client.Cordon(ctx, "my-app", m.ID, nonce); err != nil {
+		t.Fatalf("Cordon() error = %v", err)
+	}
+	if !srv.Machine("my-app", m.ID).Cordoned {
+		t.Fatal("machine was not cordoned")
+	}
+
+	if _, err := client.RefreshLease(ctx, "my-app", m.ID, &ttl, nonce); err != nil {
+		t.Fatalf("RefreshLease() error = %v", err)
+	}
+	if err := client.ReleaseLease(ctx, "my-app", m.ID, nonce); err != nil {
+		t.Fatalf("ReleaseLease() error = %v", err)
+	}
+	if lease := srv.Lease("my-app", m.ID); lease != nil {
+		t.Fatalf("Lease() = %+v after release, want nil", lease)
+	}
+}
+
+func TestAcquireLeasesConflict(t *testing.T) {
+	srv, client := newTestServer(t)
+	ctx := context.Background()
+	m1 := srv.AddMachine("my-app", &fly.Machine{})
+	m2 := srv.AddMachine("my-app", &fly.Machine{})
+
+	held := m1
+	if m2.ID > m1.ID {
+		held = m2
+	}
+	if _, err := client.AcquireLease(ctx, "my-app", held.ID, nil); err != nil {
+		t.Fatalf("AcquireLease() error = %v", err)
+	}
+
+	start := time.Now()
+	_, err := client.AcquireLeases(ctx, "my-app", []string{m1.ID, m2.ID}, 0)
+	if !errors.Is(err, &flaps.FlapsError{ResponseStatusCode: 409}) {
+		t.Fatalf("AcquireLeases() error = %v, want a 409", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("AcquireLeases() took %s, want the conflict not retried", elapsed)
+	}
+	for _, m := range []*fly.Machine{m1, m2} {
+		if lease := srv.Lease("my-app", m.ID); m != held && lease != nil {
+			t.Fatalf("Lease(%s) = %+v, want it rolled back", m.ID, lease)
+		}
+	}
+}
+
+func TestVolumes(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.ExtendNeedsRestart = true
+	ctx := context.Background()
+
+	size := 3
+	vol, err := client.CreateVolume(ctx, "my-app", fly.CreateVolumeRequest{Name: "data", SizeGb: &size})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	srv.AddMachine("my-app", &fly.Machine{
+		Config: &fly.MachineConfig{Mounts: []fly.MachineMount{{Volume: vol.ID, Path: "/data"}}},
+	})
+
+	_, needsRestart, err := client.ExtendVolume(ctx, "my-app", vol.ID, 5)
+	if err != nil {
+		t.Fatalf("ExtendVolume() error = %v", err)
+	}
+	if !needsRestart {
+		t.Fatal("ExtendVolume() needsRestart = false, want true")
+	}
+
+	if err := client.CreateVolumeSnapshot(ctx, "my-app", vol.ID); err != nil {
+		t.Fatalf("CreateVolumeSnapshot() error = %v", err)
+	}
+	snaps, err := client.GetVolumeSnapshots(ctx, "my-app", vol.ID)
+	if err != nil {
+		t.Fatalf("GetVolumeSnapshots() error = %v", err)
+	}
+	if len(snaps) != 1 || snaps[0].Status != "created" || snaps[0].VolumeSize != 5 {
+		t.Fatalf("GetVolumeSnapshots() = %+v, want one created 5GB snapshot", snaps)
+	}
+
+	restored, err := client.CreateVolume(ctx, "my-app", fly.CreateVolumeRequest{Name: "data", SnapshotID: &snaps[0].ID})
+	if err != nil {
+		t.Fatalf("CreateVolume() from snapshot error = %v", err)
+	}
+	if restored.SizeGb != 5 {
+		t.Fatalf("restored volume is %dGB, want 5GB", restored.SizeGb)
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	srv, client := newTestServer(t)
+	ctx := context.Background()
+	srv.SetSecret("my-app", "A", "1")
+
+	b := "2"
+	resp, err := client.UpdateAppSecrets(ctx, "my-app", map[string]*string{"A": nil, "B": &b})
+	if err != nil {
+		t.Fatalf("UpdateAppSecrets() error = %v", err)
+	}
+	if resp.Version != 2 {
+		t.Fatalf("UpdateAppSecrets() version = %d, want 2", resp.Version)
+	}
+
+	secrets, err := client.ListAppSecrets(ctx, "my-app", nil, true)
+	if err != nil {
+		t.Fatalf("ListAppSecrets() error = %v", err)
+	}
+	if len(secrets) != 1 || secrets[0].Name != "B" || secrets[0].Value == nil || *secrets[0].Value != "2" {
+		t.Fatalf("ListAppSecrets() = %+v, want just B=2", secrets)
+	}
+}
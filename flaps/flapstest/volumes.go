@@ -0,0 +1,288 @@
+package flapstest
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+)
+
+// AddVolume adds a volume to an app, creating the app if needed, and returns
+// a copy of it as stored. An ID, region and state are filled in if missing.
+func (s *Server) AddVolume(appName string, v *fly.Volume) *fly.Volume {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.addApp(appName, "personal")
+	v = clone(v)
+	if v.ID == "" {
+		v.ID = s.nextID("vol_")
+	}
+	if v.Region == "" {
+		v.Region = "iad"
+	}
+	if v.State == "" {
+		v.State = "created"
+	}
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now().UTC()
+	}
+	a.volumes[v.ID] = v
+
+	return clone(v)
+}
+
+// Volume returns a copy of a volume, or nil if there's no such volume.
+func (s *Server) Volume(appName, volumeID string) *fly.Volume {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.apps[appName]; ok {
+		return clone(a.volumes[volumeID])
+	}
+
+	return nil
+}
+
+// AddVolumeSnapshot adds a snapshot of a volume, and returns a copy of it as
+// stored. An ID, status and creation time are filled in if missing.
+func (s *Server) AddVolumeSnapshot(appName, volumeID string, snap *fly.VolumeSnapshot) *fly.VolumeSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.addApp(appName, "personal")
+	snap = clone(snap)
+	if snap.ID == "" {
+		snap.ID = s.nextID("vs_")
+	}
+	if snap.Status == "" {
+		snap.Status = "created"
+	}
+	if snap.CreatedAt.IsZero() {
+		snap.CreatedAt = time.Now().UTC()
+	}
+	a.snapshots[volumeID] = append(a.snapshots[volumeID], snap)
+
+	return clone(snap)
+}
+
+func (s *Server) routeVolumes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/apps/{app}/volumes", s.withApp(s.listVolumes))
+	mux.HandleFunc("POST /v1/apps/{app}/volumes", s.withApp(s.createVolume))
+	mux.HandleFunc("GET /v1/apps/{app}/volumes/{id}", s.withVolume(s.getVolume))
+	mux.HandleFunc("PUT /v1/apps/{app}/volumes/{id}", s.withVolume(s.updateVolume))
+	mux.HandleFunc("DELETE /v1/apps/{app}/volumes/{id}", s.withVolume(s.deleteVolume))
+	mux.HandleFunc("PUT /v1/apps/{app}/volumes/{id}/extend", s.withVolume(s.extendVolume))
+	mux.HandleFunc("GET /v1/apps/{app}/volumes/{id}/snapshots", s.withVolume(s.listSnapshots))
+	mux.HandleFunc("POST /v1/apps/{app}/volumes/{id}/snapshots", s.withVolume(s.createSnapshot))
+}
+
+type appHandler func(w http.ResponseWriter, r *http.Request, a *app)
+
+// withApp looks up the app named in the request path, and calls h with the
+// server locked.
+func (s *Server) withApp(h appHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if a, ok := s.lookupApp(w, r); ok {
+			h(w, r, a)
+		}
+	}
+}
+
+type volumeHandler func(w http.ResponseWriter, r *http.Request, a *app, v *fly.Volume)
+
+// withVolume looks up the volume named in the request path, and calls h with
+// the server locked.
+func (s *Server) withVolume(h volumeHandler) http.HandlerFunc {
+	return s.withApp(func(w http.ResponseWriter, r *http.Request, a *app) {
+		v, ok := a.volumes[r.PathValue("id")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "volume not found")
+			return
+		}
+
+		h(w, r, a, v)
+	})
+}
+
+func (s *Server) listVolumes(w http.ResponseWriter, r *http.Request, a *app) {
+	out := []*fly.Volume{}
+	for _, v := range a.volumes {
+		out = append(out, v)
+	}
+	slices.SortFunc(out, func(x, y *fly.Volume) int {
+		return strings.Compare(x.ID, y.ID)
+	})
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) createVolume(w http.ResponseWriter, r *http.Request, a *app) {
+	var in fly.CreateVolumeRequest
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	v := &fly.Volume{
+		ID:                s.nextID("vol_"),
+		Name:              in.Name,
+		Region:            in.Region,
+		Zone:              "fake",
+		SizeGb:            1,
+		Encrypted:         true,
+		CreatedAt:         time.Now().UTC(),
+		SnapshotRetention: 5,
+		AutoBackupEnabled: true,
+	}
+	if v.Region == "" {
+		v.Region = "iad"
+	}
+	if in.Encrypted != nil {
+		v.Encrypted = *in.Encrypted
+	}
+	if in.SnapshotRetention != nil {
+		v.SnapshotRetention = *in.SnapshotRetention
+	}
+	if in.AutoBackupEnabled != nil {
+		v.AutoBackupEnabled = *in.AutoBackupEnabled
+	}
+
+	state := "creating"
+	switch {
+	case in.SourceVolumeID != nil:
+		src, ok := a.volumes[*in.SourceVolumeID]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("source volume %s not found", *in.SourceVolumeID))
+			return
+		}
+		v.SizeGb = src.SizeGb
+		state = "hydrating"
+	case in.SnapshotID != nil:
+		snap := a.findSnapshot(*in.SnapshotID)
+		if snap == nil {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("snapshot %s not found", *in.SnapshotID))
+			return
+		}
+		v.SizeGb = snap.VolumeSize
+		state = "hydrating"
+	}
+	if in.SizeGb != nil {
+		v.SizeGb = *in.SizeGb
+	}
+	a.volumes[v.ID] = v
+
+	if s.TransitionDelay > 0 {
+		v.State = state
+	}
+	s.after(func() {
+		v.State = "created"
+	})
+
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (a *app) findSnapshot(id string) *fly.VolumeSnapshot {
+	for _, snaps := range a.snapshots {
+		for _, snap := range snaps {
+			if snap.ID == id {
+				return snap
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) getVolume(w http.ResponseWriter, r *http.Request, a *app, v *fly.Volume) {
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (s *Server) updateVolume(w http.ResponseWriter, r *http.Request, a *app, v *fly.Volume) {
+	var in fly.UpdateVolumeRequest
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	if in.SnapshotRetention != nil {
+		v.SnapshotRetention = *in.SnapshotRetention
+	}
+	if in.AutoBackupEnabled != nil {
+		v.AutoBackupEnabled = *in.AutoBackupEnabled
+	}
+
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (s *Server) deleteVolume(w http.ResponseWriter, r *http.Request, a *app, v *fly.Volume) {
+	if v.AttachedMachine != nil {
+		if m, ok := a.machines[*v.AttachedMachine]; ok && m.IsActive() {
+			writeError(w, http.StatusPreconditionFailed, fmt.Sprintf("volume %s is attached to machine %s", v.ID, m.ID))
+			return
+		}
+	}
+
+	v.State = "pending_destroy"
+	writeJSON(w, http.StatusOK, v)
+
+	s.after(func() {
+		delete(a.volumes, v.ID)
+	})
+}
+
+func (s *Server) extendVolume(w http.ResponseWriter, r *http.Request, a *app, v *fly.Volume) {
+	var in struct {
+		SizeGB int `json:"size_gb"`
+	}
+	if !readJSON(w, r, &in) {
+		return
+	}
+	if in.SizeGB <= v.SizeGb {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("size_gb must be greater than the current size of %dGB", v.SizeGb))
+		return
+	}
+	v.SizeGb = in.SizeGB
+
+	needsRestart := false
+	if s.ExtendNeedsRestart && v.AttachedMachine != nil {
+		m, ok := a.machines[*v.AttachedMachine]
+		needsRestart = ok && m.State == fly.MachineStateStarted
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"volume":        v,
+		"needs_restart": needsRestart,
+	})
+}
+
+func (s *Server) listSnapshots(w http.ResponseWriter, r *http.Request, a *app, v *fly.Volume) {
+	out := a.snapshots[v.ID]
+	if out == nil {
+		out = []*fly.VolumeSnapshot{}
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) createSnapshot(w http.ResponseWriter, r *http.Request, a *app, v *fly.Volume) {
+	snap := &fly.VolumeSnapshot{
+		ID:         s.nextID("vs_"),
+		Size:       v.SizeGb << 30,
+		VolumeSize: v.SizeGb,
+		Digest:     s.nextID("digest_"),
+		CreatedAt:  time.Now().UTC(),
+		Status:     "running",
+	}
+	a.snapshots[v.ID] = append(a.snapshots[v.ID], snap)
+
+	s.after(func() {
+		snap.Status = "created"
+	})
+
+	writeJSON(w, http.StatusOK, snap)
+}
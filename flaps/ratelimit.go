@@ -0,0 +1,246 @@
+package flaps
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/superfly/fly-go/internal/retryafter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RateLimit limits how fast, and how many at once, a client makes requests,
+// to stay under the Machines API's rate limits when fanning out across many
+// machines. Reads (GET requests) and mutations have separate budgets, and
+// every attempt at a request, retries included, is charged to its budget.
+//
+// The limits adapt: a 429 response halves the rate of the budget it came from,
+// and pauses it for as long as the response's Retry-After asks, and the rate
+// recovers gradually as requests succeed again.
+//
+// Waits for a machine's state are charged to the Reads rate, but as they're
+// long polls that can last a minute, they don't count towards its MaxInFlight,
+// so that a few watches don't hold up every other read.
+type RateLimit struct {
+	Reads     RateBudget
+	Mutations RateBudget
+}
+
+// RateBudget is the limit on one kind of request.
+type RateBudget struct {
+	// Rate is the sustained number of requests per second. Zero means no
+	// limit on the rate.
+	Rate float64
+
+	// Burst is how many requests can be made at once after a quiet period.
+	// It defaults to 1.
+	Burst int
+
+	// MaxInFlight is the most requests that can be in flight at once. Zero
+	// means no limit.
+	MaxInFlight int
+}
+
+type rateLimiter struct {
+	reads     *bucket
+	mutations *bucket
+}
+
+func newRateLimiter(rl *RateLimit) *rateLimiter {
+	if rl == nil {
+		return nil
+	}
+
+	return &rateLimiter{
+		reads:     newBucket(rl.Reads),
+		mutations: newBucket(rl.Mutations),
+	}
+}
+
+// transport returns a transport that makes requests with inner once the
+// limiter allows them. It goes underneath the client's retries, so that every
+// attempt is charged to the budget and every 429 adapts it.
+func (rl *rateLimiter) transport(inner http.RoundTripper) http.RoundTripper {
+	return &rateLimitTransport{inner: inner, limiter: rl}
+}
+
+type rateLimitTransport struct {
+	inner   http.RoundTripper
+	limiter *rateLimiter
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	limit, budget := t.limiter.bucket(req.Method)
+	longPoll := actionFromContext(req.Context()) == machineWait
+	release, waited, err := limit.acquire(req.Context(), !longPoll)
+
+	span := trace.SpanFromContext(req.Context())
+	span.SetAttributes(
+		attribute.String("request.rate_limit.budget", budget),
+		attribute.Int64("request.rate_limit.wait_ms", waited.Milliseconds()),
+	)
+	if waited > 0 {
+		span.AddEvent("rate_limited", trace.WithAttributes(attribute.String("wait", waited.String())))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	limit.observe(resp)
+
+	// The request is in flight until its response has been read.
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: sync.OnceFunc(release)}
+
+	return resp, nil
+}
+
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
+
+// bucket returns the budget a request is charged to, and its name.
+func (rl *rateLimiter) bucket(method string) (*bucket, string) {
+	if method == http.MethodGet || method == http.MethodHead {
+		return rl.reads, "reads"
+	}
+
+	return rl.mutations, "mutations"
+}
+
+// bucket is a token bucket whose rate adapts to 429 responses, with a
+// semaphore for the requests in flight.
+type bucket struct {
+	budget RateBudget
+	slots  chan struct{}
+
+	mu          sync.Mutex
+	rate        float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newBucket(budget RateBudget) *bucket {
+	if budget.Burst <= 0 {
+		budget.Burst = 1
+	}
+
+	b := &bucket{
+		budget: budget,
+		rate:   budget.Rate,
+		tokens: float64(budget.Burst),
+		last:   time.Now(),
+	}
+	if budget.MaxInFlight > 0 {
+		b.slots = make(chan struct{}, budget.MaxInFlight)
+	}
+
+	return b
+}
+
+// acquire blocks until a request may be made, and returns a function to call
+// once it's done, and how long it was blocked for. Unless inFlight is set, the
+// request doesn't take up one of the slots for requests in flight.
+func (b *bucket) acquire(ctx context.Context, inFlight bool) (release func(), waited time.Duration, err error) {
+	start := time.Now()
+	blocked := false
+
+	if delay := b.reserve(start); delay > 0 {
+		blocked = true
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			b.cancel()
+			return nil, time.Since(start), ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	release = func() {}
+	if b.slots != nil && inFlight {
+		select {
+		case b.slots <- struct{}{}:
+		default:
+			blocked = true
+			select {
+			case b.slots <- struct{}{}:
+			case <-ctx.Done():
+				b.cancel()
+				return nil, time.Since(start), ctx.Err()
+			}
+		}
+		release = func() { <-b.slots }
+	}
+
+	if !blocked {
+		return release, 0, nil
+	}
+
+	return release, time.Since(start), nil
+}
+
+// reserve takes a token, going into debt if there are none, and returns how
+// long to wait before the request can be made.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var delay time.Duration
+	if b.rate > 0 {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, float64(b.budget.Burst))
+		b.last = now
+		b.tokens--
+		if b.tokens < 0 {
+			delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+		}
+	}
+	if paused := b.pausedUntil.Sub(now); paused > delay {
+		delay = paused
+	}
+
+	return delay
+}
+
+// cancel returns the token of a request that gave up waiting.
+func (b *bucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rate > 0 {
+		b.tokens = min(b.tokens+1, float64(b.budget.Burst))
+	}
+}
+
+// observe adapts the rate to a response: it's halved, down to a tenth of the
+// budget, on a 429 and recovers by a twentieth of the budget on a success.
+func (b *bucket) observe(resp *http.Response) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		if b.budget.Rate > 0 {
+			b.rate = max(b.rate/2, b.budget.Rate/10)
+		}
+		if after, ok := retryafter.Parse(resp.Header.Get("Retry-After")); ok {
+			b.pausedUntil = time.Now().Add(after)
+		}
+	case resp.StatusCode < 400:
+		b.rate = min(b.rate+b.budget.Rate/20, b.budget.Rate)
+	}
+}
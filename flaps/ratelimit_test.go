@@ -0,0 +1,212 @@
+package flaps
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+)
+
+func TestRateLimitMaxInFlight(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"id":"m1"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewWithOptions(context.Background(), NewClientOpts{
+		BaseURL:   server.URL,
+		RateLimit: &RateLimit{Reads: RateBudget{MaxInFlight: 2}},
+	})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			if _, err := client.Get(context.Background(), "my-app", "m1"); err != nil {
+				t.Errorf("Get() error = %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if got := maxInFlight.Load(); got != 2 {
+		t.Errorf("%d requests were in flight at once, want 2", got)
+	}
+}
+
+func TestRateLimitSeparatesReadsAndMutations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"m1"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewWithOptions(context.Background(), NewClientOpts{
+		BaseURL: server.URL,
+		RateLimit: &RateLimit{
+			Reads: RateBudget{Rate: 20},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+	ctx := context.Background()
+
+	start := time.Now()
+	for range 5 {
+		if _, err := client.Get(ctx, "my-app", "m1"); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("5 reads at 20/s took %s, want at least 200ms less the burst", elapsed)
+	}
+
+	start = time.Now()
+	for range 5 {
+		if err := client.Cordon(ctx, "my-app", "m1", ""); err != nil {
+			t.Fatalf("Cordon() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("5 unlimited mutations took %s", elapsed)
+	}
+}
+
+func TestRateLimitSlowsDownOn429(t *testing.T) {
+	b := newBucket(RateBudget{Rate: 100, Burst: 10})
+
+	b.observe(&http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"1"}}})
+	if b.rate != 50 {
+		t.Errorf("rate after a 429 = %v, want 50", b.rate)
+	}
+	if delay := b.reserve(time.Now()); delay < 900*time.Millisecond {
+		t.Errorf("reserve() after a Retry-After of 1s = %s, want about 1s", delay)
+	}
+
+	for range 5 {
+		b.observe(&http.Response{StatusCode: http.StatusTooManyRequests})
+	}
+	if b.rate != 10 {
+		t.Errorf("rate after many 429s = %v, want the floor of 10", b.rate)
+	}
+
+	for range 100 {
+		b.observe(&http.Response{StatusCode: http.StatusOK})
+	}
+	if b.rate != 100 {
+		t.Errorf("rate after recovering = %v, want 100", b.rate)
+	}
+}
+
+func TestRateLimitSeesRetriedAttempts(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"id":"m1"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewWithOptions(context.Background(), NewClientOpts{
+		BaseURL:     server.URL,
+		RetryPolicy: &fly.RetryPolicy{MaxRetries: 2, InitialInterval: time.Millisecond},
+		RateLimit:   &RateLimit{Reads: RateBudget{Rate: 1, Burst: 10}},
+	})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+
+	if _, err := client.Get(context.Background(), "my-app", "m1"); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("Get() made %d requests, want the 429 retried once", got)
+	}
+
+	b := client.rateLimiter.reads
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Halved by the 429, then recovered by a twentieth by the success.
+	if b.rate != 0.55 {
+		t.Errorf("rate = %v, want 0.55 after the retried 429", b.rate)
+	}
+	if b.tokens > 8.5 {
+		t.Errorf("%v tokens left of 10, want one taken by each attempt", b.tokens)
+	}
+}
+
+func TestRateLimitWaitsDontTakeReadSlots(t *testing.T) {
+	waiting := make(chan struct{})
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/wait") {
+			waiting <- struct{}{}
+			<-done
+			return
+		}
+		w.Write([]byte(`{"id":"m1"}`))
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client, err := NewWithOptions(context.Background(), NewClientOpts{
+		BaseURL:   server.URL,
+		RateLimit: &RateLimit{Reads: RateBudget{MaxInFlight: 1}},
+	})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+
+	go client.Wait(context.Background(), "my-app", "m1")
+	<-waiting
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Get(ctx, "my-app", "m1"); err != nil {
+		t.Fatalf("Get() during a Wait error = %v, want it not held up", err)
+	}
+}
+
+func TestRateLimitRefundsTokenOnCancel(t *testing.T) {
+	b := newBucket(RateBudget{Rate: 1, Burst: 2, MaxInFlight: 1})
+
+	release, _, err := b.acquire(context.Background(), true)
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+	defer release()
+
+	// The second request gets a token but no slot, and gives up.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := b.acquire(ctx, true); err == nil {
+		t.Fatal("acquire() with every slot taken error = nil")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 0.9 {
+		t.Errorf("%v tokens left of 2, want the cancelled request's refunded", b.tokens)
+	}
+}
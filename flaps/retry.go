@@ -19,3 +19,29 @@ func Retry(ctx context.Context, op func() error) error {
 
 	return backoff.Retry(op, backoff.WithContext(bo, ctx))
 }
+
+// retry is Retry, paced by the client's RetryPolicy if it has one. Its
+// MaxRetries limits the retries too, and a policy without a Budget keeps
+// Retry's minute.
+func (f *Client) retry(ctx context.Context, op func() error) error {
+	if f.retryPolicy == nil {
+		return Retry(ctx, op)
+	}
+
+	bo := backoff.NewExponentialBackOff()
+	if f.retryPolicy.InitialInterval > 0 {
+		bo.InitialInterval = f.retryPolicy.InitialInterval
+	}
+	if f.retryPolicy.MaxInterval > 0 {
+		bo.MaxInterval = f.retryPolicy.MaxInterval
+	}
+	if f.retryPolicy.Budget > 0 {
+		bo.MaxElapsedTime = f.retryPolicy.Budget
+	} else {
+		bo.MaxElapsedTime = 1 * time.Minute
+	}
+	bo.Reset()
+	retries := uint64(max(f.retryPolicy.MaxRetries, 0))
+
+	return backoff.Retry(op, backoff.WithContext(backoff.WithMaxRetries(bo, retries), ctx))
+}
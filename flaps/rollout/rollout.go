@@ -0,0 +1,536 @@
+// Package rollout updates the machines of an app to a new configuration,
+// process group by process group, using the same deployment strategies
+// flyctl offers, and rolls the machines back if they fail their health checks.
+package rollout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/flaps"
+)
+
+type Strategy string
+
+const (
+	// Rolling updates machines in place, MaxUnavailable at a time, waiting
+	// for each batch to pass its health checks before moving on.
+	Rolling Strategy = "rolling"
+	// Canary updates a single machine in each process group first, and
+	// carries on with a rolling update once it's healthy.
+	Canary Strategy = "canary"
+	// BlueGreen boots a replacement for every machine, and destroys the
+	// originals only once all of the replacements are healthy.
+	BlueGreen Strategy = "bluegreen"
+	// Immediate updates every machine at once without waiting for health
+	// checks. There is nothing to roll back on.
+	Immediate Strategy = "immediate"
+)
+
+const (
+	defaultWaitTimeout = 5 * time.Minute
+)
+
+// healthCheckInterval is how often machines are polled while waiting for
+// their health checks to pass.
+var healthCheckInterval = time.Second
+
+type Options struct {
+	// Configs is the target config of each process group, keyed by the name
+	// of the group. Machines in groups that aren't listed are left alone.
+	Configs map[string]*fly.MachineConfig
+
+	// Strategy defaults to Rolling.
+	Strategy Strategy
+
+	// MaxUnavailable is how many machines of a process group a rolling
+	// update takes down at a time. Defaults to 1.
+	MaxUnavailable int
+
+	// WaitTimeout bounds how long a machine may take to start and pass its
+	// health checks. Defaults to 5 minutes.
+	WaitTimeout time.Duration
+
+	// LeaseTTL is the TTL, in seconds, of the leases held on machines while
+	// they're changed. Zero uses the API's default.
+	LeaseTTL int
+
+	// Events, if set, receives progress events. Sends block, so it must be
+	// drained until Run returns.
+	Events chan<- Event
+}
+
+type EventKind string
+
+const (
+	EventUpdating    EventKind = "updating"
+	EventUpdated     EventKind = "updated"
+	EventLaunched    EventKind = "launched"
+	EventHealthy     EventKind = "healthy"
+	EventFailed      EventKind = "failed"
+	EventRollingBack EventKind = "rolling_back"
+	EventRolledBack  EventKind = "rolled_back"
+	EventDestroyed   EventKind = "destroyed"
+)
+
+// Event reports progress on a single machine.
+type Event struct {
+	Kind         EventKind
+	ProcessGroup string
+	MachineID    string
+	// Err is set for EventFailed.
+	Err error
+}
+
+// Run rolls the app's machines out to the configs in opts, and returns once
+// every machine is updated, or once the ones that were updated have been
+// rolled back after a failure. The error reports what failed, along with
+// anything that went wrong rolling back.
+func Run(ctx context.Context, client *flaps.Client, appName string, opts Options) error {
+	if opts.Strategy == "" {
+		opts.Strategy = Rolling
+	}
+	if opts.MaxUnavailable <= 0 {
+		opts.MaxUnavailable = 1
+	}
+	if opts.WaitTimeout <= 0 {
+		opts.WaitTimeout = defaultWaitTimeout
+	}
+
+	machines, err := client.ListActive(ctx, appName)
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[string][]*fly.Machine)
+	for _, m := range machines {
+		if _, ok := opts.Configs[m.ProcessGroup()]; ok {
+			groups[m.ProcessGroup()] = append(groups[m.ProcessGroup()], m)
+		}
+	}
+	for _, ms := range groups {
+		slices.SortFunc(ms, func(a, b *fly.Machine) int {
+			return strings.Compare(a.ID, b.ID)
+		})
+	}
+
+	r := &rollout{
+		client:  client,
+		appName: appName,
+		opts:    opts,
+		groups:  groups,
+	}
+
+	switch opts.Strategy {
+	case Immediate:
+		return r.immediate(ctx)
+	case Rolling:
+		return r.rolling(ctx, 0)
+	case Canary:
+		return r.canary(ctx)
+	case BlueGreen:
+		return r.blueGreen(ctx)
+	default:
+		return fmt.Errorf("unknown rollout strategy %q", opts.Strategy)
+	}
+}
+
+type rollout struct {
+	client  *flaps.Client
+	appName string
+	opts    Options
+	groups  map[string][]*fly.Machine
+
+	mu sync.Mutex
+	// updated holds the machines that were updated in place, as they were
+	// before the update.
+	updated []*fly.Machine
+	// launched holds the machines bluegreen launched.
+	launched []*fly.Machine
+	// cordoned holds the machines bluegreen cordoned on the way out.
+	cordoned []*fly.Machine
+}
+
+// groupNames returns the process groups being rolled out, in a stable order.
+func (r *rollout) groupNames() []string {
+	return slices.Sorted(maps.Keys(r.groups))
+}
+
+func (r *rollout) emit(ctx context.Context, e Event) {
+	if r.opts.Events == nil {
+		return
+	}
+
+	select {
+	case r.opts.Events <- e:
+	case <-ctx.Done():
+	}
+}
+
+func (r *rollout) fail(ctx context.Context, m *fly.Machine, err error) error {
+	r.emit(ctx, Event{Kind: EventFailed, ProcessGroup: m.ProcessGroup(), MachineID: m.ID, Err: err})
+	return err
+}
+
+func (r *rollout) immediate(ctx context.Context) error {
+	var all []*fly.Machine
+	for _, group := range r.groupNames() {
+		all = append(all, r.groups[group]...)
+	}
+
+	return forEach(all, func(m *fly.Machine) error {
+		return r.update(ctx, m, false)
+	})
+}
+
+// rolling updates all but the first skip machines of every group.
+func (r *rollout) rolling(ctx context.Context, skip int) error {
+	for _, group := range r.groupNames() {
+		machines := r.groups[group]
+		if skip >= len(machines) {
+			continue
+		}
+
+		for batch := range slices.Chunk(machines[skip:], r.opts.MaxUnavailable) {
+			err := forEach(batch, func(m *fly.Machine) error {
+				return r.update(ctx, m, true)
+			})
+			if err != nil {
+				return errors.Join(err, r.rollback(ctx))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *rollout) canary(ctx context.Context) error {
+	for _, group := range r.groupNames() {
+		if err := r.update(ctx, r.groups[group][0], true); err != nil {
+			return errors.Join(err, r.rollback(ctx))
+		}
+	}
+
+	return r.rolling(ctx, 1)
+}
+
+func (r *rollout) blueGreen(ctx context.Context) error {
+	for group, config := range r.opts.Configs {
+		if len(config.Mounts) > 0 {
+			return fmt.Errorf("bluegreen rollouts don't support machines with mounts, but process group %s has some", group)
+		}
+	}
+
+	var blue []*fly.Machine
+	for _, group := range r.groupNames() {
+		blue = append(blue, r.groups[group]...)
+	}
+
+	err := forEach(blue, func(m *fly.Machine) error {
+		config, err := r.config(m.ProcessGroup())
+		if err != nil {
+			return r.fail(ctx, m, err)
+		}
+
+		green, err := r.client.Launch(ctx, r.appName, fly.LaunchMachineInput{
+			Region: m.Region,
+			Config: config,
+		})
+		if err != nil {
+			return r.fail(ctx, m, err)
+		}
+
+		r.mu.Lock()
+		r.launched = append(r.launched, green)
+		r.mu.Unlock()
+		r.emit(ctx, Event{Kind: EventLaunched, ProcessGroup: green.ProcessGroup(), MachineID: green.ID})
+
+		if err := r.waitHealthy(ctx, green); err != nil {
+			return r.fail(ctx, green, err)
+		}
+		r.emit(ctx, Event{Kind: EventHealthy, ProcessGroup: green.ProcessGroup(), MachineID: green.ID})
+
+		return nil
+	})
+	if err != nil {
+		return errors.Join(err, r.rollback(ctx))
+	}
+
+	// Cordon every blue machine before destroying any, so traffic moves to
+	// the green machines all at once.
+	err = forEach(blue, func(m *fly.Machine) error {
+		err := r.client.WithLease(ctx, r.appName, m.ID, r.opts.LeaseTTL, func(ctx context.Context, nonce string) error {
+			return r.client.Cordon(ctx, r.appName, m.ID, nonce)
+		})
+		if err != nil {
+			return r.fail(ctx, m, err)
+		}
+
+		r.mu.Lock()
+		r.cordoned = append(r.cordoned, m)
+		r.mu.Unlock()
+
+		return nil
+	})
+	if err != nil {
+		return errors.Join(err, r.rollback(ctx))
+	}
+
+	// The green machines are serving traffic by now, so there's no going
+	// back: a blue machine that can't be destroyed is left cordoned.
+	return forEach(blue, func(m *fly.Machine) error {
+		err := r.client.WithLease(ctx, r.appName, m.ID, r.opts.LeaseTTL, func(ctx context.Context, nonce string) error {
+			return r.client.Destroy(ctx, r.appName, fly.RemoveMachineInput{ID: m.ID, Kill: true}, nonce)
+		})
+		if err != nil {
+			return r.fail(ctx, m, err)
+		}
+		r.emit(ctx, Event{Kind: EventDestroyed, ProcessGroup: m.ProcessGroup(), MachineID: m.ID})
+
+		return nil
+	})
+}
+
+// update updates a machine in place to the config of its process group. When
+// wait is set, the machine is kept cordoned until it's healthy. Machines that
+// weren't started, such as ones stopped or suspended by autostop, are updated
+// without being started, so there's nothing to wait for.
+func (r *rollout) update(ctx context.Context, m *fly.Machine, wait bool) error {
+	r.emit(ctx, Event{Kind: EventUpdating, ProcessGroup: m.ProcessGroup(), MachineID: m.ID})
+
+	started := m.State == fly.MachineStateStarted
+	wait = wait && started
+
+	config, err := r.config(m.ProcessGroup())
+	if err != nil {
+		return r.fail(ctx, m, err)
+	}
+
+	err = r.client.WithLease(ctx, r.appName, m.ID, r.opts.LeaseTTL, func(ctx context.Context, nonce string) error {
+		if wait {
+			if err := r.client.Cordon(ctx, r.appName, m.ID, nonce); err != nil {
+				return err
+			}
+		}
+
+		// Recorded before the update is even attempted, as a failed
+		// update may still have changed the machine.
+		r.mu.Lock()
+		r.updated = append(r.updated, m)
+		r.mu.Unlock()
+
+		updated, err := r.client.Update(ctx, r.appName, fly.LaunchMachineInput{
+			ID:         m.ID,
+			Region:     m.Region,
+			Config:     config,
+			SkipLaunch: !started,
+		}, nonce)
+		if err != nil {
+			return err
+		}
+		r.emit(ctx, Event{Kind: EventUpdated, ProcessGroup: m.ProcessGroup(), MachineID: m.ID})
+
+		if !wait {
+			return nil
+		}
+		if err := r.waitHealthy(ctx, updated); err != nil {
+			return err
+		}
+		r.emit(ctx, Event{Kind: EventHealthy, ProcessGroup: m.ProcessGroup(), MachineID: m.ID})
+
+		return r.client.Uncordon(ctx, r.appName, m.ID, nonce)
+	})
+	if err != nil {
+		return r.fail(ctx, m, err)
+	}
+
+	return nil
+}
+
+// rollback undoes whatever the rollout did so far: machines updated in place
+// get their old config back, machines launched are destroyed, and machines
+// cordoned are uncordoned. It carries on after a caller's cancellation, since
+// stopping halfway would leave the app in a worse state.
+func (r *rollout) rollback(ctx context.Context) error {
+	ctx = context.WithoutCancel(ctx)
+
+	r.mu.Lock()
+	updated, launched, cordoned := r.updated, r.launched, r.cordoned
+	r.mu.Unlock()
+
+	restore := forEach(updated, func(m *fly.Machine) error {
+		r.emit(ctx, Event{Kind: EventRollingBack, ProcessGroup: m.ProcessGroup(), MachineID: m.ID})
+
+		err := r.client.WithLease(ctx, r.appName, m.ID, r.opts.LeaseTTL, func(ctx context.Context, nonce string) error {
+			restored, err := r.client.Update(ctx, r.appName, fly.LaunchMachineInput{
+				ID:         m.ID,
+				Region:     m.Region,
+				Config:     m.GetConfig(),
+				SkipLaunch: m.State != fly.MachineStateStarted,
+			}, nonce)
+			if err != nil {
+				return err
+			}
+
+			if m.State == fly.MachineStateStarted {
+				if err := r.waitStarted(ctx, restored); err != nil {
+					return err
+				}
+			}
+			if !m.Cordoned {
+				return r.client.Uncordon(ctx, r.appName, m.ID, nonce)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to roll back machine %s: %w", m.ID, err)
+		}
+		r.emit(ctx, Event{Kind: EventRolledBack, ProcessGroup: m.ProcessGroup(), MachineID: m.ID})
+
+		return nil
+	})
+
+	destroy := forEach(launched, func(m *fly.Machine) error {
+		err := r.client.Destroy(ctx, r.appName, fly.RemoveMachineInput{ID: m.ID, Kill: true}, "")
+		if err != nil {
+			return fmt.Errorf("failed to destroy machine %s: %w", m.ID, err)
+		}
+		r.emit(ctx, Event{Kind: EventDestroyed, ProcessGroup: m.ProcessGroup(), MachineID: m.ID})
+
+		return nil
+	})
+
+	uncordon := forEach(cordoned, func(m *fly.Machine) error {
+		err := r.client.WithLease(ctx, r.appName, m.ID, r.opts.LeaseTTL, func(ctx context.Context, nonce string) error {
+			return r.client.Uncordon(ctx, r.appName, m.ID, nonce)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to uncordon machine %s: %w", m.ID, err)
+		}
+		r.emit(ctx, Event{Kind: EventRolledBack, ProcessGroup: m.ProcessGroup(), MachineID: m.ID})
+
+		return nil
+	})
+
+	return errors.Join(restore, destroy, uncordon)
+}
+
+// waitStarted waits for a machine to reach the started state, re-polling
+// until WaitTimeout when a single Wait runs into its own timeout.
+func (r *rollout) waitStarted(ctx context.Context, m *fly.Machine) error {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.WaitTimeout)
+	defer cancel()
+
+	for {
+		err := r.client.Wait(ctx, r.appName, m.ID,
+			flaps.WithWaitStates(fly.MachineStateStarted),
+			flaps.WithWaitVersion(m.InstanceID),
+			flaps.WithWaitTimeout(r.opts.WaitTimeout),
+		)
+		switch {
+		case err == nil:
+			return nil
+		case ctx.Err() == nil && errors.Is(err, &flaps.FlapsError{ResponseStatusCode: http.StatusRequestTimeout}):
+			continue
+		default:
+			return err
+		}
+	}
+}
+
+// waitHealthy waits for a machine to start and pass all of its health
+// checks.
+func (r *rollout) waitHealthy(ctx context.Context, m *fly.Machine) error {
+	if err := r.waitStarted(ctx, m); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.opts.WaitTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	checks := &fly.HealthCheckStatus{}
+	for {
+		current, err := r.client.Get(ctx, r.appName, m.ID)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			return err
+		case err == nil:
+			checks = current.AllHealthChecks()
+			if current.State != fly.MachineStateStarted {
+				return fmt.Errorf("machine %s is %s instead of started", m.ID, current.State)
+			}
+			if checks.AllPassing() {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("machine %s failed its health checks: %d of %d passing: %w",
+				m.ID, checks.Passing, checks.Total, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// config returns a copy of the target config of a process group, tagged with
+// the group so the machine stays in it.
+func (r *rollout) config(group string) (*fly.MachineConfig, error) {
+	config, err := cloneConfig(r.opts.Configs[group])
+	if err != nil {
+		return nil, fmt.Errorf("invalid config for process group %s: %w", group, err)
+	}
+	if config.Metadata == nil {
+		config.Metadata = make(map[string]string)
+	}
+	config.Metadata[fly.MachineConfigMetadataKeyFlyProcessGroup] = group
+
+	return config, nil
+}
+
+// cloneConfig deep copies a config through its JSON encoding, which is what
+// the API sees of it anyway.
+func cloneConfig(config *fly.MachineConfig) (*fly.MachineConfig, error) {
+	out := new(fly.MachineConfig)
+	if config == nil {
+		return out, nil
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// forEach calls fn on every machine concurrently, and joins the errors.
+func forEach(machines []*fly.Machine, fn func(*fly.Machine) error) error {
+	errs := make([]error, len(machines))
+
+	var wg sync.WaitGroup
+	for i, m := range machines {
+		wg.Go(func() {
+			errs[i] = fn(m)
+		})
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
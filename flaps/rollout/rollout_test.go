@@ -0,0 +1,383 @@
+package rollout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/flaps"
+)
+
+func init() {
+	healthCheckInterval = 10 * time.Millisecond
+}
+
+// fakeMachines is just enough of the Machines API for a rollout. Machines
+// running the image "bad" fail their health checks.
+type fakeMachines struct {
+	mu       sync.Mutex
+	machines map[string]*fly.Machine
+	next     int
+}
+
+func newFakeMachines(t *testing.T, machines ...*fly.Machine) (*fakeMachines, *flaps.Client) {
+	t.Helper()
+
+	fm := &fakeMachines{machines: make(map[string]*fly.Machine)}
+	for _, m := range machines {
+		fm.machines[m.ID] = m
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/apps/my-app/machines", fm.list)
+	mux.HandleFunc("POST /v1/apps/my-app/machines", fm.launch)
+	mux.HandleFunc("GET /v1/apps/my-app/machines/{id}", fm.get)
+	mux.HandleFunc("POST /v1/apps/my-app/machines/{id}", fm.update)
+	mux.HandleFunc("DELETE /v1/apps/my-app/machines/{id}", fm.destroy)
+	mux.HandleFunc("GET /v1/apps/my-app/machines/{id}/wait", fm.ok)
+	mux.HandleFunc("POST /v1/apps/my-app/machines/{id}/lease", fm.lease)
+	mux.HandleFunc("DELETE /v1/apps/my-app/machines/{id}/lease", fm.ok)
+	mux.HandleFunc("POST /v1/apps/my-app/machines/{id}/cordon", fm.cordon(true))
+	mux.HandleFunc("POST /v1/apps/my-app/machines/{id}/uncordon", fm.cordon(false))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	t.Setenv("FLY_FLAPS_BASE_URL", server.URL)
+	client, err := flaps.NewWithOptions(context.Background(), flaps.NewClientOpts{})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+
+	return fm, client
+}
+
+func (fm *fakeMachines) render(w http.ResponseWriter, m *fly.Machine) {
+	out := *m
+	out.Checks = []*fly.MachineCheckStatus{{Name: "http", Status: fly.Passing}}
+	if m.Config.Image == "bad" {
+		out.Checks[0].Status = fly.Critical
+	}
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func (fm *fakeMachines) list(w http.ResponseWriter, r *http.Request) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	out := []*fly.Machine{}
+	for _, m := range fm.machines {
+		out = append(out, m)
+	}
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func (fm *fakeMachines) launch(w http.ResponseWriter, r *http.Request) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	var in fly.LaunchMachineInput
+	_ = json.NewDecoder(r.Body).Decode(&in)
+
+	fm.next++
+	m := &fly.Machine{
+		ID:         fmt.Sprintf("new%d", fm.next),
+		Region:     in.Region,
+		State:      fly.MachineStateStarted,
+		InstanceID: "v1",
+		Config:     in.Config,
+	}
+	fm.machines[m.ID] = m
+	fm.render(w, m)
+}
+
+func (fm *fakeMachines) get(w http.ResponseWriter, r *http.Request) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	m, ok := fm.machines[r.PathValue("id")]
+	if !ok {
+		http.Error(w, `{"error":"machine not found"}`, http.StatusNotFound)
+		return
+	}
+	fm.render(w, m)
+}
+
+func (fm *fakeMachines) update(w http.ResponseWriter, r *http.Request) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	var in fly.LaunchMachineInput
+	_ = json.NewDecoder(r.Body).Decode(&in)
+
+	m := fm.machines[r.PathValue("id")]
+	m.Config = in.Config
+	m.InstanceID += "+"
+	if !in.SkipLaunch {
+		m.State = fly.MachineStateStarted
+	}
+	fm.render(w, m)
+}
+
+func (fm *fakeMachines) destroy(w http.ResponseWriter, r *http.Request) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	delete(fm.machines, r.PathValue("id"))
+}
+
+func (fm *fakeMachines) lease(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(fly.MachineLease{
+		Status: "success",
+		Data: &fly.MachineLeaseData{
+			Nonce:     "nonce-" + r.PathValue("id"),
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+	})
+}
+
+func (fm *fakeMachines) cordon(cordoned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fm.mu.Lock()
+		defer fm.mu.Unlock()
+
+		fm.machines[r.PathValue("id")].Cordoned = cordoned
+	}
+}
+
+func (fm *fakeMachines) ok(w http.ResponseWriter, r *http.Request) {}
+
+// images returns the image and cordon state of every machine, by ID.
+func (fm *fakeMachines) images() map[string]string {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	out := make(map[string]string)
+	for id, m := range fm.machines {
+		out[id] = m.Config.Image
+		if m.Cordoned {
+			out[id] += " (cordoned)"
+		}
+	}
+
+	return out
+}
+
+func appMachine(id, group, image string) *fly.Machine {
+	return &fly.Machine{
+		ID:         id,
+		Region:     "ord",
+		State:      fly.MachineStateStarted,
+		InstanceID: "v1",
+		Config: &fly.MachineConfig{
+			Image: image,
+			Metadata: map[string]string{
+				fly.MachineConfigMetadataKeyFlyProcessGroup: group,
+			},
+		},
+	}
+}
+
+// collect runs a rollout and returns its events, as strings, and its error.
+func collect(t *testing.T, client *flaps.Client, opts Options) ([]string, error) {
+	t.Helper()
+
+	events := make(chan Event)
+	opts.Events = events
+	opts.WaitTimeout = 200 * time.Millisecond
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- Run(context.Background(), client, "my-app", opts)
+		close(events)
+	}()
+
+	var got []string
+	for e := range events {
+		got = append(got, fmt.Sprintf("%s %s", e.Kind, e.MachineID))
+	}
+
+	return got, <-errc
+}
+
+func TestRolling(t *testing.T) {
+	fm, client := newFakeMachines(t,
+		appMachine("m1", "app", "old"),
+		appMachine("m2", "app", "old"),
+		appMachine("w1", "worker", "old"),
+	)
+
+	events, err := collect(t, client, Options{
+		Configs: map[string]*fly.MachineConfig{
+			"app": {Image: "new"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := map[string]string{"m1": "new", "m2": "new", "w1": "old"}
+	if got := fm.images(); !maps.Equal(got, want) {
+		t.Fatalf("machines = %v, want %v", got, want)
+	}
+
+	wantEvents := []string{
+		"updating m1", "updated m1", "healthy m1",
+		"updating m2", "updated m2", "healthy m2",
+	}
+	if !slices.Equal(events, wantEvents) {
+		t.Fatalf("events = %v, want %v", events, wantEvents)
+	}
+}
+
+func TestRollingLeavesStoppedMachinesStopped(t *testing.T) {
+	stopped := appMachine("m2", "app", "old")
+	stopped.State = fly.MachineStateStopped
+	suspended := appMachine("m3", "app", "old")
+	suspended.State = fly.MachineStateSuspended
+	fm, client := newFakeMachines(t, appMachine("m1", "app", "old"), stopped, suspended)
+
+	events, err := collect(t, client, Options{
+		Configs: map[string]*fly.MachineConfig{"app": {Image: "new"}},
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := map[string]string{"m1": "new", "m2": "new", "m3": "new"}
+	if got := fm.images(); !maps.Equal(got, want) {
+		t.Fatalf("machines = %v, want %v", got, want)
+	}
+	for id, state := range map[string]string{"m2": fly.MachineStateStopped, "m3": fly.MachineStateSuspended} {
+		if got := fm.machines[id].State; got != state {
+			t.Errorf("machine %s is %s after the rollout, want it left %s", id, got, state)
+		}
+	}
+	wantEvents := []string{
+		"updating m1", "updated m1", "healthy m1",
+		"updating m2", "updated m2",
+		"updating m3", "updated m3",
+	}
+	if !slices.Equal(events, wantEvents) {
+		t.Fatalf("events = %v, want %v", events, wantEvents)
+	}
+}
+
+func TestRollingRollsBackOnFailedChecks(t *testing.T) {
+	fm, client := newFakeMachines(t,
+		appMachine("m1", "app", "old"),
+		appMachine("m2", "app", "old"),
+		appMachine("m3", "app", "old"),
+	)
+
+	events, err := collect(t, client, Options{
+		Configs:        map[string]*fly.MachineConfig{"app": {Image: "bad"}},
+		MaxUnavailable: 2,
+	})
+	if err == nil || !strings.Contains(err.Error(), "failed its health checks") {
+		t.Fatalf("Run() error = %v, want a health check failure", err)
+	}
+
+	want := map[string]string{"m1": "old", "m2": "old", "m3": "old"}
+	if got := fm.images(); !maps.Equal(got, want) {
+		t.Fatalf("machines = %v, want %v", got, want)
+	}
+	if slices.Contains(events, "updating m3") {
+		t.Fatalf("events = %v, want the rollout to stop after the first batch", events)
+	}
+	for _, want := range []string{"rolled_back m1", "rolled_back m2"} {
+		if !slices.Contains(events, want) {
+			t.Fatalf("events = %v, want %q", events, want)
+		}
+	}
+}
+
+func TestCanary(t *testing.T) {
+	fm, client := newFakeMachines(t,
+		appMachine("m1", "app", "old"),
+		appMachine("m2", "app", "old"),
+	)
+
+	_, err := collect(t, client, Options{
+		Configs:  map[string]*fly.MachineConfig{"app": {Image: "bad"}},
+		Strategy: Canary,
+	})
+	if err == nil {
+		t.Fatal("Run() error = nil, want a health check failure")
+	}
+
+	want := map[string]string{"m1": "old", "m2": "old"}
+	if got := fm.images(); !maps.Equal(got, want) {
+		t.Fatalf("machines = %v, want %v", got, want)
+	}
+}
+
+func TestBlueGreen(t *testing.T) {
+	fm, client := newFakeMachines(t,
+		appMachine("m1", "app", "old"),
+		appMachine("m2", "app", "old"),
+	)
+
+	_, err := collect(t, client, Options{
+		Configs:  map[string]*fly.MachineConfig{"app": {Image: "new"}},
+		Strategy: BlueGreen,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := map[string]string{"new1": "new", "new2": "new"}
+	if got := fm.images(); !maps.Equal(got, want) {
+		t.Fatalf("machines = %v, want %v", got, want)
+	}
+}
+
+func TestBlueGreenRollsBackOnFailedChecks(t *testing.T) {
+	fm, client := newFakeMachines(t,
+		appMachine("m1", "app", "old"),
+		appMachine("m2", "app", "old"),
+	)
+
+	_, err := collect(t, client, Options{
+		Configs:  map[string]*fly.MachineConfig{"app": {Image: "bad"}},
+		Strategy: BlueGreen,
+	})
+	if err == nil {
+		t.Fatal("Run() error = nil, want a health check failure")
+	}
+
+	want := map[string]string{"m1": "old", "m2": "old"}
+	if got := fm.images(); !maps.Equal(got, want) {
+		t.Fatalf("machines = %v, want %v", got, want)
+	}
+}
+
+func TestImmediate(t *testing.T) {
+	fm, client := newFakeMachines(t,
+		appMachine("m1", "app", "old"),
+		appMachine("m2", "app", "old"),
+	)
+
+	// Immediate doesn't look at health checks at all.
+	_, err := collect(t, client, Options{
+		Configs:  map[string]*fly.MachineConfig{"app": {Image: "bad"}},
+		Strategy: Immediate,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := map[string]string{"m1": "bad", "m2": "bad"}
+	if got := fm.images(); !maps.Equal(got, want) {
+		t.Fatalf("machines = %v, want %v", got, want)
+	}
+}
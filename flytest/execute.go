@@ -0,0 +1,214 @@
+package flytest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// object is a GraphQL object being resolved. Its values are either plain
+// values, which are projected onto the selection set through their JSON
+// encoding, or resolvers, which are called with the field's arguments.
+type object map[string]any
+
+// resolver computes the value of a field from its arguments.
+type resolver func(args map[string]any) (any, error)
+
+// codedError is an error with a GraphQL extensions code.
+type codedError struct {
+	code string
+	msg  string
+}
+
+func (e *codedError) Error() string { return e.msg }
+
+func errNotFound(format string, args ...any) error {
+	return &codedError{code: "NOT_FOUND", msg: fmt.Sprintf(format, args...)}
+}
+
+func errUnprocessable(format string, args ...any) error {
+	return &codedError{code: "UNPROCESSABLE", msg: fmt.Sprintf(format, args...)}
+}
+
+// executor runs one operation against a root object.
+type executor struct {
+	vars     map[string]any
+	injected map[string][]fly.Error
+	errs     []fly.Error
+}
+
+func (e *executor) execute(root object, sel ast.SelectionSet) map[string]any {
+	out := make(map[string]any)
+	for _, f := range e.collect(sel) {
+		key := responseKey(f)
+		if errs, ok := e.injected[f.Name]; ok {
+			for _, err := range errs {
+				if err.Path == nil {
+					err.Path = []string{key}
+				}
+				e.errs = append(e.errs, err)
+			}
+			out[key] = nil
+			continue
+		}
+
+		out[key] = e.field(root, f, []string{key})
+	}
+
+	return out
+}
+
+// field resolves one field of an object and projects it onto the field's
+// selection set.
+func (e *executor) field(obj object, f *ast.Field, path []string) any {
+	if f.Name == "__typename" {
+		return f.ObjectDefinition.Name
+	}
+
+	v := lookup(obj, f)
+	if r, ok := v.(resolver); ok {
+		var err error
+		if v, err = r(f.ArgumentMap(e.vars)); err != nil {
+			e.fail(path, err)
+			return nil
+		}
+	}
+
+	return e.value(v, f, path)
+}
+
+func (e *executor) value(v any, f *ast.Field, path []string) any {
+	if len(f.SelectionSet) == 0 {
+		return v
+	}
+
+	switch v := generic(v).(type) {
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = e.value(item, f, append(path[:len(path):len(path)], fmt.Sprint(i)))
+		}
+		return out
+	case object:
+		out := make(map[string]any)
+		for _, sub := range e.collect(f.SelectionSet) {
+			key := responseKey(sub)
+			out[key] = e.field(v, sub, append(path[:len(path):len(path)], key))
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+func (e *executor) fail(path []string, err error) {
+	gqlErr := fly.Error{Message: err.Error(), Path: path}
+	var coded *codedError
+	if errors.As(err, &coded) {
+		gqlErr.Extensions.Code = coded.code
+	}
+	e.errs = append(e.errs, gqlErr)
+}
+
+// collect flattens a selection set into its fields, expanding fragments and
+// honouring @skip and @include. Type conditions are ignored: every object
+// the fake serves is of a single concrete type.
+func (e *executor) collect(sel ast.SelectionSet) []*ast.Field {
+	var out []*ast.Field
+	for _, s := range sel {
+		switch s := s.(type) {
+		case *ast.Field:
+			if e.included(s.Directives) {
+				out = append(out, s)
+			}
+		case *ast.InlineFragment:
+			if e.included(s.Directives) {
+				out = append(out, e.collect(s.SelectionSet)...)
+			}
+		case *ast.FragmentSpread:
+			if e.included(s.Directives) && s.Definition != nil {
+				out = append(out, e.collect(s.Definition.SelectionSet)...)
+			}
+		}
+	}
+
+	return out
+}
+
+func (e *executor) included(directives ast.DirectiveList) bool {
+	if d := directives.ForName("skip"); d != nil && d.ArgumentMap(e.vars)["if"] == true {
+		return false
+	}
+	if d := directives.ForName("include"); d != nil && d.ArgumentMap(e.vars)["if"] == false {
+		return false
+	}
+
+	return true
+}
+
+func responseKey(f *ast.Field) string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+
+	return f.Name
+}
+
+// lookup finds the value of a field in an object. Objects built from this
+// module's types carry Go field names, which follow the response keys the
+// client decodes into, so the alias and case-insensitive matches are tried
+// after the field name.
+func lookup(obj object, f *ast.Field) any {
+	for _, name := range []string{f.Name, f.Alias} {
+		if v, ok := obj[name]; ok {
+			return v
+		}
+	}
+	for _, name := range []string{f.Name, f.Alias} {
+		for k, v := range obj {
+			if strings.EqualFold(k, name) {
+				return v
+			}
+		}
+	}
+
+	return nil
+}
+
+// generic converts v into objects, slices and scalars.
+func generic(v any) any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case object:
+		return v
+	case []object:
+		out := make([]any, len(v))
+		for i, o := range v {
+			out[i] = o
+		}
+		return out
+	case map[string]any:
+		return object(v)
+	case []any:
+		return v
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("flytest: can't encode %T: %v", v, err))
+	}
+	var out any
+	if err := json.Unmarshal(b, &out); err != nil {
+		panic(fmt.Sprintf("flytest: can't decode %T: %v", v, err))
+	}
+	if m, ok := out.(map[string]any); ok {
+		return object(m)
+	}
+
+	return out
+}
@@ -0,0 +1,636 @@
+package flytest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	fly "github.com/superfly/fly-go"
+)
+
+// user is who every change made through the fake is attributed to.
+var user = fly.User{ID: "flytest", Name: "flytest", Email: "flytest@example.com"}
+
+func (s *Server) queryRoot() object {
+	return object{
+		"app": resolver(func(args map[string]any) (any, error) {
+			a, err := s.findApp(first(args, "name", "internalId", "id"))
+			if err != nil {
+				return nil, err
+			}
+			return s.appObject(a), nil
+		}),
+		"apps": resolver(s.resolveApps),
+		"appNameAvailable": resolver(func(args map[string]any) (any, error) {
+			_, taken := s.apps[str(args, "name")]
+			return !taken, nil
+		}),
+		"organization": resolver(func(args map[string]any) (any, error) {
+			o, err := s.findOrganization(first(args, "slug", "id", "name"))
+			if err != nil {
+				return nil, err
+			}
+			return s.organizationObject(o), nil
+		}),
+		"organizations": resolver(func(args map[string]any) (any, error) {
+			nodes := []object{}
+			for _, slug := range slices.Sorted(maps.Keys(s.orgs)) {
+				o := s.orgs[slug]
+				if typ := str(args, "type"); typ == "" || typ == o.Type {
+					nodes = append(nodes, s.organizationObject(o))
+				}
+			}
+			return object{"nodes": nodes, "totalCount": len(nodes)}, nil
+		}),
+	}
+}
+
+func (s *Server) mutationRoot() object {
+	return object{
+		"createApp":           resolver(s.createApp),
+		"deleteApp":           resolver(s.deleteApp),
+		"allocateIpAddress":   resolver(s.allocateIPAddress),
+		"releaseIpAddress":    resolver(s.releaseIPAddress),
+		"addCertificate":      resolver(s.addCertificate),
+		"checkCertificate":    resolver(s.checkCertificate),
+		"deleteCertificate":   resolver(s.deleteCertificate),
+		"addWireGuardPeer":    resolver(s.addWireGuardPeer),
+		"removeWireGuardPeer": resolver(s.removeWireGuardPeer),
+		"setSecrets":          resolver(s.setSecrets),
+		"unsetSecrets":        resolver(s.unsetSecrets),
+		"createRelease":       resolver(s.createRelease),
+		"updateRelease":       resolver(s.updateRelease),
+		"createOrganization":  resolver(s.createOrganization),
+		"deleteOrganization":  resolver(s.deleteOrganization),
+		"validateWireGuardPeers": resolver(func(args map[string]any) (any, error) {
+			valid := make(map[string]bool)
+			for _, o := range s.orgs {
+				for _, peer := range o.peers {
+					valid[peer.Peerip] = true
+				}
+			}
+			invalid := []string{}
+			for _, ip := range strs(input(args), "peerIps") {
+				if !valid[ip] {
+					invalid = append(invalid, ip)
+				}
+			}
+			return object{"invalidPeerIps": invalid}, nil
+		}),
+	}
+}
+
+func (s *Server) findApp(nameOrID string) (*app, error) {
+	if a, ok := s.apps[nameOrID]; ok {
+		return a, nil
+	}
+	for _, a := range s.apps {
+		if a.ID == nameOrID {
+			return a, nil
+		}
+	}
+
+	return nil, errNotFound("Could not find App %q", nameOrID)
+}
+
+func (s *Server) findOrganization(slugOrID string) (*organization, error) {
+	if o, ok := s.orgs[slugOrID]; ok {
+		return o, nil
+	}
+	for _, o := range s.orgs {
+		if o.ID == slugOrID || o.Name == slugOrID {
+			return o, nil
+		}
+	}
+
+	return nil, errNotFound("Could not find Organization %q", slugOrID)
+}
+
+func (s *Server) appObject(a *app) object {
+	obj := generic(a.App).(object)
+	obj["organization"] = s.organizationObject(s.orgs[a.Organization.Slug])
+	obj["role"] = a.PostgresAppRole
+	obj["currentReleaseUnprocessed"] = a.CurrentRelease
+	obj["releases"] = resolver(a.resolveReleases)
+	obj["releasesUnprocessed"] = resolver(a.resolveReleases)
+	obj["certificate"] = resolver(func(args map[string]any) (any, error) {
+		hostname := str(args, "hostname")
+		if i := a.certificateIndex(hostname); i >= 0 {
+			return a.Certificates.Nodes[i], nil
+		}
+		return nil, errNotFound("Could not find Certificate %q", hostname)
+	})
+	obj["ipAddress"] = resolver(func(args map[string]any) (any, error) {
+		address := str(args, "address")
+		if i := a.ipAddressIndex("", address); i >= 0 {
+			return a.IPAddresses.Nodes[i], nil
+		}
+		return nil, errNotFound("Could not find IPAddress %q", address)
+	})
+
+	return obj
+}
+
+func (a *app) resolveReleases(args map[string]any) (any, error) {
+	nodes := a.Releases.Nodes
+	if n, ok := intArg(args, "first"); ok && n < len(nodes) {
+		nodes = nodes[:n]
+	}
+
+	return object{"nodes": nodes, "totalCount": len(a.Releases.Nodes)}, nil
+}
+
+func (s *Server) organizationObject(o *organization) object {
+	obj := generic(o.Organization).(object)
+	obj["wireGuardPeer"] = resolver(func(args map[string]any) (any, error) {
+		name := str(args, "name")
+		if peer, ok := o.peers[name]; ok {
+			return peer, nil
+		}
+		return nil, errNotFound("Could not find WireGuardPeer %q", name)
+	})
+	obj["wireGuardPeers"] = object{"nodes": o.peerList(), "totalCount": len(o.peers)}
+	obj["delegatedWireGuardTokens"] = object{"nodes": []any{}, "totalCount": 0}
+	obj["apps"] = resolver(func(args map[string]any) (any, error) {
+		nodes := []object{}
+		for _, name := range slices.Sorted(maps.Keys(s.apps)) {
+			if a := s.apps[name]; a.Organization.Slug == o.Slug {
+				nodes = append(nodes, s.appObject(a))
+			}
+		}
+		return object{"nodes": nodes, "totalCount": len(nodes)}, nil
+	})
+
+	return obj
+}
+
+func (o *organization) peerList() []*fly.WireGuardPeer {
+	out := []*fly.WireGuardPeer{}
+	for _, name := range slices.Sorted(maps.Keys(o.peers)) {
+		peer := *o.peers[name]
+		out = append(out, &peer)
+	}
+
+	return out
+}
+
+// resolveApps lists apps in order of their names, which double as cursors.
+func (s *Server) resolveApps(args map[string]any) (any, error) {
+	var orgSlug string
+	if orgID := str(args, "organizationId"); orgID != "" {
+		o, err := s.findOrganization(orgID)
+		if err != nil {
+			return nil, err
+		}
+		orgSlug = o.Slug
+	}
+	role := str(args, "role")
+	after := str(args, "after")
+
+	var names []string
+	for _, name := range slices.Sorted(maps.Keys(s.apps)) {
+		a := s.apps[name]
+		switch {
+		case orgSlug != "" && a.Organization.Slug != orgSlug:
+		case role != "" && (a.PostgresAppRole == nil || a.PostgresAppRole.Name != role):
+		case after != "" && name <= after:
+		default:
+			names = append(names, name)
+		}
+	}
+
+	hasNextPage := false
+	if n, ok := intArg(args, "first"); ok && n < len(names) {
+		names, hasNextPage = names[:n], true
+	}
+
+	nodes := []object{}
+	endCursor := ""
+	for _, name := range names {
+		nodes = append(nodes, s.appObject(s.apps[name]))
+		endCursor = name
+	}
+
+	return object{
+		"nodes":      nodes,
+		"totalCount": len(nodes),
+		"pageInfo":   object{"hasNextPage": hasNextPage, "endCursor": endCursor},
+	}, nil
+}
+
+func (s *Server) createApp(args map[string]any) (any, error) {
+	in := input(args)
+	name := str(in, "name")
+	if _, ok := s.apps[name]; ok {
+		return nil, errUnprocessable("Name has already been taken")
+	}
+	o, err := s.findOrganization(str(in, "organizationId"))
+	if err != nil {
+		return nil, err
+	}
+
+	a := s.addApp(fly.App{Name: name, Network: str(in, "network")}, o)
+	if role := str(in, "appRoleId"); role != "" {
+		a.PostgresAppRole = &struct{ Name string }{Name: role}
+	}
+
+	return object{"app": s.appObject(a)}, nil
+}
+
+func (s *Server) deleteApp(args map[string]any) (any, error) {
+	a, err := s.findApp(str(args, "appId"))
+	if err != nil {
+		return nil, err
+	}
+	delete(s.apps, a.Name)
+
+	return object{"organization": s.organizationObject(s.orgs[a.Organization.Slug])}, nil
+}
+
+func (s *Server) createOrganization(args map[string]any) (any, error) {
+	name := str(input(args), "name")
+	if _, err := s.findOrganization(name); err == nil {
+		return nil, errUnprocessable("Name has already been taken")
+	}
+	o := s.addOrganization(fly.Organization{Name: name})
+
+	return object{"organization": s.organizationObject(o)}, nil
+}
+
+func (s *Server) deleteOrganization(args map[string]any) (any, error) {
+	o, err := s.findOrganization(str(input(args), "organizationId"))
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range s.apps {
+		if a.Organization.Slug == o.Slug {
+			return nil, errUnprocessable("Organization %s still has apps", o.Slug)
+		}
+	}
+	delete(s.orgs, o.Slug)
+
+	return object{"deletedOrganizationId": o.ID}, nil
+}
+
+func (s *Server) allocateIPAddress(args map[string]any) (any, error) {
+	in := input(args)
+	a, err := s.findApp(str(in, "appId"))
+	if err != nil {
+		return nil, err
+	}
+
+	s.seq++
+	ip := fly.IPAddress{
+		ID:        fmt.Sprintf("ip_%d", s.seq),
+		Type:      str(in, "type"),
+		Region:    str(in, "region"),
+		CreatedAt: s.now(),
+	}
+	if ip.Region == "" {
+		ip.Region = "global"
+	}
+	switch ip.Type {
+	case "shared_v4":
+		if a.SharedIPAddress == "" {
+			a.SharedIPAddress = fmt.Sprintf("198.51.100.%d", s.seq%256)
+		}
+		return object{"app": s.appObject(a), "ipAddress": nil}, nil
+	case "v4":
+		ip.Address = fmt.Sprintf("203.0.113.%d", s.seq%256)
+	case "v6":
+		ip.Address = fmt.Sprintf("2001:db8::%x", s.seq)
+	case "private_v6":
+		ip.Address = fmt.Sprintf("fdaa:0:1:a7b::%x", s.seq)
+		if network := str(in, "network"); network != "" {
+			ip.Network = &struct {
+				Name         string
+				Organization *struct{ Slug string }
+			}{Name: network, Organization: &struct{ Slug string }{Slug: a.Organization.Slug}}
+		}
+	default:
+		return nil, &codedError{code: "INVALID_ARGUMENTS", msg: fmt.Sprintf("unknown IP address type %q", ip.Type)}
+	}
+	a.IPAddresses.Nodes = append(a.IPAddresses.Nodes, ip)
+
+	return object{"app": s.appObject(a), "ipAddress": ip}, nil
+}
+
+func (a *app) ipAddressIndex(id, address string) int {
+	return slices.IndexFunc(a.IPAddresses.Nodes, func(ip fly.IPAddress) bool {
+		return (id != "" && ip.ID == id) || (address != "" && ip.Address == address)
+	})
+}
+
+func (s *Server) releaseIPAddress(args map[string]any) (any, error) {
+	in := input(args)
+	a, err := s.findApp(str(in, "appId"))
+	if err != nil {
+		return nil, err
+	}
+
+	id, address := str(in, "ipAddressId"), str(in, "ip")
+	switch i := a.ipAddressIndex(id, address); {
+	case i >= 0:
+		a.IPAddresses.Nodes = slices.Delete(a.IPAddresses.Nodes, i, i+1)
+	case address != "" && address == a.SharedIPAddress:
+		a.SharedIPAddress = ""
+	default:
+		return nil, errNotFound("Could not find IPAddress %q", id+address)
+	}
+
+	return object{"app": s.appObject(a), "clientMutationId": nil}, nil
+}
+
+func (a *app) certificateIndex(hostname string) int {
+	return slices.IndexFunc(a.Certificates.Nodes, func(cert fly.AppCertificate) bool {
+		return cert.Hostname == hostname
+	})
+}
+
+func (s *Server) addCertificate(args map[string]any) (any, error) {
+	a, err := s.findApp(str(args, "appId"))
+	if err != nil {
+		return nil, err
+	}
+	hostname := str(args, "hostname")
+	if a.certificateIndex(hostname) >= 0 {
+		return nil, errUnprocessable("Hostname has already been taken")
+	}
+
+	cert := fly.AppCertificate{
+		ID:                    s.nextID("cert_"),
+		Hostname:              hostname,
+		CreatedAt:             s.now(),
+		Source:                "fly",
+		ClientStatus:          "Awaiting configuration",
+		CertificateAuthority:  "lets_encrypt",
+		IsWildcard:            strings.HasPrefix(hostname, "*."),
+		IsApex:                strings.Count(hostname, ".") == 1,
+		DNSValidationHostname: "_acme-challenge." + strings.TrimPrefix(hostname, "*."),
+		DNSValidationTarget:   strings.TrimPrefix(hostname, "*.") + ".flydns.net",
+	}
+	a.Certificates.Nodes = append(a.Certificates.Nodes, cert)
+
+	return object{"app": s.appObject(a), "certificate": cert, "check": fly.HostnameCheck{}}, nil
+}
+
+func (s *Server) checkCertificate(args map[string]any) (any, error) {
+	in := input(args)
+	a, err := s.findApp(str(in, "appId"))
+	if err != nil {
+		return nil, err
+	}
+	hostname := str(in, "hostname")
+	i := a.certificateIndex(hostname)
+	if i < 0 {
+		return nil, errNotFound("Could not find Certificate %q", hostname)
+	}
+
+	return object{"app": s.appObject(a), "certificate": a.Certificates.Nodes[i], "check": fly.HostnameCheck{}}, nil
+}
+
+func (s *Server) deleteCertificate(args map[string]any) (any, error) {
+	a, err := s.findApp(str(args, "appId"))
+	if err != nil {
+		return nil, err
+	}
+	hostname := str(args, "hostname")
+	i := a.certificateIndex(hostname)
+	if i < 0 {
+		return nil, errNotFound("Could not find Certificate %q", hostname)
+	}
+	cert := a.Certificates.Nodes[i]
+	a.Certificates.Nodes = slices.Delete(a.Certificates.Nodes, i, i+1)
+
+	return object{"app": s.appObject(a), "certificate": cert}, nil
+}
+
+func (s *Server) addWireGuardPeer(args map[string]any) (any, error) {
+	in := input(args)
+	o, err := s.findOrganization(str(in, "organizationId"))
+	if err != nil {
+		return nil, err
+	}
+	name := str(in, "name")
+	if _, ok := o.peers[name]; ok {
+		return nil, errUnprocessable("Name has already been taken")
+	}
+
+	s.seq++
+	peer := &fly.WireGuardPeer{
+		ID:     fmt.Sprintf("peer_%d", s.seq),
+		Name:   name,
+		Pubkey: str(in, "pubkey"),
+		Region: str(in, "region"),
+		Peerip: fmt.Sprintf("fdaa:0:1:a7b:%x::2", s.seq),
+	}
+	if peer.Region == "" {
+		peer.Region = "iad"
+	}
+	o.peers[name] = peer
+
+	return object{
+		"peerip":     peer.Peerip,
+		"endpointip": "192.0.2.1",
+		"pubkey":     peer.Pubkey,
+		"network":    in["network"],
+	}, nil
+}
+
+func (s *Server) removeWireGuardPeer(args map[string]any) (any, error) {
+	in := input(args)
+	o, err := s.findOrganization(str(in, "organizationId"))
+	if err != nil {
+		return nil, err
+	}
+	name := str(in, "name")
+	if _, ok := o.peers[name]; !ok {
+		return nil, errNotFound("Could not find WireGuardPeer %q", name)
+	}
+	delete(o.peers, name)
+
+	return object{"organization": s.organizationObject(o)}, nil
+}
+
+func (s *Server) setSecrets(args map[string]any) (any, error) {
+	in := input(args)
+	a, err := s.findApp(str(in, "appId"))
+	if err != nil {
+		return nil, err
+	}
+
+	if in["replaceAll"] == true {
+		clear(a.secrets)
+	}
+	secrets, _ := in["secrets"].([]any)
+	for _, secret := range secrets {
+		secret, _ := secret.(map[string]any)
+		a.secrets[str(secret, "key")] = str(secret, "value")
+	}
+	s.syncSecrets(a)
+
+	release := s.addRelease(a, "change_secrets", "Update secrets")
+
+	return object{"app": s.appObject(a), "release": release}, nil
+}
+
+func (s *Server) unsetSecrets(args map[string]any) (any, error) {
+	in := input(args)
+	a, err := s.findApp(str(in, "appId"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range strs(in, "keys") {
+		delete(a.secrets, key)
+	}
+	s.syncSecrets(a)
+
+	release := s.addRelease(a, "change_secrets", "Remove secrets")
+
+	return object{"app": s.appObject(a), "release": release}, nil
+}
+
+// syncSecrets brings the secrets listed on an app in line with their
+// values, keeping the creation times of the ones that were already there.
+func (s *Server) syncSecrets(a *app) {
+	created := make(map[string]fly.Secret)
+	for _, secret := range a.Secrets {
+		created[secret.Name] = secret
+	}
+
+	a.Secrets = nil
+	for _, name := range slices.Sorted(maps.Keys(a.secrets)) {
+		digest := sha256.Sum256([]byte(a.secrets[name]))
+		secret := fly.Secret{Name: name, Digest: hex.EncodeToString(digest[:8]), CreatedAt: s.now()}
+		if prev, ok := created[name]; ok && prev.Digest == secret.Digest {
+			secret.CreatedAt = prev.CreatedAt
+		}
+		a.Secrets = append(a.Secrets, secret)
+	}
+}
+
+// addRelease adds a complete release to an app and makes it current.
+func (s *Server) addRelease(a *app, reason, description string) fly.Release {
+	release := fly.Release{
+		ID:          s.nextID("release_"),
+		Version:     a.Version + 1,
+		Stable:      true,
+		Reason:      reason,
+		Description: description,
+		Status:      "complete",
+		User:        user,
+		CreatedAt:   s.now(),
+	}
+	if a.CurrentRelease != nil {
+		release.ImageRef = a.CurrentRelease.ImageRef
+	}
+	a.Version = release.Version
+	a.Releases.Nodes = append([]fly.Release{release}, a.Releases.Nodes...)
+	current := release
+	a.CurrentRelease = &current
+
+	return release
+}
+
+func (s *Server) createRelease(args map[string]any) (any, error) {
+	in := input(args)
+	a, err := s.findApp(str(in, "appId"))
+	if err != nil {
+		return nil, err
+	}
+
+	release := fly.Release{
+		ID:                 s.nextID("release_"),
+		Version:            a.Version + 1,
+		Reason:             "deploy",
+		Description:        "Deploy image",
+		Status:             "pending",
+		DeploymentStrategy: str(in, "strategy"),
+		ImageRef:           str(in, "image"),
+		User:               user,
+		CreatedAt:          s.now(),
+	}
+	a.Version = release.Version
+	a.Releases.Nodes = append([]fly.Release{release}, a.Releases.Nodes...)
+
+	return object{"app": s.appObject(a), "release": release}, nil
+}
+
+func (s *Server) updateRelease(args map[string]any) (any, error) {
+	in := input(args)
+	id := str(in, "releaseId")
+	for _, a := range s.apps {
+		for i := range a.Releases.Nodes {
+			release := &a.Releases.Nodes[i]
+			if release.ID != id {
+				continue
+			}
+			if status := str(in, "status"); status != "" {
+				release.Status = status
+			}
+			if release.Status == "complete" {
+				release.Stable = true
+				current := *release
+				a.CurrentRelease = &current
+			}
+			return object{"release": *release}, nil
+		}
+	}
+
+	return nil, errNotFound("Could not find Release %q", id)
+}
+
+// input returns the input object argument of a mutation.
+func input(args map[string]any) map[string]any {
+	in, _ := args["input"].(map[string]any)
+	return in
+}
+
+func str(m map[string]any, key string) string {
+	v, _ := m[key].(string)
+	return v
+}
+
+func strs(m map[string]any, key string) []string {
+	vs, _ := m[key].([]any)
+	out := make([]string, 0, len(vs))
+	for _, v := range vs {
+		if v, ok := v.(string); ok {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
+
+func intArg(m map[string]any, key string) (int, bool) {
+	switch v := m[key].(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return int(n), err == nil
+	}
+
+	return 0, false
+}
+
+// first returns the first of the string arguments that is set.
+func first(args map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if v := str(args, key); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,346 @@
+// Package flytest provides a fake of the GraphQL API for tests of code that
+// drives a fly.Client.
+//
+// The fake validates every request against the schema the client is written
+// against, and serves apps, organizations, IP addresses, certificates,
+// WireGuard peers, releases and secrets from memory. Errors, with the
+// extension codes the API uses, can be injected for any root field.
+//
+//	srv := flytest.NewServer()
+//	defer srv.Close()
+//	srv.AddApp(fly.App{Name: "my-app", Organization: fly.Organization{Slug: "personal"}})
+//	client := srv.NewClient()
+//	app, err := client.GetApp(ctx, "my-app")
+package flytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/validator"
+)
+
+// Server is a fake GraphQL API. The zero value is not usable; use NewServer.
+type Server struct {
+	*httptest.Server
+
+	schema *ast.Schema
+
+	mu       sync.Mutex
+	orgs     map[string]*organization // by slug
+	apps     map[string]*app          // by name
+	injected map[string][]fly.Error   // by root field
+	// seq numbers everything the server hands out IDs and addresses for.
+	seq int
+}
+
+type organization struct {
+	fly.Organization
+	peers map[string]*fly.WireGuardPeer
+}
+
+type app struct {
+	fly.App
+	secrets map[string]string
+}
+
+// NewServer starts a fake GraphQL API. Close it when done.
+func NewServer() *Server {
+	schema, err := gqlparser.LoadSchema(&ast.Source{Name: "schema.graphql", Input: fly.GraphQLSchema()})
+	if err != nil {
+		panic(fmt.Sprintf("flytest: can't load the GraphQL schema: %v", err))
+	}
+
+	s := &Server{
+		schema:   schema,
+		orgs:     make(map[string]*organization),
+		apps:     make(map[string]*app),
+		injected: make(map[string][]fly.Error),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /graphql", s.serveGraphQL)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// NewClient returns a client that talks to the server.
+func (s *Server) NewClient() *fly.Client {
+	return fly.NewClientFromOptions(fly.ClientOptions{
+		AccessToken: "flytest",
+		BaseURL:     s.URL,
+		Name:        "flytest",
+		Version:     "0.0.0",
+	})
+}
+
+// AddOrganization adds an organization, and returns a copy of it as stored.
+// An ID, slug and type are filled in if missing.
+func (s *Server) AddOrganization(org fly.Organization) fly.Organization {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addOrganization(org).Organization
+}
+
+func (s *Server) addOrganization(org fly.Organization) *organization {
+	if org.Slug == "" {
+		org.Slug = strings.ToLower(strings.ReplaceAll(org.Name, " ", "-"))
+	}
+	if o, ok := s.orgs[org.Slug]; ok {
+		return o
+	}
+
+	if org.ID == "" {
+		org.ID = s.nextID("org_")
+	}
+	if org.Name == "" {
+		org.Name = org.Slug
+	}
+	if org.RawSlug == "" {
+		org.RawSlug = org.Slug
+	}
+	if org.Type == "" {
+		org.Type = string(fly.OrganizationTypeShared)
+		if org.Slug == "personal" {
+			org.Type = string(fly.OrganizationTypePersonal)
+		}
+	}
+
+	o := &organization{Organization: org, peers: make(map[string]*fly.WireGuardPeer)}
+	s.orgs[org.Slug] = o
+
+	return o
+}
+
+// Organization returns a copy of an organization, with its WireGuard peers.
+func (s *Server) Organization(slug string) (fly.Organization, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orgs[slug]
+	if !ok {
+		return fly.Organization{}, false
+	}
+
+	out := o.Organization
+	peers := o.peerList()
+	out.WireGuardPeers.Nodes = &peers
+
+	return out, true
+}
+
+// AddApp adds an app, and the organization named by its slug if that doesn't
+// exist yet, and returns a copy of it as stored. An ID, hostname and status
+// are filled in if missing.
+func (s *Server) AddApp(a fly.App) fly.App {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a.Organization.Slug == "" {
+		a.Organization.Slug = "personal"
+	}
+	org := s.addOrganization(a.Organization)
+
+	return s.addApp(a, org).App
+}
+
+func (s *Server) addApp(a fly.App, org *organization) *app {
+	if a.ID == "" {
+		a.ID = a.Name
+	}
+	if a.Hostname == "" {
+		a.Hostname = a.Name + ".fly.dev"
+	}
+	if a.AppURL == "" {
+		a.AppURL = "https://" + a.Hostname
+	}
+	if a.Status == "" {
+		a.Status = "pending"
+	}
+	if a.PlatformVersion == "" {
+		a.PlatformVersion = "machines"
+	}
+	if a.Network == "" {
+		a.Network = "default"
+	}
+	a.Organization = org.Organization
+
+	stored := &app{App: a, secrets: make(map[string]string)}
+	for _, secret := range a.Secrets {
+		stored.secrets[secret.Name] = ""
+	}
+	s.apps[a.Name] = stored
+
+	return stored
+}
+
+// App returns a copy of an app, with its IP addresses, certificates,
+// releases and secrets.
+func (s *Server) App(name string) (fly.App, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.apps[name]
+	if !ok {
+		return fly.App{}, false
+	}
+
+	out := a.App
+	if o, ok := s.orgs[a.Organization.Slug]; ok {
+		out.Organization = o.Organization
+	}
+
+	return out, true
+}
+
+// Secrets returns the values of an app's secrets, by name. Secrets added
+// with AddApp have empty values.
+func (s *Server) Secrets(appName string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.apps[appName]; ok {
+		return maps.Clone(a.secrets)
+	}
+
+	return nil
+}
+
+// InjectErrors makes the server fail every request that selects a root
+// field, such as "app" or "allocateIpAddress", with errs. The errors' paths
+// default to the field's response key. Calling it with no errors makes the
+// field work again.
+//
+//	srv.InjectErrors("app", fly.Error{
+//		Message:    "Could not find App",
+//		Extensions: fly.Extensions{Code: "NOT_FOUND"},
+//	})
+func (s *Server) InjectErrors(field string, errs ...fly.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(errs) == 0 {
+		delete(s.injected, field)
+		return
+	}
+	s.injected[field] = slices.Clone(errs)
+}
+
+// nextID returns a new ID with the given prefix. The caller must hold the
+// lock.
+func (s *Server) nextID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s%d", prefix, s.seq)
+}
+
+func (s *Server) now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+type graphQLRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+}
+
+type graphQLResponse struct {
+	Data   any            `json:"data"`
+	Errors []graphQLError `json:"errors,omitempty"`
+}
+
+type graphQLError struct {
+	Message    string         `json:"message"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+func (s *Server) serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	doc, errs := gqlparser.LoadQuery(s.schema, req.Query)
+	if len(errs) > 0 {
+		out := graphQLResponse{}
+		for _, err := range errs {
+			out.Errors = append(out.Errors, graphQLError{
+				Message:    err.Message,
+				Extensions: map[string]any{"code": "GRAPHQL_VALIDATION_FAILED"},
+			})
+		}
+		writeResponse(w, out)
+		return
+	}
+
+	op := doc.Operations.ForName(req.OperationName)
+	if op == nil {
+		writeResponse(w, graphQLResponse{Errors: []graphQLError{{
+			Message: fmt.Sprintf("no operation named %q", req.OperationName),
+		}}})
+		return
+	}
+
+	vars, err := validator.VariableValues(s.schema, op, req.Variables)
+	if err != nil {
+		writeResponse(w, graphQLResponse{Errors: []graphQLError{{
+			Message:    err.Error(),
+			Extensions: map[string]any{"code": "INVALID_ARGUMENTS"},
+		}}})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := &executor{vars: vars, injected: s.injected}
+	var root object
+	switch op.Operation {
+	case ast.Mutation:
+		root = s.mutationRoot()
+	case ast.Query:
+		root = s.queryRoot()
+	default:
+		writeResponse(w, graphQLResponse{Errors: []graphQLError{{
+			Message: fmt.Sprintf("flytest: %s operations are not supported", op.Operation),
+		}}})
+		return
+	}
+
+	out := graphQLResponse{Data: e.execute(root, op.SelectionSet)}
+	for _, err := range e.errs {
+		gqlErr := graphQLError{Message: err.Message}
+		for _, p := range err.Path {
+			gqlErr.Path = append(gqlErr.Path, p)
+		}
+		if err.Extensions.Code != "" {
+			gqlErr.Extensions = map[string]any{"code": err.Extensions.Code}
+		}
+		if err.Extensions.ServiceName != "" {
+			if gqlErr.Extensions == nil {
+				gqlErr.Extensions = make(map[string]any)
+			}
+			gqlErr.Extensions["serviceName"] = err.Extensions.ServiceName
+		}
+		out.Errors = append(out.Errors, gqlErr)
+	}
+	writeResponse(w, out)
+}
+
+func writeResponse(w http.ResponseWriter, resp graphQLResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,256 @@
+package flytest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/graphql"
+)
+
+func newTestServer(t *testing.T) (*Server, *fly.Client) {
+	t.Helper()
+
+	srv := NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddApp(fly.App{Name: "my-app", Organization: fly.Organization{Slug: "my-org"}})
+
+	return srv, srv.NewClient()
+}
+
+// errorCode returns the extensions code of the GraphQL error in err's chain.
+func errorCode(err error) string {
+	var gqlErr *graphql.GraphQLError
+	if errors.As(err, &gqlErr) {
+		return gqlErr.Extensions.Code
+	}
+
+	return ""
+}
+
+func TestGetApp(t *testing.T) {
+	_, client := newTestServer(t)
+	ctx := context.Background()
+
+	ip, err := client.AllocateIPAddress(ctx, "my-app", "v6", "", "", "")
+	if err != nil {
+		t.Fatalf("AllocateIPAddress() error = %v", err)
+	}
+	if _, err := client.AllocateSharedIPAddress(ctx, "my-app"); err != nil {
+		t.Fatalf("AllocateSharedIPAddress() error = %v", err)
+	}
+
+	app, err := client.GetApp(ctx, "my-app")
+	if err != nil {
+		t.Fatalf("GetApp() error = %v", err)
+	}
+	if app.Name != "my-app" || app.Hostname != "my-app.fly.dev" || app.Organization.Slug != "my-org" {
+		t.Fatalf("GetApp() = %+v, want my-app in my-org", app)
+	}
+	if len(app.IPAddresses.Nodes) != 1 || app.IPAddresses.Nodes[0].Address != ip.Address {
+		t.Fatalf("GetApp() IP addresses = %+v, want %s", app.IPAddresses.Nodes, ip.Address)
+	}
+	if app.SharedIPAddress == "" {
+		t.Fatal("GetApp() has no shared IP address")
+	}
+
+	if err := client.ReleaseIPAddress(ctx, "my-app", ip.Address); err != nil {
+		t.Fatalf("ReleaseIPAddress() error = %v", err)
+	}
+	ips, err := client.GetIPAddresses(ctx, "my-app")
+	if err != nil {
+		t.Fatalf("GetIPAddresses() error = %v", err)
+	}
+	if len(ips) != 1 || ips[0].Type != "shared_v4" {
+		t.Fatalf("GetIPAddresses() = %+v, want just the shared address", ips)
+	}
+}
+
+func TestGetAppNotFound(t *testing.T) {
+	_, client := newTestServer(t)
+
+	_, err := client.GetApp(context.Background(), "other-app")
+	if errorCode(err) != "NOT_FOUND" {
+		t.Fatalf("GetApp() error = %v, want a NOT_FOUND error", err)
+	}
+}
+
+func TestInjectErrors(t *testing.T) {
+	srv, client := newTestServer(t)
+	ctx := context.Background()
+
+	srv.InjectErrors("app", fly.Error{Message: "down for maintenance", Extensions: fly.Extensions{Code: "MAINTENANCE"}})
+	if _, err := client.GetApp(ctx, "my-app"); errorCode(err) != "MAINTENANCE" {
+		t.Fatalf("GetApp() error = %v, want a MAINTENANCE error", err)
+	}
+
+	srv.InjectErrors("app")
+	if _, err := client.GetApp(ctx, "my-app"); err != nil {
+		t.Fatalf("GetApp() error = %v after clearing the injected errors", err)
+	}
+}
+
+func TestWireGuardPeers(t *testing.T) {
+	srv, client := newTestServer(t)
+	ctx := context.Background()
+
+	org, _ := srv.Organization("my-org")
+	created, err := client.CreateWireGuardPeer(ctx, org.ID, "ord", "laptop", "pubkey", "")
+	if err != nil {
+		t.Fatalf("CreateWireGuardPeer() error = %v", err)
+	}
+
+	peers, err := client.GetWireGuardPeers(ctx, "my-org")
+	if err != nil {
+		t.Fatalf("GetWireGuardPeers() error = %v", err)
+	}
+	if len(peers) != 1 || peers[0].Name != "laptop" || peers[0].Peerip != created.Peerip {
+		t.Fatalf("GetWireGuardPeers() = %+v, want laptop at %s", peers, created.Peerip)
+	}
+
+	if _, err := client.CreateWireGuardPeer(ctx, org.ID, "ord", "laptop", "pubkey", ""); errorCode(err) != "UNPROCESSABLE" {
+		t.Fatalf("CreateWireGuardPeer() of a duplicate error = %v, want UNPROCESSABLE", err)
+	}
+
+	if err := client.RemoveWireGuardPeer(ctx, org.ID, "laptop"); err != nil {
+		t.Fatalf("RemoveWireGuardPeer() error = %v", err)
+	}
+	if _, err := client.GetWireGuardPeer(ctx, "my-org", "laptop"); errorCode(err) != "NOT_FOUND" {
+		t.Fatalf("GetWireGuardPeer() error = %v, want NOT_FOUND", err)
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	srv, client := newTestServer(t)
+	ctx := context.Background()
+
+	release, err := client.SetSecrets(ctx, "my-app", map[string]string{"A": "1", "B": "2"})
+	if err != nil {
+		t.Fatalf("SetSecrets() error = %v", err)
+	}
+	if release.Version != 1 {
+		t.Fatalf("SetSecrets() release version = %d, want 1", release.Version)
+	}
+
+	release, err = client.UnsetSecrets(ctx, "my-app", []string{"A"})
+	if err != nil {
+		t.Fatalf("UnsetSecrets() error = %v", err)
+	}
+	if release.Version != 2 {
+		t.Fatalf("UnsetSecrets() release version = %d, want 2", release.Version)
+	}
+
+	secrets, err := client.GetAppSecrets(ctx, "my-app")
+	if err != nil {
+		t.Fatalf("GetAppSecrets() error = %v", err)
+	}
+	if len(secrets) != 1 || secrets[0].Name != "B" || secrets[0].Digest == "" {
+		t.Fatalf("GetAppSecrets() = %+v, want just B", secrets)
+	}
+	if got := srv.Secrets("my-app"); got["B"] != "2" {
+		t.Fatalf("Secrets() = %v, want B=2", got)
+	}
+
+	releases, err := client.GetAppReleasesMachines(ctx, "my-app", "", 1)
+	if err != nil {
+		t.Fatalf("GetAppReleasesMachines() error = %v", err)
+	}
+	if len(releases) != 1 || releases[0].Version != 2 {
+		t.Fatalf("GetAppReleasesMachines() = %+v, want release 2", releases)
+	}
+}
+
+func TestCertificates(t *testing.T) {
+	_, client := newTestServer(t)
+	ctx := context.Background()
+
+	cert, _, err := client.AddCertificate(ctx, "my-app", "example.com")
+	if err != nil {
+		t.Fatalf("AddCertificate() error = %v", err)
+	}
+	if !cert.IsApex || cert.Hostname != "example.com" {
+		t.Fatalf("AddCertificate() = %+v, want an apex certificate for example.com", cert)
+	}
+
+	certs, err := client.GetAppCertificates(ctx, "my-app")
+	if err != nil {
+		t.Fatalf("GetAppCertificates() error = %v", err)
+	}
+	if len(certs) != 1 || certs[0].Hostname != "example.com" {
+		t.Fatalf("GetAppCertificates() = %+v, want example.com", certs)
+	}
+
+	if _, err := client.DeleteCertificate(ctx, "my-app", "example.com"); err != nil {
+		t.Fatalf("DeleteCertificate() error = %v", err)
+	}
+	if _, _, err := client.CheckAppCertificate(ctx, "my-app", "example.com"); errorCode(err) != "NOT_FOUND" {
+		t.Fatalf("CheckAppCertificate() error = %v, want NOT_FOUND", err)
+	}
+}
+
+func TestAppsAndOrganizations(t *testing.T) {
+	srv, client := newTestServer(t)
+	ctx := context.Background()
+	srv.AddApp(fly.App{Name: "other-app", Organization: fly.Organization{Slug: "personal"}})
+
+	org, err := client.GetOrganizationBySlug(ctx, "my-org")
+	if err != nil {
+		t.Fatalf("GetOrganizationBySlug() error = %v", err)
+	}
+
+	created, err := client.CreateApp(ctx, fly.CreateAppInput{OrganizationID: org.ID, Name: "new-app"})
+	if err != nil {
+		t.Fatalf("CreateApp() error = %v", err)
+	}
+	if created.Organization.Slug != "my-org" {
+		t.Fatalf("CreateApp() organization = %q, want my-org", created.Organization.Slug)
+	}
+
+	apps, err := client.GetAppsForOrganization(ctx, org.ID)
+	if err != nil {
+		t.Fatalf("GetAppsForOrganization() error = %v", err)
+	}
+	var names []string
+	for _, app := range apps {
+		names = append(names, app.Name)
+	}
+	if want := []string{"my-app", "new-app"}; !slices.Equal(names, want) {
+		t.Fatalf("GetAppsForOrganization() = %v, want %v", names, want)
+	}
+
+	orgs, err := client.GetOrganizations(ctx)
+	if err != nil {
+		t.Fatalf("GetOrganizations() error = %v", err)
+	}
+	if len(orgs) != 2 {
+		t.Fatalf("GetOrganizations() = %+v, want my-org and personal", orgs)
+	}
+}
+
+func TestGenqlientRequests(t *testing.T) {
+	srv, client := newTestServer(t)
+	ctx := context.Background()
+
+	resp, err := client.CreateRelease(ctx, fly.CreateReleaseInput{
+		AppId:           "my-app",
+		Image:           "registry.fly.io/my-app:v1",
+		PlatformVersion: "machines",
+		Strategy:        fly.DeploymentStrategyRolling,
+		Definition:      map[string]any{},
+	})
+	if err != nil {
+		t.Fatalf("CreateRelease() error = %v", err)
+	}
+
+	id := resp.CreateRelease.Release.Id
+	if _, err := client.UpdateRelease(ctx, fly.UpdateReleaseInput{ReleaseId: id, Status: "complete"}); err != nil {
+		t.Fatalf("UpdateRelease() error = %v", err)
+	}
+
+	app, _ := srv.App("my-app")
+	if app.CurrentRelease == nil || app.CurrentRelease.ID != id || app.CurrentRelease.ImageRef != "registry.fly.io/my-app:v1" {
+		t.Fatalf("current release = %+v, want %s", app.CurrentRelease, id)
+	}
+}
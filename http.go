@@ -13,6 +13,16 @@ import (
 )
 
 func NewHTTPClient(logger Logger, transport http.RoundTripper) (*http.Client, error) {
+	return NewHTTPClientWithRetryPolicy(logger, transport, nil)
+}
+
+// NewHTTPClientWithRetryPolicy is like NewHTTPClient, but retries requests
+// according to policy instead of the default retries, unless it's nil.
+func NewHTTPClientWithRetryPolicy(logger Logger, transport http.RoundTripper, policy *RetryPolicy) (*http.Client, error) {
+	if policy != nil {
+		return newHTTPClient(logger, policy.Transport(transport)), nil
+	}
+
 	retryTransport := rehttp.NewTransport(
 		transport,
 		rehttp.RetryAll(
@@ -25,18 +35,22 @@ func NewHTTPClient(logger Logger, transport http.RoundTripper) (*http.Client, er
 		rehttp.ExpJitterDelay(100*time.Millisecond, 1*time.Second),
 	)
 
+	return newHTTPClient(logger, retryTransport), nil
+}
+
+func newHTTPClient(logger Logger, retryTransport http.RoundTripper) *http.Client {
 	if logger != nil {
 		return &http.Client{
 			Transport: &LoggingTransport{
 				InnerTransport: retryTransport,
 				Logger:         logger,
 			},
-		}, nil
+		}
 	}
 
 	return &http.Client{
 		Transport: retryTransport,
-	}, nil
+	}
 }
 
 type LoggingTransport struct {
@@ -0,0 +1,161 @@
+// Command jsonschemadocs extracts the doc comments of the structs in the given
+// Go files, and their fields, into the descriptions fly.JSONSchema gives
+// them, which it can't get from reflection.
+//
+//	go run ./internal/cmd/jsonschemadocs -out jsonschema_docs.go machine_types.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+func main() {
+	out := flag.String("out", "jsonschema_docs.go", "file to write")
+	flag.Parse()
+
+	src, err := generate(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generate returns the source of the descriptions of the structs in paths.
+func generate(paths []string) ([]byte, error) {
+	types := make(map[string]string)
+	fields := make(map[string]map[string]string)
+
+	fset := token.NewFileSet()
+	for _, path := range paths {
+		f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+
+				doc := ts.Doc
+				if doc == nil && len(gd.Specs) == 1 {
+					doc = gd.Doc
+				}
+				if desc := typeDescription(doc); desc != "" {
+					types[ts.Name.Name] = desc
+				}
+
+				for _, field := range st.Fields.List {
+					desc := description(field.Doc)
+					if desc == "" {
+						desc = description(field.Comment)
+					}
+					if desc == "" {
+						continue
+					}
+					for _, name := range field.Names {
+						if fields[ts.Name.Name] == nil {
+							fields[ts.Name.Name] = make(map[string]string)
+						}
+						fields[ts.Name.Name][name.Name] = desc
+					}
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by jsonschemadocs from %s; DO NOT EDIT.\n\n", strings.Join(paths, ", "))
+	fmt.Fprintf(&buf, "package fly\n\n")
+	fmt.Fprintf(&buf, "// typeDescriptions are the descriptions of structs in their JSON schemas.\n")
+	fmt.Fprintf(&buf, "var typeDescriptions = map[string]string{\n")
+	for _, name := range slices.Sorted(maps.Keys(types)) {
+		fmt.Fprintf(&buf, "%q: %q,\n", name, types[name])
+	}
+	fmt.Fprintf(&buf, "}\n\n")
+	fmt.Fprintf(&buf, "// fieldDescriptions are the descriptions of struct fields in their JSON\n// schemas, by struct and field name.\n")
+	fmt.Fprintf(&buf, "var fieldDescriptions = map[string]map[string]string{\n")
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		fmt.Fprintf(&buf, "%q: {\n", name)
+		for _, field := range slices.Sorted(maps.Keys(fields[name])) {
+			fmt.Fprintf(&buf, "%q: %q,\n", field, fields[name][field])
+		}
+		fmt.Fprintf(&buf, "},\n")
+	}
+	fmt.Fprintf(&buf, "}\n")
+
+	return format.Source(buf.Bytes())
+}
+
+// typeDescription returns the @description annotation of a type's doc comment
+// if it has one, and the doc comment otherwise.
+func typeDescription(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+
+	var annotated []string
+	for _, line := range strings.Split(doc.Text(), "\n") {
+		if rest, ok := strings.CutPrefix(line, "@description"); ok {
+			annotated = append(annotated, strings.TrimSpace(rest))
+		}
+	}
+	if len(annotated) > 0 {
+		return strings.Join(annotated, "\n")
+	}
+
+	return description(doc)
+}
+
+// description unwraps a comment's lines into paragraphs, keeping list items
+// on their own lines, and drops swag annotations and TODOs.
+func description(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, line := range strings.Split(doc.Text(), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "@"), strings.HasPrefix(line, "TODO"):
+			continue
+		case line == "":
+			if b.Len() > 0 {
+				b.WriteString("\n\n")
+			}
+			continue
+		}
+
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			if strings.HasPrefix(line, "* ") || strings.HasPrefix(line, "- ") {
+				b.WriteString("\n")
+			} else {
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(line)
+	}
+
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestGeneratedDocsAreUpToDate(t *testing.T) {
+	if err := os.Chdir("../../.."); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := generate([]string{"machine_types.go", "volume_types.go", "secrets_types.go"})
+	if err != nil {
+		t.Fatalf("generate() error = %v", err)
+	}
+	got, err := os.ReadFile("jsonschema_docs.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("jsonschema_docs.go is out of date; run go generate")
+	}
+}
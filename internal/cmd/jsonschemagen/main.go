@@ -0,0 +1,94 @@
+// Command jsonschemagen writes the JSON schemas of the machine, volume and
+// secrets types, for use outside of Go, and an OpenAPI document with all of
+// them as components.
+//
+//	go run ./internal/cmd/jsonschemagen -out schemas
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"unicode"
+
+	fly "github.com/superfly/fly-go"
+)
+
+// types are the types to write schemas of.
+var types = []any{
+	fly.MachineConfig{},
+	fly.Machine{},
+	fly.LaunchMachineInput{},
+	fly.Volume{},
+	fly.VolumeSnapshot{},
+	fly.CreateVolumeRequest{},
+	fly.UpdateVolumeRequest{},
+	fly.AppSecret{},
+	fly.UpdateAppSecretsRequest{},
+	fly.SecretKey{},
+	fly.SetSecretKeyRequest{},
+}
+
+func main() {
+	out := flag.String("out", "schemas", "directory to write the schemas to")
+	flag.Parse()
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		log.Fatal(err)
+	}
+
+	components := make(map[string]json.RawMessage)
+	for _, v := range types {
+		schema := fly.JSONSchema(v)
+		name := reflect.TypeOf(v).Name()
+		if err := os.WriteFile(filepath.Join(*out, snakeCase(name)+".schema.json"), append(schema, '\n'), 0o644); err != nil {
+			log.Fatal(err)
+		}
+
+		var s struct {
+			Defs map[string]json.RawMessage `json:"$defs"`
+		}
+		if err := json.Unmarshal(schema, &s); err != nil {
+			log.Fatal(err)
+		}
+		for name, def := range s.Defs {
+			components[name] = def
+		}
+	}
+
+	doc := map[string]any{
+		"openapi":           "3.1.0",
+		"jsonSchemaDialect": fly.JSONSchemaDialect,
+		"info":              map[string]any{"title": "Fly Machines API types", "version": "v1"},
+		"paths":             map[string]any{},
+		"components":        map[string]any{"schemas": components},
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	b = bytes.ReplaceAll(b, []byte(`"#/$defs/`), []byte(`"#/components/schemas/`))
+	if err := os.WriteFile(filepath.Join(*out, "openapi.json"), append(b, '\n'), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// snakeCase turns MachineConfig into machine_config.
+func snakeCase(s string) string {
+	var b []rune
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b = append(b, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b = append(b, r)
+	}
+
+	return string(b)
+}
@@ -0,0 +1,28 @@
+// Package retryafter parses the Retry-After header, for the clients' retries
+// and rate limiting to share.
+package retryafter
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Parse parses a Retry-After header, which holds either a number of seconds
+// or an HTTP date, into how long to wait. A date in the past is no wait.
+func Parse(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(time.Until(at), 0), true
+	}
+
+	return 0, false
+}
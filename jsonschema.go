@@ -0,0 +1,238 @@
+package fly
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+//go:generate go run ./internal/cmd/jsonschemadocs -out jsonschema_docs.go machine_types.go volume_types.go secrets_types.go
+//go:generate go run ./internal/cmd/jsonschemagen -out schemas
+
+// JSONSchemaDialect is the version of JSON Schema the schemas are written in.
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// MachineConfigJSONSchema returns a JSON Schema for MachineConfig. See
+// JSONSchema.
+func MachineConfigJSONSchema() []byte {
+	return JSONSchema(MachineConfig{})
+}
+
+// JSONSchema returns a JSON Schema for the JSON encoding of v's type, with the
+// types it's made of in $defs. It follows the same struct tags as the API's
+// OpenAPI spec: enums lists a field's allowed values, swaggertype overrides
+// its JSON type, example gives an example value and validate:"required"
+// marks it as required. Descriptions come from the types' doc comments and
+// their @description annotations.
+func JSONSchema(v any) []byte {
+	g := &schemaGenerator{defs: make(map[string]any)}
+	schema := g.schema(reflect.TypeOf(v))
+	schema["$schema"] = JSONSchemaDialect
+	if len(g.defs) > 0 {
+		schema["$defs"] = g.defs
+	}
+
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf("fly: can't encode JSON schema: %v", err))
+	}
+
+	return b
+}
+
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+var (
+	durationType  = reflect.TypeFor[Duration]()
+	timeType      = reflect.TypeFor[time.Time]()
+	marshalerType = reflect.TypeFor[json.Marshaler]()
+)
+
+// schema returns the schema for t. Named structs are added to the defs and
+// referred to, so that recursive types terminate.
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case durationType:
+		return map[string]any{"type": "string", "description": "A duration such as 15s or 1m30s."}
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		// A type with its own encoding needs a swaggertype tag on the
+		// field to say what it is.
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil
+			g.defs[t.Name()] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	}
+
+	// Interfaces, and anything else, can hold any value.
+	return map[string]any{}
+}
+
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	g.fields(t, properties, &required)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if desc := typeDescriptions[t.Name()]; desc != "" {
+		schema["description"] = desc
+	}
+
+	return schema
+}
+
+// fields adds the schemas of t's fields to properties, with the fields of
+// embedded structs inline as encoding/json has them.
+func (g *schemaGenerator) fields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			g.fields(ft, properties, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		schema := g.fieldSchema(f)
+		if desc := fieldDescriptions[t.Name()][f.Name]; desc != "" {
+			schema = withDescription(schema, desc)
+			if strings.HasPrefix(desc, "Deprecated:") || strings.Contains(desc, "\nDeprecated:") {
+				schema["deprecated"] = true
+			}
+		}
+		properties[name] = schema
+
+		if strings.Contains(f.Tag.Get("validate"), "required") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// fieldSchema returns the schema of a field, with its tags applied.
+func (g *schemaGenerator) fieldSchema(f reflect.StructField) map[string]any {
+	var schema map[string]any
+	if st := f.Tag.Get("swaggertype"); st != "" {
+		schema = swaggerTypeSchema(st)
+	} else {
+		schema = g.schema(f.Type)
+	}
+
+	// enums and example apply to the items of an array.
+	if items, ok := schema["items"].(map[string]any); ok {
+		schema["items"] = withEnumsAndExample(items, f.Tag)
+		return schema
+	}
+
+	return withEnumsAndExample(schema, f.Tag)
+}
+
+func withEnumsAndExample(schema map[string]any, tag reflect.StructTag) map[string]any {
+	if enums := tag.Get("enums"); enums != "" {
+		schema = withoutRef(schema)
+		schema["enum"] = strings.Split(enums, ",")
+	}
+	if example := tag.Get("example"); example != "" {
+		var v any = example
+		if schema["type"] != "string" {
+			if err := json.Unmarshal([]byte(example), &v); err != nil {
+				v = example
+			}
+		}
+		schema = withoutRef(schema)
+		schema["examples"] = []any{v}
+	}
+
+	return schema
+}
+
+// swaggerTypeSchema returns the schema for a swaggertype tag, such as "string",
+// "array,integer" or "primitive,integer".
+func swaggerTypeSchema(tag string) map[string]any {
+	parts := strings.Split(tag, ",")
+	switch parts[0] {
+	case "array":
+		items := map[string]any{}
+		if len(parts) > 1 {
+			items = swaggerTypeSchema(strings.Join(parts[1:], ","))
+		}
+		return map[string]any{"type": "array", "items": items}
+	case "primitive":
+		if len(parts) > 1 {
+			return swaggerTypeSchema(parts[1])
+		}
+	case "string", "integer", "number", "boolean", "object":
+		return map[string]any{"type": parts[0]}
+	}
+
+	return map[string]any{}
+}
+
+func withDescription(schema map[string]any, desc string) map[string]any {
+	schema = withoutRef(schema)
+	schema["description"] = desc
+
+	return schema
+}
+
+// withoutRef returns a schema that can have keywords added to it. Not every
+// consumer of a schema allows a $ref to have siblings, so one is wrapped in an
+// allOf.
+func withoutRef(schema map[string]any) map[string]any {
+	if _, ok := schema["$ref"]; !ok {
+		return schema
+	}
+
+	return map[string]any{"allOf": []any{schema}}
+}
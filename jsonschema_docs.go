@@ -0,0 +1,167 @@
+// Code generated by jsonschemadocs from machine_types.go, volume_types.go, secrets_types.go; DO NOT EDIT.
+
+package fly
+
+// typeDescriptions are the descriptions of structs in their JSON schemas.
+var typeDescriptions = map[string]string{
+	"EnvFrom":           "EnvVar defines an environment variable to be populated from a machine field, env_var",
+	"File":              "A file that will be written to the Machine. One of RawValue or SecretName must be set.",
+	"MachineHTTPHeader": "For http checks, an array of objects with string field Name and array of strings field Values. The key/value pairs specify header and header values that will get passed with the check call.",
+	"MachineRestart":    "The Machine restart policy defines whether and how flyd restarts a Machine after its main process exits. See https://fly.io/docs/machines/guides-examples/machine-restart-policy/.",
+	"MachineSecret":     "A Secret needing to be set in the environment of the Machine. env_var is required",
+	"MachineSpot":       "MachineSpot configures spot pricing behavior for a Machine",
+	"TempDirVolume":     "A TempDir is an ephemeral directory tied to the lifecycle of a Machine. It is often used as scratch space, to communicate between containers and so on.",
+}
+
+// fieldDescriptions are the descriptions of struct fields in their JSON
+// schemas, by struct and field name.
+var fieldDescriptions = map[string]map[string]string{
+	"ContainerConfig": {
+		"CmdOverride":        "CmdOverride is used to override the default command of the image.",
+		"DependsOn":          "DependsOn can be used to define dependencies between containers. The container will only be started after all of its dependent conditions have been satisfied.",
+		"EntrypointOverride": "EntrypointOverride is used to override the default entrypoint of the image.",
+		"EnvFrom":            "EnvFrom can be provided to set environment variables from machine fields.",
+		"ExecOverride":       "Image Config overrides - these fields are used to override the image configuration. If not provided, the image configuration will be used. ExecOverride is used to override the default command of the image.",
+		"ExtraEnv":           "ExtraEnv is used to add additional environment variables to the container.",
+		"Files":              "Files are files that will be written to the container file system.",
+		"Healthchecks":       "Healthchecks determine the health of your containers. Healthchecks can use HTTP, TCP or an Exec command.",
+		"Image":              "Image is the docker image to run.",
+		"Mounts":             "Set of mounts added to the container. These must reference a volume in the machine config via its name.",
+		"Name":               "Name is used to identify the container in the machine.",
+		"Restart":            "Restart is used to define the restart policy for the container.",
+		"Secrets":            "Secrets can be provided at the process level to explicitly indicate which secrets should be used for the process. If not provided, the secrets provided at the machine level will be used.",
+		"Stop":               "Stop is used to define the signal and timeout for stopping the container.",
+		"UserOverride":       "UserOverride is used to override the default user of the image.",
+	},
+	"ContainerHealthcheck": {
+		"FailureThreshold": "The number of times the check must fail before considering the container unhealthy.",
+		"GracePeriod":      "The time in seconds to wait after a container starts before checking its health.",
+		"Interval":         "The time in seconds between executing the defined check.",
+		"Kind":             "Kind of healthcheck (readiness, liveness)",
+		"Name":             "The name of the check. Must be unique within the container.",
+		"SuccessThreshold": "The number of times the check must succeeed before considering the container healthy.",
+		"Timeout":          "The time in seconds to wait for the check to complete.",
+		"Unhealthy":        "Unhealthy policy that determines what action to take if a container is deemed unhealthy",
+	},
+	"ContainerMount": {
+		"Name": "The name of the volume. Must exist in the volumes field in the machine configuration",
+		"Path": "The path to mount the volume within the container",
+	},
+	"CreateVolumeRequest": {
+		"ComputeRequirements": "If the volume is going to be attached to a new machine, make the placement logic aware of it",
+		"FSType":              "FSType sets the filesystem of this volume. The valid values are \"ext4\" and \"raw\". Not setting the value results \"ext4\".",
+		"SnapshotID":          "restore from snapshot",
+		"SourceVolumeID":      "fork from remote volume",
+	},
+	"EnvFrom": {
+		"EnvVar":   "EnvVar is required and is the name of the environment variable that will be set from the secret. It must be a valid environment variable name.",
+		"FieldRef": "FieldRef selects a field of the Machine: supports id, version, app_name, private_ip, region, image.",
+	},
+	"ExecHealthcheck": {
+		"Command": "The command to run to check the health of the container (e.g. [\"cat\", \"/tmp/healthy\"])",
+	},
+	"File": {
+		"GuestPath":   "GuestPath is the path on the machine where the file will be written and must be an absolute path. For example: /full/path/to/file.json",
+		"ImageConfig": "The name of an image to use the OCI image config as the file contents.",
+		"Mode":        "Mode bits used to set permissions on this file as accepted by chmod(2).",
+		"RawValue":    "The base64 encoded string of the file contents.",
+		"SecretName":  "The name of the secret that contains the base64 encoded file contents.",
+	},
+	"HTTPHealthcheck": {
+		"Headers":       "Additional headers to send with the request",
+		"Method":        "The HTTP method to use to when making the request",
+		"Path":          "The path to send the request to",
+		"Port":          "The port to connect to, often the same as internal_port",
+		"Scheme":        "Whether to use http or https",
+		"TLSServerName": "If the protocol is https, the hostname to use for TLS certificate validation",
+		"TLSSkipVerify": "If the protocol is https, whether or not to verify the TLS certificate",
+	},
+	"LaunchMachineInput": {
+		"ID": "Client side only",
+	},
+	"Machine": {
+		"IncompleteConfig": "When `host_status` isn't \"ok\", the config can't be fully retrieved and has to be rebuilt from multiple sources to form an partial configuration, not suitable to clone or recreate the original machine",
+		"InstanceID":       "InstanceID is unique for each version of the machine",
+		"PrivateIP":        "PrivateIP is the internal 6PN address of the machine.",
+	},
+	"MachineCheck": {
+		"GracePeriod":       "The time to wait after a VM starts before checking its health",
+		"HTTPMethod":        "For http checks, the HTTP method to use to when making the request",
+		"HTTPPath":          "For http checks, the path to send the request to",
+		"HTTPProtocol":      "For http checks, whether to use http or https",
+		"HTTPSkipTLSVerify": "For http checks with https protocol, whether or not to verify the TLS certificate",
+		"HTTPTLSServerName": "If the protocol is https, the hostname to use for TLS certificate validation",
+		"Interval":          "The time between connectivity checks",
+		"Kind":              "Kind of the check (informational, readiness)",
+		"Port":              "The port to connect to, often the same as internal_port",
+		"Timeout":           "The maximum time a connection can take before being reported as failing its health check",
+		"Type":              "tcp or http",
+	},
+	"MachineConfig": {
+		"AutoDestroy":             "Optional boolean telling the Machine to destroy itself once it’s complete (default false)",
+		"Checks":                  "An optional object that defines one or more named top-level checks. The key for each check is the check name.",
+		"Containers":              "Containers are a list of containers that will run in the machine. Currently restricted to only specific organizations.",
+		"DisableMachineAutostart": "Deprecated: use Service.Autostart instead",
+		"Env":                     "An object filled with key/value pairs to be set as environment variables",
+		"Image":                   "The docker image to run",
+		"Standbys":                "Standbys enable a machine to be a standby for another. In the event of a hardware failure, the standby machine will be started.",
+		"VMSize":                  "Deprecated: use Guest instead",
+		"Volumes":                 "Volumes describe the set of volumes that can be attached to the machine. Used in conjuction with containers",
+	},
+	"MachineExecRequest": {
+		"Machine": "Machine runs the command in the machine's own namespace instead of in a container. It is mutually exclusive with Container.",
+	},
+	"MachineGuest": {
+		"PersistRootfs": "Deprecated: use MachineConfig.Rootfs instead",
+	},
+	"MachineHTTPHeader": {
+		"Name":   "The header name",
+		"Values": "The header value",
+	},
+	"MachineProcess": {
+		"EnvFrom":          "EnvFrom can be provided to set environment variables from machine fields.",
+		"IgnoreAppSecrets": "IgnoreAppSecrets can be set to true to ignore the secrets for the App the Machine belongs to and only use the secrets provided at the process level. The default/legacy behavior is to use the secrets provided at the App level.",
+		"Secrets":          "Secrets can be provided at the process level to explicitly indicate which secrets should be used for the process. If not provided, the secrets provided at the machine level will be used.",
+	},
+	"MachineRestart": {
+		"MaxRetries": "When policy is on-failure, the maximum number of times to attempt to restart the Machine before letting it stop.",
+		"Policy":     "* no - Never try to restart a Machine automatically when its main process exits, whether that’s on purpose or on a crash.\n* always - Always restart a Machine automatically and never let it enter a stopped state, even when the main process exits cleanly.\n* on-failure - Try up to MaxRetries times to automatically restart the Machine if it exits with a non-zero exit code. Default when no explicit policy is set, and for Machines with schedules.",
+	},
+	"MachineSecret": {
+		"EnvVar": "EnvVar is required and is the name of the environment variable that will be set from the secret. It must be a valid environment variable name.",
+		"Name":   "Name is optional and when provided is used to reference a secret name where the EnvVar is different from what was set as the secret name.",
+	},
+	"MachineService": {
+		"Autostop": "Accepts a string (new format) or a boolean (old format). For backward compatibility with older clients, the API continues to use booleans for \"off\" and \"stop\" in responses.\n* \"off\" or false - Do not autostop the Machine.\n* \"stop\" or true - Automatically stop the Machine.\n* \"suspend\" - Automatically suspend the Machine, falling back to a full stop if this is not possible.",
+		"Checks":   "An optional list of service checks",
+	},
+	"MachineServiceCheck": {
+		"GracePeriod":       "The time to wait after a VM starts before checking its health",
+		"HTTPMethod":        "For http checks, the HTTP method to use to when making the request",
+		"HTTPPath":          "For http checks, the path to send the request to",
+		"HTTPProtocol":      "For http checks, whether to use http or https",
+		"HTTPSkipTLSVerify": "For http checks with https protocol, whether or not to verify the TLS certificate",
+		"HTTPTLSServerName": "If the protocol is https, the hostname to use for TLS certificate validation",
+		"Interval":          "The time between connectivity checks",
+		"Port":              "The port to connect to, often the same as internal_port",
+		"Timeout":           "The maximum time a connection can take before being reported as failing its health check",
+		"Type":              "tcp or http",
+	},
+	"MachineSpot": {
+		"MaxPriceFraction": "MaxPriceFraction is the maximum fraction of the full Machine price you will pay for this Machine. Range: (0, 1.0]",
+	},
+	"ReplayCache": {
+		"Name": "Name of the cookie or header to key the cache on",
+		"Type": "Currently either \"cookie\" or \"header\"",
+	},
+	"TCPHealthcheck": {
+		"Port": "The port to connect to, often the same as internal_port",
+	},
+	"TempDirVolume": {
+		"SizeMB":      "The size limit of the temp dir, only applicable when using disk backed storage.",
+		"StorageType": "The type of storage used to back the temp dir. Either disk or memory.",
+	},
+	"VolumeConfig": {
+		"Name": "The name of the volume. A volume must have a unique name within an app",
+	},
+}
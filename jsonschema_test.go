@@ -0,0 +1,76 @@
+package fly
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestMachineConfigJSONSchema(t *testing.T) {
+	var schema struct {
+		Schema string                    `json:"$schema"`
+		Ref    string                    `json:"$ref"`
+		Defs   map[string]map[string]any `json:"$defs"`
+	}
+	if err := json.Unmarshal(MachineConfigJSONSchema(), &schema); err != nil {
+		t.Fatalf("MachineConfigJSONSchema() isn't JSON: %v", err)
+	}
+	if schema.Schema != JSONSchemaDialect || schema.Ref != "#/$defs/MachineConfig" {
+		t.Errorf("$schema = %q, $ref = %q", schema.Schema, schema.Ref)
+	}
+
+	property := func(def, name string) map[string]any {
+		t.Helper()
+		properties, _ := schema.Defs[def]["properties"].(map[string]any)
+		p, ok := properties[name].(map[string]any)
+		if !ok {
+			t.Fatalf("%s has no property %s", def, name)
+		}
+		return p
+	}
+
+	cases := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"enums", property("MachineRestart", "policy")["enum"], []any{"no", "always", "on-failure"}},
+		{"swaggertype", property("MachineService", "autostop")["type"], "string"},
+		{"swaggertype enums", property("MachineService", "autostop")["enum"], []any{"off", "stop", "suspend"}},
+		{"example", property("MachineCheck", "interval")["examples"], []any{"15s"}},
+		{"field description", property("MachineCheck", "port")["description"], "The port to connect to, often the same as internal_port"},
+		{"@description", schema.Defs["File"]["description"], "A file that will be written to the Machine. One of RawValue or SecretName must be set."},
+		{"deprecated", property("MachineConfig", "size")["deprecated"], true},
+		{"required", schema.Defs["Static"]["required"], []any{"guest_path", "url_prefix"}},
+		{"ref", property("MachineConfig", "guest")["$ref"], "#/$defs/MachineGuest"},
+		{"map", property("MachineConfig", "env")["additionalProperties"], map[string]any{"type": "string"}},
+		{"array items", property("MachineConfig", "standbys")["items"], map[string]any{"type": "string"}},
+	}
+	for _, tc := range cases {
+		gotJSON, _ := json.Marshal(tc.got)
+		wantJSON, _ := json.Marshal(tc.want)
+		if !bytes.Equal(gotJSON, wantJSON) {
+			t.Errorf("%s: got %s, want %s", tc.name, gotJSON, wantJSON)
+		}
+	}
+
+	if _, ok := property("MachineConfig", "init")["description"]; ok {
+		t.Errorf("init has a description, but its field has no doc comment")
+	}
+	if !slices.Contains(slices.Collect(maps.Keys(schema.Defs)), "dnsOption") {
+		t.Errorf("$defs = %v, want the unexported types too", slices.Collect(maps.Keys(schema.Defs)))
+	}
+}
+
+func TestMachineConfigJSONSchemaIsGenerated(t *testing.T) {
+	file, err := os.ReadFile("schemas/machine_config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(file), MachineConfigJSONSchema()) {
+		t.Errorf("schemas/machine_config.schema.json is out of date; run go generate")
+	}
+}
@@ -0,0 +1,331 @@
+package fly
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ConfigChangeKind is what it takes to apply a change to a machine's config.
+// Kinds are ordered, so the kind of a set of changes is the largest of them.
+type ConfigChangeKind int
+
+const (
+	// ConfigNoChange means there's nothing to apply.
+	ConfigNoChange ConfigChangeKind = iota
+	// ConfigChangeInPlace changes only how the platform treats the machine,
+	// such as its services, checks or metadata, and not what runs on it.
+	ConfigChangeInPlace
+	// ConfigChangeRestart changes what runs on the machine, such as its
+	// image, environment or guest, so the machine is restarted to apply it.
+	ConfigChangeRestart
+	// ConfigChangeReplace can't be applied to an existing machine, such as a
+	// change of mounts, so the machine has to be replaced. See
+	// LaunchMachineInput.RequiresReplacement.
+	ConfigChangeReplace
+)
+
+func (k ConfigChangeKind) String() string {
+	switch k {
+	case ConfigNoChange:
+		return "none"
+	case ConfigChangeInPlace:
+		return "in-place"
+	case ConfigChangeRestart:
+		return "restart"
+	case ConfigChangeReplace:
+		return "replace"
+	}
+
+	return fmt.Sprintf("ConfigChangeKind(%d)", int(k))
+}
+
+// ConfigChange is a change to one field of a machine's config.
+type ConfigChange struct {
+	// Path names the field by its JSON names, such as "guest.memory_mb" or
+	// "env.PORT". List items are named by their key where they have one,
+	// such as "mounts[/data]" or "services[tcp/8080]", and by their index
+	// otherwise.
+	Path string
+	// Old and New are the JSON values of the field, nil if it was added or
+	// removed.
+	Old, New any
+	Kind     ConfigChangeKind
+}
+
+func (c ConfigChange) String() string {
+	return fmt.Sprintf("%s (%s): %s -> %s", c.Path, c.Kind, diffValueString(c.Old), diffValueString(c.New))
+}
+
+// MachineConfigDiff is the changes between two machine configs, in a stable
+// order.
+type MachineConfigDiff struct {
+	Changes []ConfigChange
+}
+
+// Kind is what it takes to apply every change.
+func (d *MachineConfigDiff) Kind() ConfigChangeKind {
+	kind := ConfigNoChange
+	for _, c := range d.Changes {
+		kind = max(kind, c.Kind)
+	}
+
+	return kind
+}
+
+// Empty reports whether the configs are the same.
+func (d *MachineConfigDiff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// RequiresReplacement reports whether the machine has to be replaced rather
+// than updated, which is what LaunchMachineInput.RequiresReplacement is for.
+func (d *MachineConfigDiff) RequiresReplacement() bool {
+	return d.Kind() == ConfigChangeReplace
+}
+
+func (d *MachineConfigDiff) String() string {
+	lines := make([]string, len(d.Changes))
+	for i, c := range d.Changes {
+		lines[i] = c.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// inPlaceFields are the top-level config fields that can change without the
+// machine being restarted. Fields not listed here are assumed to need a
+// restart, so that new fields err on the side of caution.
+var inPlaceFields = map[string]bool{
+	"metadata":                  true,
+	"services":                  true,
+	"checks":                    true,
+	"metrics":                   true,
+	"statics":                   true,
+	"schedule":                  true,
+	"auto_destroy":              true,
+	"restart":                   true,
+	"standbys":                  true,
+	"stop_config":               true,
+	"disable_machine_autostart": true,
+}
+
+// replaceFields are the top-level config fields that can't change without the
+// machine being replaced.
+var replaceFields = map[string]bool{
+	"mounts": true,
+}
+
+// mountInPlaceFields are the fields of a mount that configure its volume's
+// auto-extension, and don't need the machine replaced.
+var mountInPlaceFields = map[string]bool{
+	"extend_threshold_percent": true,
+	"add_size_gb":              true,
+	"size_gb_limit":            true,
+}
+
+// listKeys names the field that identifies the items of a list, by the list's
+// JSON name, so that items are compared with their counterpart whatever their
+// order.
+var listKeys = map[string]func(item map[string]any) string{
+	"mounts":     keyField("path"),
+	"files":      keyField("guest_path"),
+	"statics":    keyField("guest_path"),
+	"containers": keyField("name"),
+	"volumes":    keyField("name"),
+	"services": func(item map[string]any) string {
+		port, ok := item["internal_port"].(float64)
+		if !ok {
+			return ""
+		}
+		protocol, _ := item["protocol"].(string)
+		return fmt.Sprintf("%s/%d", protocol, int(port))
+	},
+}
+
+func keyField(name string) func(map[string]any) string {
+	return func(item map[string]any) string {
+		s, _ := item[name].(string)
+		return s
+	}
+}
+
+// DiffMachineConfig returns the field-level changes from old to new, either
+// of which may be nil. Fields are compared by their JSON encoding, so an empty
+// field and a missing one are the same, as they are to the API.
+func DiffMachineConfig(old, new *MachineConfig) *MachineConfigDiff {
+	d := &MachineConfigDiff{}
+	d.walk("", "", configJSON(old), configJSON(new))
+
+	return d
+}
+
+func configJSON(c *MachineConfig) any {
+	if c == nil {
+		c = &MachineConfig{}
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		panic(fmt.Sprintf("fly: can't encode machine config: %v", err))
+	}
+	var out any
+	if err := json.Unmarshal(b, &out); err != nil {
+		panic(fmt.Sprintf("fly: can't decode machine config: %v", err))
+	}
+
+	return out
+}
+
+func (d *MachineConfigDiff) walk(field, path string, a, b any) {
+	// Compare an object or list that was added or removed item by item, so
+	// that each change has its own path. A list item is reported whole.
+	if !strings.HasSuffix(path, "]") {
+		a, b = emptyLike(a, b), emptyLike(b, a)
+	}
+
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			d.walkMap(field, path, a, b)
+			return
+		}
+	case []any:
+		if b, ok := b.([]any); ok {
+			d.walkList(field, path, a, b)
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		d.Changes = append(d.Changes, ConfigChange{Path: path, Old: a, New: b, Kind: changeKind(field, path)})
+	}
+}
+
+// emptyLike returns v, or an empty value of the same type as other if v is
+// nil.
+func emptyLike(v, other any) any {
+	if v != nil {
+		return v
+	}
+
+	switch other.(type) {
+	case map[string]any:
+		return map[string]any{}
+	case []any:
+		return []any{}
+	}
+
+	return nil
+}
+
+func (d *MachineConfigDiff) walkMap(field, path string, a, b map[string]any) {
+	keys := slices.Collect(maps.Keys(a))
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		f := field
+		if f == "" {
+			f = k
+		}
+		p := k
+		if path != "" {
+			p = path + "." + k
+		}
+		d.walk(f, p, a[k], b[k])
+	}
+}
+
+func (d *MachineConfigDiff) walkList(field, path string, a, b []any) {
+	name := path[strings.LastIndex(path, ".")+1:]
+	if key, ok := listKeys[name]; ok {
+		if ak, ok := keyItems(a, key); ok {
+			if bk, ok := keyItems(b, key); ok {
+				d.walkKeyed(field, path, a, b, ak, bk)
+				return
+			}
+		}
+	}
+
+	for i := range max(len(a), len(b)) {
+		var x, y any
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		d.walk(field, fmt.Sprintf("%s[%d]", path, i), x, y)
+	}
+}
+
+// walkKeyed compares list items by key: items in the order of the old list
+// first, then the ones that were added.
+func (d *MachineConfigDiff) walkKeyed(field, path string, a, b []any, ak, bk []string) {
+	for i, k := range ak {
+		var y any
+		if j := slices.Index(bk, k); j >= 0 {
+			y = b[j]
+		}
+		d.walk(field, fmt.Sprintf("%s[%s]", path, k), a[i], y)
+	}
+	for j, k := range bk {
+		if !slices.Contains(ak, k) {
+			d.walk(field, fmt.Sprintf("%s[%s]", path, k), nil, b[j])
+		}
+	}
+}
+
+// keyItems returns the keys of a list's items, unless one lacks a key or two
+// share one.
+func keyItems(items []any, key func(map[string]any) string) ([]string, bool) {
+	keys := make([]string, len(items))
+	for i, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if keys[i] = key(m); keys[i] == "" || slices.Contains(keys[:i], keys[i]) {
+			return nil, false
+		}
+	}
+
+	return keys, true
+}
+
+func changeKind(field, path string) ConfigChangeKind {
+	leaf := path[strings.LastIndex(path, ".")+1:]
+
+	switch {
+	case field == "mounts" && mountInPlaceFields[leaf]:
+		return ConfigChangeInPlace
+	case replaceFields[field]:
+		return ConfigChangeReplace
+	case inPlaceFields[field]:
+		return ConfigChangeInPlace
+	}
+
+	return ConfigChangeRestart
+}
+
+func diffValueString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "<none>"
+	case string:
+		return strconv.Quote(v)
+	}
+
+	b, _ := json.Marshal(v)
+
+	return string(b)
+}
@@ -0,0 +1,160 @@
+package fly
+
+import (
+	"testing"
+)
+
+func TestDiffMachineConfig(t *testing.T) {
+	base := func() *MachineConfig {
+		return &MachineConfig{
+			Image: "registry.fly.io/my-app:v1",
+			Env:   map[string]string{"PORT": "8080", "LOG_LEVEL": "info"},
+			Guest: &MachineGuest{CPUKind: "shared", CPUs: 1, MemoryMB: 256},
+			Mounts: []MachineMount{
+				{Path: "/data", Volume: "vol_1", Name: "data"},
+			},
+			Services: []MachineService{
+				{Protocol: "tcp", InternalPort: 8080, Ports: []MachinePort{{Port: Pointer(443)}}},
+				{Protocol: "tcp", InternalPort: 9090},
+			},
+			Files: []*File{
+				{GuestPath: "/etc/a.conf", RawValue: Pointer("YQ==")},
+				{GuestPath: "/etc/b.conf", RawValue: Pointer("Yg==")},
+			},
+			Metadata: map[string]string{"fly_process_group": "app"},
+		}
+	}
+
+	cases := []struct {
+		name   string
+		change func(*MachineConfig)
+		paths  []string
+		kind   ConfigChangeKind
+	}{
+		{
+			name:   "no change",
+			change: func(*MachineConfig) {},
+			kind:   ConfigNoChange,
+		},
+		{
+			name: "reordered lists and empty fields",
+			change: func(c *MachineConfig) {
+				c.Services[0], c.Services[1] = c.Services[1], c.Services[0]
+				c.Files[0], c.Files[1] = c.Files[1], c.Files[0]
+				c.Checks = map[string]MachineCheck{}
+				c.Processes = []MachineProcess{}
+			},
+			kind: ConfigNoChange,
+		},
+		{
+			name: "metadata",
+			change: func(c *MachineConfig) {
+				c.Metadata["fly_release_version"] = "2"
+			},
+			paths: []string{"metadata.fly_release_version"},
+			kind:  ConfigChangeInPlace,
+		},
+		{
+			name: "service port",
+			change: func(c *MachineConfig) {
+				c.Services[0].Ports[0].Port = Pointer(8443)
+			},
+			paths: []string{"services[tcp/8080].ports[0].port"},
+			kind:  ConfigChangeInPlace,
+		},
+		{
+			name: "env and image",
+			change: func(c *MachineConfig) {
+				c.Env["LOG_LEVEL"] = "debug"
+				delete(c.Env, "PORT")
+				c.Image = "registry.fly.io/my-app:v2"
+			},
+			paths: []string{"env.LOG_LEVEL", "env.PORT", "image"},
+			kind:  ConfigChangeRestart,
+		},
+		{
+			name: "guest",
+			change: func(c *MachineConfig) {
+				c.Guest.MemoryMB = 512
+			},
+			paths: []string{"guest.memory_mb"},
+			kind:  ConfigChangeRestart,
+		},
+		{
+			name: "file added",
+			change: func(c *MachineConfig) {
+				c.Files = append(c.Files, &File{GuestPath: "/etc/c.conf", RawValue: Pointer("Yw==")})
+			},
+			paths: []string{"files[/etc/c.conf]"},
+			kind:  ConfigChangeRestart,
+		},
+		{
+			name: "volume swapped",
+			change: func(c *MachineConfig) {
+				c.Mounts[0].Volume = "vol_2"
+			},
+			paths: []string{"mounts[/data].volume"},
+			kind:  ConfigChangeReplace,
+		},
+		{
+			name: "mount auto-extension",
+			change: func(c *MachineConfig) {
+				c.Mounts[0].ExtendThresholdPercent = 80
+				c.Mounts[0].AddSizeGb = 10
+			},
+			paths: []string{"mounts[/data].add_size_gb", "mounts[/data].extend_threshold_percent"},
+			kind:  ConfigChangeInPlace,
+		},
+		{
+			name: "check added alongside a mount removed",
+			change: func(c *MachineConfig) {
+				c.Checks = map[string]MachineCheck{"alive": {Type: Pointer("tcp"), Port: Pointer(8080)}}
+				c.Mounts = nil
+			},
+			paths: []string{"checks.alive.port", "checks.alive.type", "mounts[/data]"},
+			kind:  ConfigChangeReplace,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			old, new := base(), base()
+			tc.change(new)
+
+			d := DiffMachineConfig(old, new)
+			var paths []string
+			for _, c := range d.Changes {
+				paths = append(paths, c.Path)
+			}
+			if len(paths) != len(tc.paths) {
+				t.Fatalf("DiffMachineConfig() changes = %v, want %v", paths, tc.paths)
+			}
+			for i := range paths {
+				if paths[i] != tc.paths[i] {
+					t.Fatalf("DiffMachineConfig() changes = %v, want %v", paths, tc.paths)
+				}
+			}
+			if got := d.Kind(); got != tc.kind {
+				t.Errorf("Kind() = %s, want %s\n%s", got, tc.kind, d)
+			}
+			if got, want := d.RequiresReplacement(), tc.kind == ConfigChangeReplace; got != want {
+				t.Errorf("RequiresReplacement() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDiffMachineConfigValues(t *testing.T) {
+	d := DiffMachineConfig(nil, &MachineConfig{Env: map[string]string{"PORT": "8080"}})
+
+	if len(d.Changes) != 1 {
+		t.Fatalf("DiffMachineConfig() = %v, want one change", d)
+	}
+	c := d.Changes[0]
+	if c.Path != "env.PORT" || c.Old != nil || c.New != "8080" {
+		t.Errorf("change = %+v, want env.PORT added as 8080", c)
+	}
+	if want := `env.PORT (restart): <none> -> "8080"`; c.String() != want {
+		t.Errorf("String() = %q, want %q", c.String(), want)
+	}
+}
@@ -0,0 +1,365 @@
+package fly
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ConfigError is a problem with one field of a machine config.
+type ConfigError struct {
+	// Path names the field by its JSON names, such as
+	// "services[0].ports[1].port".
+	Path    string
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ConfigErrors is every problem found with a machine config.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return "invalid machine config: " + strings.Join(msgs, "; ")
+}
+
+func (e ConfigErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+
+	return errs
+}
+
+var (
+	stopSignals          = []string{"SIGHUP", "SIGINT", "SIGQUIT", "SIGKILL", "SIGUSR1", "SIGUSR2", "SIGTERM"}
+	envFieldRefs         = []string{"id", "version", "app_name", "private_ip", "region", "image"}
+	dependencyConditions = []ContainerDependencyCondition{ExitedSuccessfully, Healthy, Started}
+)
+
+// Validate checks the config for the problems the API would reject it for at
+// launch, and returns them all as ConfigErrors, or nil if it found none.
+func (c *MachineConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	v := &configValidator{}
+	v.guest("guest", c.Guest)
+	v.services(c.Services)
+	v.files("files", c.Files)
+	v.stop("stop_config", c.StopConfig)
+	v.schedule(c.Schedule)
+	v.containers(c.Containers, c.Volumes)
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	return v.errs
+}
+
+type configValidator struct {
+	errs ConfigErrors
+}
+
+func (v *configValidator) errorf(path, format string, args ...any) {
+	v.errs = append(v.errs, &ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *configValidator) guest(p string, g *MachineGuest) {
+	if g == nil {
+		return
+	}
+
+	var minPerCPU, maxPerCPU int
+	switch g.CPUKind {
+	case "", "shared":
+		minPerCPU, maxPerCPU = MIN_MEMORY_MB_PER_SHARED_CPU, MAX_MEMORY_MB_PER_SHARED_CPU
+	case "performance":
+		minPerCPU, maxPerCPU = MIN_MEMORY_MB_PER_CPU, MAX_MEMORY_MB_PER_CPU
+	default:
+		v.errorf(p+".cpu_kind", "must be shared or performance, not %q", g.CPUKind)
+		return
+	}
+
+	if g.CPUs < 0 {
+		v.errorf(p+".cpus", "must not be negative")
+		return
+	}
+	if g.MemoryMB == 0 {
+		return
+	}
+
+	cpus := max(g.CPUs, 1)
+	switch {
+	case g.MemoryMB < cpus*minPerCPU:
+		v.errorf(p+".memory_mb", "%dMB is below the minimum of %dMB for %d %s CPUs", g.MemoryMB, cpus*minPerCPU, cpus, cmp.Or(g.CPUKind, "shared"))
+	case g.MemoryMB > cpus*maxPerCPU:
+		v.errorf(p+".memory_mb", "%dMB is above the maximum of %dMB for %d %s CPUs", g.MemoryMB, cpus*maxPerCPU, cpus, cmp.Or(g.CPUKind, "shared"))
+	case g.MemoryMB%256 != 0:
+		v.errorf(p+".memory_mb", "%dMB is not a multiple of 256MB", g.MemoryMB)
+	}
+}
+
+func (v *configValidator) services(services []MachineService) {
+	for i, s := range services {
+		p := fmt.Sprintf("services[%d]", i)
+		if s.InternalPort != 0 {
+			v.port(p+".internal_port", &s.InternalPort)
+		}
+
+		for j, port := range s.Ports {
+			pp := fmt.Sprintf("%s.ports[%d]", p, j)
+			v.port(pp+".port", port.Port)
+			v.port(pp+".start_port", port.StartPort)
+			v.port(pp+".end_port", port.EndPort)
+
+			switch {
+			case port.Port != nil && (port.StartPort != nil || port.EndPort != nil):
+				v.errorf(pp, "must have either a port or a range, not both")
+			case (port.StartPort == nil) != (port.EndPort == nil):
+				v.errorf(pp, "must have both a start_port and an end_port")
+			case port.StartPort != nil && *port.StartPort > *port.EndPort:
+				v.errorf(pp, "start_port %d is after end_port %d", *port.StartPort, *port.EndPort)
+			}
+		}
+	}
+}
+
+func (v *configValidator) port(p string, port *int) {
+	if port != nil && (*port < 1 || *port > 65535) {
+		v.errorf(p, "%d is not between 1 and 65535", *port)
+	}
+}
+
+func (v *configValidator) files(p string, files []*File) {
+	for i, f := range files {
+		fp := fmt.Sprintf("%s[%d]", p, i)
+		if f == nil {
+			v.errorf(fp, "must not be null")
+			continue
+		}
+
+		if !path.IsAbs(f.GuestPath) {
+			v.errorf(fp+".guest_path", "%q is not an absolute path", f.GuestPath)
+		}
+
+		sources := 0
+		for _, s := range []*string{f.RawValue, f.SecretName, f.ImageConfig} {
+			if s != nil {
+				sources++
+			}
+		}
+		if sources != 1 {
+			v.errorf(fp, "must have exactly one of raw_value, secret_name and image_config")
+		}
+	}
+}
+
+func (v *configValidator) stop(p string, stop *StopConfig) {
+	if stop == nil || stop.Signal == nil {
+		return
+	}
+	if !slices.Contains(stopSignals, *stop.Signal) {
+		v.errorf(p+".signal", "must be one of %s, not %q", strings.Join(stopSignals, ", "), *stop.Signal)
+	}
+}
+
+func (v *configValidator) containers(containers []*ContainerConfig, volumes []*VolumeConfig) {
+	var volumeNames []string
+	for _, vol := range volumes {
+		if vol != nil {
+			volumeNames = append(volumeNames, vol.Name)
+		}
+	}
+
+	index := make(map[string]int)
+	for i, c := range containers {
+		if c == nil {
+			continue
+		}
+		p := fmt.Sprintf("containers[%d]", i)
+		if c.Name == "" {
+			v.errorf(p+".name", "must not be empty")
+		} else if _, ok := index[c.Name]; ok {
+			v.errorf(p+".name", "%q is used by more than one container", c.Name)
+		} else {
+			index[c.Name] = i
+		}
+	}
+
+	for i, c := range containers {
+		if c == nil {
+			continue
+		}
+		p := fmt.Sprintf("containers[%d]", i)
+
+		for j, dep := range c.DependsOn {
+			dp := fmt.Sprintf("%s.depends_on[%d]", p, j)
+			if _, ok := index[dep.Name]; !ok {
+				v.errorf(dp+".name", "no container is named %q", dep.Name)
+			}
+			if !slices.Contains(dependencyConditions, dep.Condition) {
+				v.errorf(dp+".condition", "must be one of exited_successfully, healthy, started, not %q", dep.Condition)
+			}
+		}
+
+		for j, m := range c.Mounts {
+			if !slices.Contains(volumeNames, m.Name) {
+				v.errorf(fmt.Sprintf("%s.mounts[%d].name", p, j), "no volume is named %q", m.Name)
+			}
+		}
+
+		for j, env := range c.EnvFrom {
+			if !slices.Contains(envFieldRefs, env.FieldRef) {
+				v.errorf(fmt.Sprintf("%s.env_from[%d].field_ref", p, j), "must be one of %s, not %q", strings.Join(envFieldRefs, ", "), env.FieldRef)
+			}
+		}
+
+		v.files(p+".files", c.Files)
+		v.stop(p+".stop", c.Stop)
+	}
+
+	v.dependencyCycles(containers, index)
+}
+
+// dependencyCycles reports each cycle in the containers' dependencies once,
+// at the first container in it.
+func (v *configValidator) dependencyCycles(containers []*ContainerConfig, index map[string]int) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(containers))
+	var stack []int
+
+	var visit func(i int)
+	visit = func(i int) {
+		state[i] = visiting
+		stack = append(stack, i)
+
+		for _, dep := range containers[i].DependsOn {
+			j, ok := index[dep.Name]
+			if !ok {
+				continue
+			}
+			switch state[j] {
+			case unvisited:
+				visit(j)
+			case visiting:
+				cycle := stack[slices.Index(stack, j):]
+				names := make([]string, 0, len(cycle)+1)
+				for _, k := range cycle {
+					names = append(names, containers[k].Name)
+				}
+				names = append(names, containers[j].Name)
+				v.errorf(fmt.Sprintf("containers[%d].depends_on", j), "dependency cycle: %s", strings.Join(names, " -> "))
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[i] = done
+	}
+
+	for i, c := range containers {
+		if c != nil && state[i] == unvisited && index[c.Name] == i {
+			visit(i)
+		}
+	}
+}
+
+// scheduleIntervals are the named schedules the API accepts besides cron
+// expressions.
+var scheduleIntervals = []string{"hourly", "daily", "weekly", "monthly"}
+
+func (v *configValidator) schedule(s string) {
+	if s == "" || slices.Contains(scheduleIntervals, s) {
+		return
+	}
+	if err := parseCron(s); err != nil {
+		v.errorf("schedule", "%q is neither one of %s nor a cron expression: %v", s, strings.Join(scheduleIntervals, ", "), err)
+	}
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+	names    []string
+}{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// parseCron checks a standard five-field cron expression, or one of the
+// @yearly, @monthly, @weekly, @daily and @hourly shorthands.
+func parseCron(s string) error {
+	switch s {
+	case "@yearly", "@annually", "@monthly", "@weekly", "@daily", "@midnight", "@hourly":
+		return nil
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) != len(cronFields) {
+		return fmt.Errorf("want %d fields, got %d", len(cronFields), len(fields))
+	}
+
+	for i, field := range fields {
+		f := cronFields[i]
+		value := func(s string) (int, error) {
+			if n := slices.Index(f.names, strings.ToLower(s)); n >= 0 {
+				return n + f.min, nil
+			}
+			n, err := strconv.Atoi(s)
+			if err != nil || n < f.min || n > f.max {
+				return 0, fmt.Errorf("invalid %s %q", f.name, s)
+			}
+			return n, nil
+		}
+
+		for _, part := range strings.Split(field, ",") {
+			rng, step, hasStep := strings.Cut(part, "/")
+			if hasStep {
+				if n, err := strconv.Atoi(step); err != nil || n < 1 {
+					return fmt.Errorf("invalid %s step %q", f.name, step)
+				}
+			}
+			if rng == "*" {
+				continue
+			}
+
+			lo, hi, isRange := strings.Cut(rng, "-")
+			start, err := value(lo)
+			if err != nil {
+				return err
+			}
+			if !isRange {
+				continue
+			}
+			end, err := value(hi)
+			if err != nil {
+				return err
+			}
+			if start > end {
+				return fmt.Errorf("invalid %s range %q", f.name, rng)
+			}
+		}
+	}
+
+	return nil
+}
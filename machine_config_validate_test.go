@@ -0,0 +1,159 @@
+package fly
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMachineConfigValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		config MachineConfig
+		paths  []string
+	}{
+		{
+			name: "valid",
+			config: MachineConfig{
+				Guest:    &MachineGuest{CPUKind: "performance", CPUs: 2, MemoryMB: 4096},
+				Services: []MachineService{{InternalPort: 8080, Ports: []MachinePort{{Port: Pointer(443)}, {StartPort: Pointer(1000), EndPort: Pointer(2000)}}}},
+				Files:    []*File{{GuestPath: "/etc/app.conf", SecretName: Pointer("APP_CONF")}},
+				Schedule: "*/15 9-17 * jan-jun mon-fri",
+				Containers: []*ContainerConfig{
+					{Name: "app", DependsOn: []ContainerDependency{{Name: "db", Condition: Healthy}}, Mounts: []ContainerMount{{Name: "data", Path: "/data"}}},
+					{Name: "db", EnvFrom: []EnvFrom{{EnvVar: "REGION", FieldRef: "region"}}, Stop: &StopConfig{Signal: Pointer("SIGINT")}},
+				},
+				Volumes: []*VolumeConfig{{Name: "data"}},
+			},
+		},
+		{
+			name:   "memory below the minimum",
+			config: MachineConfig{Guest: &MachineGuest{CPUKind: "performance", CPUs: 2, MemoryMB: 2048}},
+			paths:  []string{"guest.memory_mb"},
+		},
+		{
+			name:   "memory above the maximum",
+			config: MachineConfig{Guest: &MachineGuest{CPUKind: "shared", CPUs: 1, MemoryMB: 4096}},
+			paths:  []string{"guest.memory_mb"},
+		},
+		{
+			name:   "memory not a multiple of 256",
+			config: MachineConfig{Guest: &MachineGuest{CPUs: 1, MemoryMB: 300}},
+			paths:  []string{"guest.memory_mb"},
+		},
+		{
+			name:   "unknown cpu kind",
+			config: MachineConfig{Guest: &MachineGuest{CPUKind: "quantum", CPUs: 1}},
+			paths:  []string{"guest.cpu_kind"},
+		},
+		{
+			name: "ports",
+			config: MachineConfig{Services: []MachineService{{
+				InternalPort: 70000,
+				Ports: []MachinePort{
+					{Port: Pointer(0)},
+					{Port: Pointer(80), StartPort: Pointer(80), EndPort: Pointer(90)},
+					{StartPort: Pointer(2000)},
+					{StartPort: Pointer(2000), EndPort: Pointer(1000)},
+				},
+			}}},
+			paths: []string{
+				"services[0].internal_port",
+				"services[0].ports[0].port",
+				"services[0].ports[1]",
+				"services[0].ports[2]",
+				"services[0].ports[3]",
+			},
+		},
+		{
+			name: "files",
+			config: MachineConfig{Files: []*File{
+				{GuestPath: "relative.conf", RawValue: Pointer("YQ==")},
+				{GuestPath: "/etc/none.conf"},
+				{GuestPath: "/etc/both.conf", RawValue: Pointer("YQ=="), ImageConfig: Pointer("nginx")},
+			}},
+			paths: []string{"files[0].guest_path", "files[1]", "files[2]"},
+		},
+		{
+			name:   "stop signal",
+			config: MachineConfig{StopConfig: &StopConfig{Signal: Pointer("SIGSTOP")}},
+			paths:  []string{"stop_config.signal"},
+		},
+		{
+			name:   "schedule",
+			config: MachineConfig{Schedule: "61 * * * *"},
+			paths:  []string{"schedule"},
+		},
+		{
+			name: "containers",
+			config: MachineConfig{
+				Containers: []*ContainerConfig{
+					{
+						Name:      "app",
+						DependsOn: []ContainerDependency{{Name: "missing", Condition: "ready"}},
+						Mounts:    []ContainerMount{{Name: "data", Path: "/data"}},
+						EnvFrom:   []EnvFrom{{EnvVar: "HOST", FieldRef: "hostname"}},
+						Files:     []*File{{GuestPath: "/etc/none.conf"}},
+						Stop:      &StopConfig{Signal: Pointer("TERM")},
+					},
+					{Name: "app"},
+				},
+			},
+			paths: []string{
+				"containers[1].name",
+				"containers[0].depends_on[0].name",
+				"containers[0].depends_on[0].condition",
+				"containers[0].mounts[0].name",
+				"containers[0].env_from[0].field_ref",
+				"containers[0].files[0]",
+				"containers[0].stop.signal",
+			},
+		},
+		{
+			name: "dependency cycle",
+			config: MachineConfig{Containers: []*ContainerConfig{
+				{Name: "a", DependsOn: []ContainerDependency{{Name: "b", Condition: Started}}},
+				{Name: "b", DependsOn: []ContainerDependency{{Name: "c", Condition: Started}}},
+				{Name: "c", DependsOn: []ContainerDependency{{Name: "a", Condition: Started}}},
+				{Name: "d", DependsOn: []ContainerDependency{{Name: "d", Condition: Started}}},
+			}},
+			paths: []string{"containers[0].depends_on", "containers[3].depends_on"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if len(tc.paths) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+
+			var errs ConfigErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("Validate() error = %v, want ConfigErrors", err)
+			}
+			var paths []string
+			for _, e := range errs {
+				paths = append(paths, e.Path)
+			}
+			if !slices.Equal(paths, tc.paths) {
+				t.Fatalf("Validate() errors at %v, want %v\n%v", paths, tc.paths, err)
+			}
+		})
+	}
+}
+
+func TestConfigErrorsUnwrap(t *testing.T) {
+	err := (&MachineConfig{Schedule: "every tuesday"}).Validate()
+
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("errors.As(%v) found no *ConfigError", err)
+	}
+	if cerr.Path != "schedule" {
+		t.Errorf("ConfigError.Path = %q, want schedule", cerr.Path)
+	}
+}
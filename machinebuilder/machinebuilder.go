@@ -0,0 +1,500 @@
+// Package machinebuilder builds machine configs without the pointer fields
+// and nested structs of fly.MachineConfig getting in the way:
+//
+//	config, err := machinebuilder.New().
+//		Image("registry.fly.io/my-app:v1").
+//		Guest("performance-2x").
+//		Env("PORT", "8080").
+//		HTTPService(8080).
+//		WithCheck(machinebuilder.Check{Type: "http", Path: "/healthz", Interval: 15 * time.Second}).
+//		Mount("vol_123", "/data").
+//		Build()
+//
+// Mistakes are collected as the config is built and returned together by
+// Build, along with anything fly.MachineConfig.Validate finds, rather than
+// panicking.
+package machinebuilder
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	pathpkg "path"
+	"slices"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+)
+
+// Builder builds a machine config. The zero value is not usable; call New.
+//
+// A Builder is a template: each call to Build returns a new config, so one
+// builder can be used for several machines, and changed between them.
+type Builder struct {
+	steps    []func(*fly.MachineConfig) error
+	services int
+}
+
+// New returns a builder for an empty config.
+func New() *Builder {
+	return &Builder{}
+}
+
+func (b *Builder) step(name string, f func(*fly.MachineConfig) error) *Builder {
+	b.steps = append(b.steps, func(c *fly.MachineConfig) error {
+		if err := f(c); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	})
+
+	return b
+}
+
+// Build returns the config, or every problem found building it.
+func (b *Builder) Build() (*fly.MachineConfig, error) {
+	config := &fly.MachineConfig{}
+
+	var errs []error
+	for _, step := range b.steps {
+		if err := step(config); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := config.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return config, nil
+}
+
+// Image sets the image to run.
+func (b *Builder) Image(image string) *Builder {
+	return b.step("Image", func(c *fly.MachineConfig) error {
+		if image == "" {
+			return errors.New("image must not be empty")
+		}
+		c.Image = image
+		return nil
+	})
+}
+
+// Guest sets the CPUs and memory to one of the fly.MachinePresets, such as
+// "shared-cpu-1x" or "performance-2x".
+func (b *Builder) Guest(size string) *Builder {
+	return b.step("Guest", func(c *fly.MachineConfig) error {
+		if c.Guest == nil {
+			c.Guest = &fly.MachineGuest{}
+		}
+		return c.Guest.SetSize(size)
+	})
+}
+
+// Memory overrides the memory of the guest set by Guest.
+func (b *Builder) Memory(mb int) *Builder {
+	return b.step("Memory", func(c *fly.MachineConfig) error {
+		if c.Guest == nil {
+			return errors.New("no guest size has been set")
+		}
+		c.Guest.MemoryMB = mb
+		return nil
+	})
+}
+
+// Env sets an environment variable.
+func (b *Builder) Env(name, value string) *Builder {
+	return b.step("Env", func(c *fly.MachineConfig) error {
+		if name == "" {
+			return errors.New("name must not be empty")
+		}
+		if c.Env == nil {
+			c.Env = make(map[string]string)
+		}
+		c.Env[name] = value
+		return nil
+	})
+}
+
+// Metadata sets a metadata key.
+func (b *Builder) Metadata(key, value string) *Builder {
+	return b.step("Metadata", func(c *fly.MachineConfig) error {
+		if key == "" {
+			return errors.New("key must not be empty")
+		}
+		if c.Metadata == nil {
+			c.Metadata = make(map[string]string)
+		}
+		c.Metadata[key] = value
+		return nil
+	})
+}
+
+// ProcessGroup sets the process group the machine belongs to.
+func (b *Builder) ProcessGroup(name string) *Builder {
+	return b.Metadata(fly.MachineConfigMetadataKeyFlyProcessGroup, name)
+}
+
+// Cmd overrides the image's command.
+func (b *Builder) Cmd(args ...string) *Builder {
+	return b.step("Cmd", func(c *fly.MachineConfig) error {
+		c.Init.Cmd = slices.Clone(args)
+		return nil
+	})
+}
+
+// Entrypoint overrides the image's entrypoint.
+func (b *Builder) Entrypoint(args ...string) *Builder {
+	return b.step("Entrypoint", func(c *fly.MachineConfig) error {
+		c.Init.Entrypoint = slices.Clone(args)
+		return nil
+	})
+}
+
+// Mount attaches a volume at path.
+func (b *Builder) Mount(volumeID, path string) *Builder {
+	return b.step("Mount", func(c *fly.MachineConfig) error {
+		if !pathpkg.IsAbs(path) {
+			return fmt.Errorf("%q is not an absolute path", path)
+		}
+		for _, m := range c.Mounts {
+			if m.Path == path {
+				return fmt.Errorf("a volume is already mounted at %s", path)
+			}
+		}
+		if volumeID == "" {
+			return errors.New("volume must not be empty")
+		}
+		c.Mounts = append(c.Mounts, fly.MachineMount{Volume: volumeID, Path: path})
+		return nil
+	})
+}
+
+// File writes content to path on the machine.
+func (b *Builder) File(path string, content []byte) *Builder {
+	return b.step("File", func(c *fly.MachineConfig) error {
+		c.Files = append(c.Files, &fly.File{
+			GuestPath: path,
+			RawValue:  fly.Pointer(base64.StdEncoding.EncodeToString(content)),
+		})
+		return nil
+	})
+}
+
+// SecretFile writes the app secret named secret to path on the machine.
+func (b *Builder) SecretFile(path, secret string) *Builder {
+	return b.step("SecretFile", func(c *fly.MachineConfig) error {
+		c.Files = append(c.Files, &fly.File{GuestPath: path, SecretName: fly.Pointer(secret)})
+		return nil
+	})
+}
+
+// Restart sets the restart policy. maxRetries only applies to
+// fly.MachineRestartPolicyOnFailure.
+func (b *Builder) Restart(policy fly.MachineRestartPolicy, maxRetries int) *Builder {
+	return b.step("Restart", func(c *fly.MachineConfig) error {
+		switch policy {
+		case fly.MachineRestartPolicyNo, fly.MachineRestartPolicyAlways, fly.MachineRestartPolicyOnFailure:
+		default:
+			return fmt.Errorf("unknown restart policy %q", policy)
+		}
+		c.Restart = &fly.MachineRestart{Policy: policy, MaxRetries: maxRetries}
+		return nil
+	})
+}
+
+// Schedule runs the machine on a schedule, such as "daily".
+func (b *Builder) Schedule(schedule string) *Builder {
+	return b.step("Schedule", func(c *fly.MachineConfig) error {
+		c.Schedule = schedule
+		return nil
+	})
+}
+
+// AutoDestroy destroys the machine once its main process exits.
+func (b *Builder) AutoDestroy() *Builder {
+	return b.step("AutoDestroy", func(c *fly.MachineConfig) error {
+		c.AutoDestroy = true
+		return nil
+	})
+}
+
+// Stop sets the signal the machine is stopped with, and how long it has to
+// exit before it's killed. A zero timeout keeps the platform's default.
+func (b *Builder) Stop(signal string, timeout time.Duration) *Builder {
+	return b.step("Stop", func(c *fly.MachineConfig) error {
+		c.StopConfig = &fly.StopConfig{Signal: fly.Pointer(signal)}
+		if timeout > 0 {
+			c.StopConfig.Timeout = &fly.Duration{Duration: timeout}
+		}
+		return nil
+	})
+}
+
+// Metrics has the platform scrape Prometheus metrics from port and path.
+func (b *Builder) Metrics(port int, path string) *Builder {
+	return b.step("Metrics", func(c *fly.MachineConfig) error {
+		c.Metrics = &fly.MachineMetrics{Port: port, Path: path}
+		return nil
+	})
+}
+
+// Check adds a top-level check. Its Port must be set.
+func (b *Builder) Check(name string, check Check) *Builder {
+	return b.step("Check", func(c *fly.MachineConfig) error {
+		if name == "" {
+			return errors.New("name must not be empty")
+		}
+		if check.Port == 0 {
+			return fmt.Errorf("check %s has no port", name)
+		}
+		mc, err := check.machineCheck()
+		if err != nil {
+			return fmt.Errorf("check %s: %w", name, err)
+		}
+		if c.Checks == nil {
+			c.Checks = make(map[string]fly.MachineCheck)
+		}
+		c.Checks[name] = mc
+		return nil
+	})
+}
+
+// HTTPService adds a service that serves HTTP on ports 80 and 443, with TLS
+// on 443 and HTTP redirected to HTTPS, from internalPort on the machine.
+func (b *Builder) HTTPService(internalPort int) *ServiceBuilder {
+	return b.Service(fly.MachineService{
+		Protocol:     "tcp",
+		InternalPort: internalPort,
+		Ports: []fly.MachinePort{
+			{Port: fly.Pointer(80), Handlers: []string{"http"}, ForceHTTPS: true},
+			{Port: fly.Pointer(443), Handlers: []string{"tls", "http"}},
+		},
+	})
+}
+
+// TCPService adds a service that passes TCP connections on port through to
+// internalPort on the machine, through the given handlers, if any.
+func (b *Builder) TCPService(internalPort, port int, handlers ...string) *ServiceBuilder {
+	return b.Service(fly.MachineService{
+		Protocol:     "tcp",
+		InternalPort: internalPort,
+		Ports:        []fly.MachinePort{{Port: fly.Pointer(port), Handlers: handlers}},
+	})
+}
+
+// Service adds a service as is, to be configured further with the
+// ServiceBuilder's methods.
+func (b *Builder) Service(service fly.MachineService) *ServiceBuilder {
+	s := &ServiceBuilder{Builder: b, index: b.services}
+	b.services++
+	b.step("Service", func(c *fly.MachineConfig) error {
+		// Copy the service, pointers and all, so that configs built from
+		// the same builder don't share it.
+		data, err := json.Marshal(service)
+		if err != nil {
+			return err
+		}
+		var svc fly.MachineService
+		if err := json.Unmarshal(data, &svc); err != nil {
+			return err
+		}
+		c.Services = append(c.Services, svc)
+		return nil
+	})
+
+	return s
+}
+
+// ServiceBuilder configures a service added to a config. The Builder's methods
+// are available on it too, to carry on with the rest of the config.
+type ServiceBuilder struct {
+	*Builder
+	index int
+}
+
+func (s *ServiceBuilder) step(name string, f func(*fly.MachineService) error) *ServiceBuilder {
+	s.Builder.step(name, func(c *fly.MachineConfig) error {
+		return f(&c.Services[s.index])
+	})
+
+	return s
+}
+
+// WithCheck adds a health check to the service. The platform checks the
+// service's internal port unless the check has a Port.
+func (s *ServiceBuilder) WithCheck(check Check) *ServiceBuilder {
+	return s.step("WithCheck", func(svc *fly.MachineService) error {
+		if check.Kind != "" {
+			return errors.New("service checks have no kind")
+		}
+		sc, err := check.serviceCheck()
+		if err != nil {
+			return err
+		}
+		svc.Checks = append(svc.Checks, sc)
+		return nil
+	})
+}
+
+// Autostop sets whether the proxy stops or suspends the machine when it's
+// idle.
+func (s *ServiceBuilder) Autostop(autostop fly.MachineAutostop) *ServiceBuilder {
+	return s.step("Autostop", func(svc *fly.MachineService) error {
+		svc.Autostop = fly.Pointer(autostop)
+		return nil
+	})
+}
+
+// Autostart sets whether the proxy starts the machine when a request comes
+// in for it.
+func (s *ServiceBuilder) Autostart(autostart bool) *ServiceBuilder {
+	return s.step("Autostart", func(svc *fly.MachineService) error {
+		svc.Autostart = fly.Pointer(autostart)
+		return nil
+	})
+}
+
+// MinMachinesRunning sets how many machines in the primary region the proxy
+// leaves running when it stops idle ones.
+func (s *ServiceBuilder) MinMachinesRunning(n int) *ServiceBuilder {
+	return s.step("MinMachinesRunning", func(svc *fly.MachineService) error {
+		if n < 0 {
+			return fmt.Errorf("%d is negative", n)
+		}
+		svc.MinMachinesRunning = fly.Pointer(n)
+		return nil
+	})
+}
+
+// Concurrency sets the limits the proxy balances load by, counted in
+// "connections" or "requests".
+func (s *ServiceBuilder) Concurrency(kind string, soft, hard int) *ServiceBuilder {
+	return s.step("Concurrency", func(svc *fly.MachineService) error {
+		switch {
+		case kind != "connections" && kind != "requests":
+			return fmt.Errorf("kind must be connections or requests, not %q", kind)
+		case soft > hard:
+			return fmt.Errorf("soft limit %d is above the hard limit %d", soft, hard)
+		}
+		svc.Concurrency = &fly.MachineServiceConcurrency{Type: kind, SoftLimit: soft, HardLimit: hard}
+		return nil
+	})
+}
+
+// Check is a health check, as either a top-level check or a service check.
+// Zero fields are left to the platform's defaults.
+type Check struct {
+	// Type is "http" or "tcp".
+	Type string
+	// Port is the port on the machine to check. Service checks default to
+	// the service's internal port.
+	Port int
+	// Kind is "informational" or "readiness", for top-level checks only.
+	Kind fly.MachineCheckKind
+
+	Interval    time.Duration
+	Timeout     time.Duration
+	GracePeriod time.Duration
+
+	// Path, Method, Protocol ("http" or "https") and Headers are for http
+	// checks, which need a Path.
+	Path     string
+	Method   string
+	Protocol string
+	Headers  map[string][]string
+	// TLSSkipVerify and TLSServerName are for https checks.
+	TLSSkipVerify bool
+	TLSServerName string
+}
+
+func (c Check) serviceCheck() (fly.MachineServiceCheck, error) {
+	sc := fly.MachineServiceCheck{
+		Port:        optional(c.Port),
+		Type:        optional(c.Type),
+		Interval:    duration(c.Interval),
+		Timeout:     duration(c.Timeout),
+		GracePeriod: duration(c.GracePeriod),
+	}
+
+	switch c.Type {
+	case "tcp":
+		if c.Path != "" || c.Method != "" || c.Protocol != "" || len(c.Headers) > 0 {
+			return sc, errors.New("tcp checks have no HTTP options")
+		}
+	case "http":
+		if !pathpkg.IsAbs(c.Path) {
+			return sc, fmt.Errorf("http checks need an absolute path, not %q", c.Path)
+		}
+		switch c.Protocol {
+		case "", "http", "https":
+		default:
+			return sc, fmt.Errorf("protocol must be http or https, not %q", c.Protocol)
+		}
+		sc.HTTPPath = optional(c.Path)
+		sc.HTTPMethod = optional(c.Method)
+		sc.HTTPProtocol = optional(c.Protocol)
+		sc.HTTPTLSServerName = optional(c.TLSServerName)
+		if c.TLSSkipVerify {
+			sc.HTTPSkipTLSVerify = fly.Pointer(true)
+		}
+		for _, name := range slices.Sorted(maps.Keys(c.Headers)) {
+			sc.HTTPHeaders = append(sc.HTTPHeaders, fly.MachineHTTPHeader{Name: name, Values: c.Headers[name]})
+		}
+	default:
+		return sc, fmt.Errorf("type must be http or tcp, not %q", c.Type)
+	}
+
+	return sc, nil
+}
+
+func (c Check) machineCheck() (fly.MachineCheck, error) {
+	switch c.Kind {
+	case "", fly.MachineCheckKindInformational, fly.MachineCheckKindReadiness:
+	default:
+		return fly.MachineCheck{}, fmt.Errorf("kind must be informational or readiness, not %q", c.Kind)
+	}
+
+	sc, err := c.serviceCheck()
+	if err != nil {
+		return fly.MachineCheck{}, err
+	}
+
+	return fly.MachineCheck{
+		Port:              sc.Port,
+		Type:              sc.Type,
+		Kind:              optional(c.Kind),
+		Interval:          sc.Interval,
+		Timeout:           sc.Timeout,
+		GracePeriod:       sc.GracePeriod,
+		HTTPMethod:        sc.HTTPMethod,
+		HTTPPath:          sc.HTTPPath,
+		HTTPProtocol:      sc.HTTPProtocol,
+		HTTPSkipTLSVerify: sc.HTTPSkipTLSVerify,
+		HTTPTLSServerName: sc.HTTPTLSServerName,
+		HTTPHeaders:       sc.HTTPHeaders,
+	}, nil
+}
+
+// optional returns a pointer to v, or nil if it's the zero value.
+func optional[T comparable](v T) *T {
+	var zero T
+	if v == zero {
+		return nil
+	}
+
+	return &v
+}
+
+func duration(d time.Duration) *fly.Duration {
+	if d == 0 {
+		return nil
+	}
+
+	return &fly.Duration{Duration: d}
+}
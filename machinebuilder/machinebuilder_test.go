@@ -0,0 +1,125 @@
+package machinebuilder
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+)
+
+func TestBuild(t *testing.T) {
+	config, err := New().
+		Image("registry.fly.io/my-app:v1").
+		Guest("performance-2x").
+		Memory(8192).
+		Env("PORT", "8080").
+		ProcessGroup("web").
+		HTTPService(8080).
+		WithCheck(Check{Type: "http", Path: "/healthz", Interval: 15 * time.Second, Headers: map[string][]string{"X-Check": {"1"}}}).
+		Autostop(fly.MachineAutostopSuspend).
+		MinMachinesRunning(1).
+		Mount("vol_123", "/data").
+		Check("alive", Check{Type: "tcp", Port: 9090, Kind: fly.MachineCheckKindReadiness}).
+		Stop("SIGINT", 30*time.Second).
+		Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	if config.Image != "registry.fly.io/my-app:v1" {
+		t.Errorf("Image = %q", config.Image)
+	}
+	if g := config.Guest; g.CPUKind != "performance" || g.CPUs != 2 || g.MemoryMB != 8192 {
+		t.Errorf("Guest = %+v, want performance, 2 CPUs and 8192MB", g)
+	}
+	if config.Env["PORT"] != "8080" || config.ProcessGroup() != "web" {
+		t.Errorf("Env = %v, Metadata = %v", config.Env, config.Metadata)
+	}
+	if len(config.Mounts) != 1 || config.Mounts[0].Volume != "vol_123" || config.Mounts[0].Path != "/data" {
+		t.Errorf("Mounts = %+v", config.Mounts)
+	}
+
+	if len(config.Services) != 1 {
+		t.Fatalf("Services = %+v, want one", config.Services)
+	}
+	svc := config.Services[0]
+	if svc.InternalPort != 8080 || len(svc.Ports) != 2 || *svc.Ports[1].Port != 443 || !svc.Ports[0].ForceHTTPS {
+		t.Errorf("service = %+v", svc)
+	}
+	if *svc.Autostop != fly.MachineAutostopSuspend || *svc.MinMachinesRunning != 1 {
+		t.Errorf("service autostop = %v, min machines = %v", *svc.Autostop, *svc.MinMachinesRunning)
+	}
+	if len(svc.Checks) != 1 {
+		t.Fatalf("service checks = %+v, want one", svc.Checks)
+	}
+	check := svc.Checks[0]
+	if *check.Type != "http" || *check.HTTPPath != "/healthz" || check.Interval.Duration != 15*time.Second || check.Timeout != nil || check.HTTPHeaders[0].Name != "X-Check" {
+		t.Errorf("service check = %+v", check)
+	}
+
+	alive, ok := config.Checks["alive"]
+	if !ok || *alive.Port != 9090 || *alive.Kind != fly.MachineCheckKindReadiness || alive.HTTPPath != nil {
+		t.Errorf("Checks = %+v", config.Checks)
+	}
+	if *config.StopConfig.Signal != "SIGINT" || config.StopConfig.Timeout.Duration != 30*time.Second {
+		t.Errorf("StopConfig = %+v", config.StopConfig)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	_, err := New().
+		Image("").
+		Memory(1024).
+		Guest("shared-cpu-3x").
+		HTTPService(8080).
+		WithCheck(Check{Type: "http"}).
+		Mount("vol_123", "data").
+		Check("alive", Check{Type: "tcp"}).
+		Schedule("every other tuesday").
+		Build()
+	if err == nil {
+		t.Fatal("Build() error = nil")
+	}
+
+	for _, want := range []string{
+		"Image: image must not be empty",
+		"Memory: no guest size has been set",
+		"Guest: 'shared-cpu-3x' is an invalid machine size",
+		`WithCheck: http checks need an absolute path, not ""`,
+		`Mount: "data" is not an absolute path`,
+		"Check: check alive has no port",
+		"schedule: ",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Build() error = %v\nwant it to contain %q", err, want)
+		}
+	}
+
+	var cerrs fly.ConfigErrors
+	if !errors.As(err, &cerrs) || len(cerrs) != 1 {
+		t.Errorf("Build() error = %v, want one problem found by Validate", err)
+	}
+}
+
+func TestBuildIsRepeatable(t *testing.T) {
+	b := New().Image("nginx").HTTPService(80).Builder
+
+	first, err := b.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	*first.Services[0].Ports[0].Port = 8080
+
+	second, err := b.Env("A", "1").Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if *second.Services[0].Ports[0].Port != 80 {
+		t.Errorf("changing one config changed the next one built")
+	}
+	if len(first.Env) != 0 || second.Env["A"] != "1" {
+		t.Errorf("Env = %v then %v, want only the second to have A", first.Env, second.Env)
+	}
+}
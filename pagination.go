@@ -0,0 +1,64 @@
+package fly
+
+import (
+	"context"
+	"iter"
+
+	"github.com/superfly/graphql"
+)
+
+// pageSize is how many nodes of a connection the paginated queries ask for at
+// once.
+const pageSize = 200
+
+// PageInfo is the cursor at the end of a page of a GraphQL connection, and
+// whether there's another page after it.
+type PageInfo struct {
+	HasNextPage bool
+	EndCursor   string
+}
+
+// Paginate iterates over the nodes of a GraphQL connection, fetching a page at
+// a time as the iteration gets to it. newRequest builds the request for the
+// page after the cursor, which is nil for the first page, and page picks the
+// nodes and page info of the connection out of the response.
+//
+// A failed request is yielded as an error with the zero T, and ends the
+// iteration.
+func Paginate[T any](ctx context.Context, c *Client, newRequest func(after *string) *graphql.Request, page func(Query) ([]T, PageInfo)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var after *string
+		for {
+			data, err := c.RunWithContext(ctx, newRequest(after))
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+
+			nodes, info := page(data)
+			for _, node := range nodes {
+				if !yield(node, nil) {
+					return
+				}
+			}
+			if !info.HasNextPage || info.EndCursor == "" {
+				return
+			}
+			after = &info.EndCursor
+		}
+	}
+}
+
+// collect gathers every node of a paginated query, or returns its error.
+func collect[T any](seq iter.Seq2[T, error]) ([]T, error) {
+	out := []T{}
+	for node, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, node)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,74 @@
+package fly
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAllOrganizationsPaginates(t *testing.T) {
+	tripper := &scriptedTripper{steps: []step{
+		{body: `{"data": {"organizations": {"pageInfo": {"hasNextPage": true, "endCursor": "b"}, "nodes": [{"slug": "a"}, {"slug": "b"}]}}}`},
+		{body: `{"data": {"organizations": {"pageInfo": {"hasNextPage": false, "endCursor": "c"}, "nodes": [{"slug": "c"}]}}}`},
+	}}
+	client := newTestClient(tripper)
+
+	orgs, err := client.GetOrganizations(context.Background())
+	if err != nil {
+		t.Fatalf("GetOrganizations() error = %v", err)
+	}
+	var slugs []string
+	for _, org := range orgs {
+		slugs = append(slugs, org.Slug)
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(slugs, want) {
+		t.Fatalf("GetOrganizations() = %v, want %v", slugs, want)
+	}
+	if got := tripper.callCount(); got != 2 {
+		t.Fatalf("call count = %d, want 2", got)
+	}
+}
+
+func TestPaginateStopsEarly(t *testing.T) {
+	tripper := &scriptedTripper{steps: []step{
+		{body: `{"data": {"organizations": {"pageInfo": {"hasNextPage": true, "endCursor": "b"}, "nodes": [{"slug": "a"}, {"slug": "b"}]}}}`},
+	}}
+	client := newTestClient(tripper)
+
+	for org, err := range client.AllOrganizations(context.Background()) {
+		if err != nil {
+			t.Fatalf("AllOrganizations() error = %v", err)
+		}
+		if org.Slug == "a" {
+			break
+		}
+	}
+	if got := tripper.callCount(); got != 1 {
+		t.Fatalf("call count = %d, want 1", got)
+	}
+}
+
+func TestPaginateError(t *testing.T) {
+	tripper := &scriptedTripper{steps: []step{
+		{body: `{"data": {"organizations": {"pageInfo": {"hasNextPage": true, "endCursor": "a"}, "nodes": [{"slug": "a"}]}}}`},
+		{body: `{"errors": [{"message": "boom"}]}`},
+	}}
+	client := newTestClient(tripper)
+
+	var slugs []string
+	var gotErr error
+	for org, err := range client.AllOrganizations(context.Background()) {
+		if err != nil {
+			gotErr = err
+			continue
+		}
+		slugs = append(slugs, org.Slug)
+	}
+	if gotErr == nil || !strings.Contains(gotErr.Error(), "boom") {
+		t.Fatalf("AllOrganizations() error = %v, want the second page's error", gotErr)
+	}
+	if !slices.Equal(slugs, []string{"a"}) {
+		t.Fatalf("AllOrganizations() = %v, want [a]", slugs)
+	}
+}
@@ -3,51 +3,36 @@ package fly
 import (
 	"context"
 	"fmt"
+	"iter"
 	"strings"
+
+	"github.com/superfly/graphql"
 )
 
 func (c *Client) GetApps(ctx context.Context, role *string) ([]App, error) {
-	more := true
-	apps := []App{}
-	var cursor string
-
-	for more {
-		var appPage []App
-		var err error
-
-		appPage, more, cursor, err = c.getAppsPage(ctx, nil, role, &cursor)
-		if err != nil {
-			return nil, err
-		}
-		apps = append(apps, appPage...)
-	}
+	return collect(c.AllApps(ctx, role))
+}
 
-	return apps, nil
+// AllApps iterates over the viewer's apps, optionally only those with role,
+// a page at a time.
+func (c *Client) AllApps(ctx context.Context, role *string) iter.Seq2[App, error] {
+	return c.allApps(ctx, nil, role)
 }
 
 func (c *Client) GetAppsForOrganization(ctx context.Context, orgID string) ([]App, error) {
-	more := true
-	apps := []App{}
-	var cursor string
-
-	for more {
-		var appPage []App
-		var err error
-
-		appPage, more, cursor, err = c.getAppsPage(ctx, &orgID, nil, &cursor)
-		if err != nil {
-			return nil, err
-		}
-		apps = append(apps, appPage...)
-	}
+	return collect(c.AllAppsForOrganization(ctx, orgID))
+}
 
-	return apps, nil
+// AllAppsForOrganization iterates over an organization's apps, a page at a
+// time.
+func (c *Client) AllAppsForOrganization(ctx context.Context, orgID string) iter.Seq2[App, error] {
+	return c.allApps(ctx, &orgID, nil)
 }
 
-func (c *Client) getAppsPage(ctx context.Context, orgID *string, role *string, after *string) ([]App, bool, string, error) {
+func (c *Client) allApps(ctx context.Context, orgID *string, role *string) iter.Seq2[App, error] {
 	query := `
-		query($org: ID, $role: String, $after: String) {
-			apps(type: "container", first: 200, after: $after, organizationId: $org, role: $role) {
+		query($org: ID, $role: String, $first: Int, $after: String) {
+			apps(type: "container", first: $first, after: $after, organizationId: $org, role: $role) {
 				pageInfo {
 					hasNextPage
 					endCursor
@@ -72,24 +57,24 @@ func (c *Client) getAppsPage(ctx context.Context, orgID *string, role *string, a
 		}
 		`
 
-	req := c.NewRequest(query)
 	ctx = ctxWithAction(ctx, "get_apps_page")
-	if orgID != nil {
-		req.Var("org", *orgID)
-	}
-	if role != nil {
-		req.Var("role", *role)
-	}
-	if after != nil {
-		req.Var("after", *after)
-	}
 
-	data, err := c.RunWithContext(ctx, req)
-	if err != nil {
-		return nil, false, "", err
-	}
-
-	return data.Apps.Nodes, data.Apps.PageInfo.HasNextPage, data.Apps.PageInfo.EndCursor, nil
+	return Paginate(ctx, c, func(after *string) *graphql.Request {
+		req := c.NewRequest(query)
+		req.Var("first", pageSize)
+		if orgID != nil {
+			req.Var("org", *orgID)
+		}
+		if role != nil {
+			req.Var("role", *role)
+		}
+		if after != nil {
+			req.Var("after", *after)
+		}
+		return req
+	}, func(data Query) ([]App, PageInfo) {
+		return data.Apps.Nodes, data.Apps.PageInfo
+	})
 }
 
 func (c *Client) GetApp(ctx context.Context, appName string) (*App, error) {
@@ -3,9 +3,14 @@ package fly
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
 type getLogsResponse struct {
@@ -61,3 +66,257 @@ func (c *Client) GetAppLogs(ctx context.Context, appName, token, region, instanc
 
 	return
 }
+
+// TailLogsOptions filter the logs TailAppLogs follows, and set how often it
+// polls for them.
+type TailLogsOptions struct {
+	// LogFilter selects the entries to follow.
+	LogFilter
+
+	// Token is where to start following the logs from, as returned with a
+	// page of GetAppLogs. Without one, the logs start from the latest
+	// entries.
+	Token string
+
+	// PollInterval is how long to wait after a page with no entries before
+	// polling again. It doubles with each empty page in a row, up to
+	// MaxPollInterval, and goes back to PollInterval once there are entries.
+	// They default to a second and 30 seconds.
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+}
+
+const (
+	defaultLogsPollInterval    = time.Second
+	defaultLogsMaxPollInterval = 30 * time.Second
+)
+
+// TailAppLogs follows an app's logs, yielding each entry that matches opts
+// once, in the order they're logged, until ctx is cancelled or the iteration
+// stops.
+//
+// Failed polls are retried from the last page that succeeded, waiting as
+// after an empty page. A client error, such as a missing app, is yielded and
+// ends the iteration; cancelling ctx ends it without an error. An entry whose
+// timestamp can't be parsed is yielded as an error, and the iteration carries
+// on.
+func (c *Client) TailAppLogs(ctx context.Context, appName string, opts TailLogsOptions) iter.Seq2[LogEntry, error] {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultLogsPollInterval
+	}
+	if opts.MaxPollInterval < opts.PollInterval {
+		opts.MaxPollInterval = max(defaultLogsMaxPollInterval, opts.PollInterval)
+	}
+
+	return func(yield func(LogEntry, error) bool) {
+		token := opts.Token
+		wait := opts.PollInterval
+		// Pages can overlap, when a poll is retried or the API hands back
+		// entries it already did, so the keys of the last page with entries
+		// are kept to drop the repeats.
+		var last map[string]struct{}
+
+		for {
+			entries, nextToken, err := c.GetAppLogs(ctx, appName, token, opts.Region, opts.MachineID)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil && IsClientError(err) && !isRateLimited(err):
+				yield(LogEntry{}, err)
+				return
+			}
+
+			if err == nil {
+				if nextToken != "" {
+					token = nextToken
+				}
+
+				page := make(map[string]struct{}, len(entries))
+				for _, entry := range entries {
+					key := entry.Timestamp + "\x00" + entry.Instance
+					_, repeat := last[key]
+					_, dup := page[key]
+					page[key] = struct{}{}
+					if repeat || dup {
+						continue
+					}
+					r, err := entry.Record()
+					switch {
+					case err != nil:
+						if !yield(LogEntry{}, err) {
+							return
+						}
+					case opts.Matches(r):
+						if !yield(entry, nil) {
+							return
+						}
+					}
+				}
+
+				if len(entries) > 0 {
+					last = page
+					wait = opts.PollInterval
+					continue
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			wait = min(wait*2, opts.MaxPollInterval)
+		}
+	}
+}
+
+func isRateLimited(err error) bool {
+	var apiErr *ApiError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusTooManyRequests
+}
+
+// LogFilter selects log entries. The zero value selects every entry.
+type LogFilter struct {
+	// Start and End bound the time of the entries, Start included and End
+	// excluded. A zero time leaves that end open.
+	Start time.Time
+	End   time.Time
+
+	// Level only selects entries of the level, such as "info" or "error",
+	// regardless of case.
+	Level string
+	// MachineID, Region and ProcessGroup only select entries from the
+	// machine, region, or process group.
+	MachineID    string
+	Region       string
+	ProcessGroup string
+
+	// MinStatus and MaxStatus only select entries about HTTP responses with
+	// a status in the range, bounds included. A zero bound leaves that end
+	// open; with both zero, entries are selected whatever they're about.
+	MinStatus int
+	MaxStatus int
+
+	// Match only selects entries whose message contains it, regardless of
+	// case.
+	Match string
+}
+
+// LogQuery selects the entries of an app's logs QueryAppLogs returns. The zero
+// value selects every entry.
+type LogQuery struct {
+	// LogFilter selects the entries to return.
+	LogFilter
+
+	// Token is where to start reading the logs from, as returned with a
+	// page of GetAppLogs. Without one, they start from the latest entries:
+	// the API has no way to start from a time, so Start only skips entries
+	// and older logs can only be reached from a token saved when they were
+	// recent.
+	Token string
+}
+
+// LogRecord is a log entry, with its time parsed.
+type LogRecord struct {
+	Time     time.Time `json:"time"`
+	Message  string    `json:"message"`
+	Level    string    `json:"level,omitempty"`
+	Instance string    `json:"instance,omitempty"`
+	Region   string    `json:"region,omitempty"`
+	Meta     LogMeta   `json:"meta"`
+}
+
+// Record parses the entry's timestamp into a LogRecord.
+func (e LogEntry) Record() (LogRecord, error) {
+	t, err := time.Parse(time.RFC3339Nano, e.Timestamp)
+	if err != nil {
+		return LogRecord{}, fmt.Errorf("invalid log timestamp %q: %w", e.Timestamp, err)
+	}
+
+	return LogRecord{
+		Time:     t,
+		Message:  e.Message,
+		Level:    e.Level,
+		Instance: e.Instance,
+		Region:   e.Region,
+		Meta:     e.Meta,
+	}, nil
+}
+
+// Matches reports whether the filter selects r.
+func (f LogFilter) Matches(r LogRecord) bool {
+	switch {
+	case !f.Start.IsZero() && r.Time.Before(f.Start):
+		return false
+	case !f.End.IsZero() && !r.Time.Before(f.End):
+		return false
+	case f.Level != "" && !strings.EqualFold(r.Level, f.Level):
+		return false
+	case f.MachineID != "" && r.Instance != f.MachineID && r.Meta.Instance != f.MachineID:
+		return false
+	case f.Region != "" && r.Region != f.Region && r.Meta.Region != f.Region:
+		return false
+	case f.ProcessGroup != "" && r.Meta.ProcessGroup != f.ProcessGroup:
+		return false
+	case f.Match != "" && !strings.Contains(strings.ToLower(r.Message), strings.ToLower(f.Match)):
+		return false
+	}
+
+	if f.MinStatus != 0 || f.MaxStatus != 0 {
+		status := r.Meta.HTTP.Response.StatusCode
+		if status == 0 || status < f.MinStatus || (f.MaxStatus != 0 && status > f.MaxStatus) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// QueryAppLogs pages through an app's logs from q.Token, oldest first, and
+// returns the entries q selects. It stops at the first entry past q.End, or
+// once it has caught up with the latest entries.
+//
+// The API only filters by machine and region, so the rest of the filter,
+// q.Start included, is applied to each page as it arrives.
+func (c *Client) QueryAppLogs(ctx context.Context, appName string, q LogQuery) ([]LogRecord, error) {
+	records := []LogRecord{}
+	token := q.Token
+
+	for {
+		entries, nextToken, err := c.GetAppLogs(ctx, appName, token, q.Region, q.MachineID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			r, err := entry.Record()
+			if err != nil {
+				return nil, err
+			}
+			if !q.End.IsZero() && !r.Time.Before(q.End) {
+				return records, nil
+			}
+			if q.Matches(r) {
+				records = append(records, r)
+			}
+		}
+
+		if len(entries) == 0 || nextToken == "" || nextToken == token {
+			return records, nil
+		}
+		token = nextToken
+	}
+}
+
+// WriteLogsNDJSON writes the records to w as newline-delimited JSON, one
+// record per line.
+func WriteLogsNDJSON(w io.Writer, records []LogRecord) error {
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
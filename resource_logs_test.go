@@ -0,0 +1,204 @@
+package fly
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logsTripper serves the pages of logs in order, and records the token each
+// poll asks for.
+type logsTripper struct {
+	mu     sync.Mutex
+	pages  []logsPage
+	tokens []string
+}
+
+type logsPage struct {
+	status int
+	body   string
+}
+
+func (l *logsTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens = append(l.tokens, req.URL.Query().Get("next_token"))
+	page := logsPage{status: http.StatusNotFound}
+	if len(l.pages) > 0 {
+		page, l.pages = l.pages[0], l.pages[1:]
+	}
+
+	return &http.Response{
+		StatusCode: page.status,
+		Status:     http.StatusText(page.status),
+		Body:       io.NopCloser(strings.NewReader(page.body)),
+		Header:     make(http.Header),
+	}, nil
+}
+
+func newLogsTripper() *logsTripper {
+	return &logsTripper{pages: []logsPage{
+		{http.StatusOK, `{"data": [
+			{"attributes": {"timestamp": "2024-05-01T12:00:01Z", "instance": "i1", "level": "info", "message": "starting"}},
+			{"attributes": {"timestamp": "2024-05-01T12:00:02Z", "instance": "i1", "level": "info", "message": "listening"}}
+		], "meta": {"next_token": "n1"}}`},
+		{http.StatusInternalServerError, ``},
+		{http.StatusOK, `{"data": [
+			{"attributes": {"timestamp": "2024-05-01T12:00:02Z", "instance": "i1", "level": "info", "message": "listening"}},
+			{"attributes": {"timestamp": "2024-05-01T12:00:03Z", "instance": "i2", "level": "error", "message": "boom"}}
+		], "meta": {"next_token": "n2"}}`},
+		{http.StatusOK, `{"data": [], "meta": {"next_token": ""}}`},
+	}}
+}
+
+func TestTailAppLogs(t *testing.T) {
+	tripper := newLogsTripper()
+	client := newTestClient(tripper)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var messages []string
+	var tailErr error
+	for entry, err := range client.TailAppLogs(ctx, "my-app", TailLogsOptions{PollInterval: time.Millisecond}) {
+		if err != nil {
+			tailErr = err
+			break
+		}
+		messages = append(messages, entry.Message)
+	}
+
+	if want := []string{"starting", "listening", "boom"}; !slices.Equal(messages, want) {
+		t.Errorf("TailAppLogs() messages = %v, want %v", messages, want)
+	}
+	if !IsNotFoundError(tailErr) {
+		t.Errorf("TailAppLogs() error = %v, want a 404", tailErr)
+	}
+	if want := []string{"", "n1", "n1", "n2", "n2"}; !slices.Equal(tripper.tokens, want) {
+		t.Errorf("tokens = %v, want %v", tripper.tokens, want)
+	}
+}
+
+func TestTailAppLogsFilters(t *testing.T) {
+	tests := []struct {
+		name string
+		opts TailLogsOptions
+		want []string
+	}{
+		{"level", TailLogsOptions{LogFilter: LogFilter{Level: "ERROR"}}, []string{"boom"}},
+		{"machine", TailLogsOptions{LogFilter: LogFilter{MachineID: "i1"}}, []string{"starting", "listening"}},
+		{"match", TailLogsOptions{LogFilter: LogFilter{Match: "ING"}}, []string{"starting", "listening"}},
+		{"start", TailLogsOptions{LogFilter: LogFilter{Start: time.Date(2024, 5, 1, 12, 0, 2, 0, time.UTC)}}, []string{"listening", "boom"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(newLogsTripper())
+			tt.opts.PollInterval = time.Millisecond
+
+			var messages []string
+			for entry, err := range client.TailAppLogs(context.Background(), "my-app", tt.opts) {
+				if err != nil {
+					break
+				}
+				messages = append(messages, entry.Message)
+			}
+			if !slices.Equal(messages, tt.want) {
+				t.Errorf("TailAppLogs() messages = %v, want %v", messages, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryAppLogs(t *testing.T) {
+	tripper := &logsTripper{pages: []logsPage{
+		{http.StatusOK, `{"data": [
+			{"attributes": {"timestamp": "2024-05-01T12:01:00.5Z", "level": "info", "message": "GET / 200",
+				"meta": {"instance": "m1", "process_group": "web", "http": {"response": {"status_code": 200}}}}},
+			{"attributes": {"timestamp": "2024-05-01T12:02:00Z", "level": "error", "message": "GET /boom 502",
+				"meta": {"instance": "m1", "process_group": "web", "http": {"response": {"status_code": 502}}}}}
+		], "meta": {"next_token": "n1"}}`},
+		{http.StatusOK, `{"data": [
+			{"attributes": {"timestamp": "2024-05-01T12:03:00Z", "level": "error", "message": "Worker BOOM",
+				"meta": {"instance": "m2", "process_group": "worker"}}},
+			{"attributes": {"timestamp": "2024-05-01T12:10:00Z", "level": "error", "message": "too late"}}
+		], "meta": {"next_token": "n2"}}`},
+	}}
+	client := newTestClient(tripper)
+
+	base := LogQuery{
+		Token:     "n0",
+		LogFilter: LogFilter{End: time.Date(2024, 5, 1, 12, 5, 0, 0, time.UTC)},
+	}
+	records, err := client.QueryAppLogs(context.Background(), "my-app", base)
+	if err != nil {
+		t.Fatalf("QueryAppLogs() error = %v", err)
+	}
+	var messages []string
+	for _, r := range records {
+		messages = append(messages, r.Message)
+	}
+	if want := []string{"GET / 200", "GET /boom 502", "Worker BOOM"}; !slices.Equal(messages, want) {
+		t.Fatalf("QueryAppLogs() messages = %v, want %v", messages, want)
+	}
+	if want := time.Date(2024, 5, 1, 12, 1, 0, 5e8, time.UTC); !records[0].Time.Equal(want) {
+		t.Errorf("Time = %v, want %v", records[0].Time, want)
+	}
+	// The query started from its token and stopped at the first entry past
+	// its end.
+	if want := []string{"n0", "n1"}; !slices.Equal(tripper.tokens, want) {
+		t.Errorf("tokens = %v, want %v", tripper.tokens, want)
+	}
+
+	tests := []struct {
+		name  string
+		query func(q LogQuery) LogQuery
+		want  []string
+	}{
+		{"level", func(q LogQuery) LogQuery { q.Level = "ERROR"; return q }, []string{"GET /boom 502", "Worker BOOM"}},
+		{"process group", func(q LogQuery) LogQuery { q.ProcessGroup = "worker"; return q }, []string{"Worker BOOM"}},
+		{"machine", func(q LogQuery) LogQuery { q.MachineID = "m1"; return q }, []string{"GET / 200", "GET /boom 502"}},
+		{"status", func(q LogQuery) LogQuery { q.MinStatus = 500; q.MaxStatus = 599; return q }, []string{"GET /boom 502"}},
+		{"match", func(q LogQuery) LogQuery { q.Match = "boom"; return q }, []string{"GET /boom 502", "Worker BOOM"}},
+		{"start", func(q LogQuery) LogQuery { q.Start = time.Date(2024, 5, 1, 12, 2, 0, 0, time.UTC); return q }, []string{"GET /boom 502", "Worker BOOM"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.query(base)
+			var got []string
+			for _, r := range records {
+				if q.Matches(r) {
+					got = append(got, r.Message)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Matches() selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteLogsNDJSON(t *testing.T) {
+	records := []LogRecord{
+		{Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Message: "one", Meta: LogMeta{Instance: "m1"}},
+		{Time: time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC), Message: "two"},
+	}
+
+	var buf strings.Builder
+	if err := WriteLogsNDJSON(&buf, records); err != nil {
+		t.Fatalf("WriteLogsNDJSON() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("WriteLogsNDJSON() wrote %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+	if !strings.HasPrefix(lines[0], `{"time":"2024-05-01T12:00:00Z","message":"one","meta":{"instance":"m1",`) {
+		t.Errorf("first line = %s", lines[0])
+	}
+}
@@ -2,6 +2,7 @@ package fly
 
 import (
 	"context"
+	"iter"
 
 	"github.com/superfly/graphql"
 )
@@ -26,9 +27,19 @@ type OrganizationFilter func(*organizationFilter)
 var AdminOnly OrganizationFilter = func(f *organizationFilter) { f.admin = true }
 
 func (c *Client) GetOrganizations(ctx context.Context, filters ...OrganizationFilter) ([]Organization, error) {
+	return collect(c.AllOrganizations(ctx, filters...))
+}
+
+// AllOrganizations iterates over the viewer's organizations, a page at a
+// time.
+func (c *Client) AllOrganizations(ctx context.Context, filters ...OrganizationFilter) iter.Seq2[Organization, error] {
 	q := `
-		query($admin: Boolean!) {
-			organizations(admin: $admin) {
+		query($admin: Boolean!, $first: Int, $after: String) {
+			organizations(admin: $admin, first: $first, after: $after) {
+				pageInfo {
+					hasNextPage
+					endCursor
+				}
 				nodes {
 					id
 					slug
@@ -49,17 +60,19 @@ func (c *Client) GetOrganizations(ctx context.Context, filters ...OrganizationFi
 		f(filter)
 	}
 
-	req := c.NewRequest(q)
-	filter.apply(req)
-
 	ctx = ctxWithAction(ctx, "get_organizations")
 
-	data, err := c.RunWithContext(ctx, req)
-	if err != nil {
-		return nil, err
-	}
-
-	return data.Organizations.Nodes, nil
+	return Paginate(ctx, c, func(after *string) *graphql.Request {
+		req := c.NewRequest(q)
+		filter.apply(req)
+		req.Var("first", pageSize)
+		if after != nil {
+			req.Var("after", *after)
+		}
+		return req
+	}, func(data Query) ([]Organization, PageInfo) {
+		return data.Organizations.Nodes, data.Organizations.PageInfo
+	})
 }
 
 func (c *Client) GetOrganizationRemoteBuilderBySlug(ctx context.Context, slug string) (*Organization, error) {
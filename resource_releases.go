@@ -1,6 +1,11 @@
 package fly
 
-import "context"
+import (
+	"context"
+	"iter"
+
+	"github.com/superfly/graphql"
+)
 
 func (c *Client) GetAppReleasesMachines(ctx context.Context, appName, status string, limit int) ([]Release, error) {
 	query := `
@@ -43,6 +48,52 @@ func (c *Client) GetAppReleasesMachines(ctx context.Context, appName, status str
 	return data.App.Releases.Nodes, nil
 }
 
+// AllAppReleasesMachines iterates over every release of an app, newest first,
+// a page at a time.
+func (c *Client) AllAppReleasesMachines(ctx context.Context, appName string) iter.Seq2[Release, error] {
+	query := `
+		query($appName: String!, $first: Int, $after: String) {
+			app(name: $appName) {
+				releases: releasesUnprocessed(first: $first, after: $after) {
+					pageInfo {
+						hasNextPage
+						endCursor
+					}
+					nodes {
+						id
+						version
+						description
+						reason
+						status
+						imageRef
+						stable
+						user {
+							id
+							email
+							name
+						}
+						createdAt
+					}
+				}
+			}
+		}
+	`
+
+	ctx = ctxWithAction(ctx, "get_app_releases_machines")
+
+	return Paginate(ctx, c, func(after *string) *graphql.Request {
+		req := c.NewRequest(query)
+		req.Var("appName", appName)
+		req.Var("first", pageSize)
+		if after != nil {
+			req.Var("after", *after)
+		}
+		return req
+	}, func(data Query) ([]Release, PageInfo) {
+		return data.App.Releases.Nodes, data.App.Releases.PageInfo
+	})
+}
+
 func (c *Client) GetAppCurrentReleaseMachines(ctx context.Context, appName string) (*Release, error) {
 	query := `
 		query ($appName: String!) {
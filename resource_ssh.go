@@ -3,16 +3,31 @@ package fly
 import (
 	"context"
 	"crypto/ed25519"
+	"iter"
 	"strings"
 
+	"github.com/superfly/graphql"
 	"golang.org/x/crypto/ssh"
 )
 
 func (c *Client) GetLoggedCertificates(ctx context.Context, slug string) ([]LoggedCertificate, error) {
-	req := c.NewRequest(`
-query($slug: String!) {
+	return collect(c.AllLoggedCertificates(ctx, slug))
+}
+
+// AllLoggedCertificates iterates over the SSH certificates issued for an
+// organization, a page at a time.
+func (c *Client) AllLoggedCertificates(ctx context.Context, slug string) iter.Seq2[LoggedCertificate, error] {
+	ctx = ctxWithAction(ctx, "get_logged_certificates")
+
+	return Paginate(ctx, c, func(after *string) *graphql.Request {
+		req := c.NewRequest(`
+query($slug: String!, $first: Int, $after: String) {
   organization(slug: $slug) {
-    loggedCertificates {
+    loggedCertificates(first: $first, after: $after) {
+      pageInfo {
+        hasNextPage
+        endCursor
+      }
       nodes {
         root
         cert
@@ -21,15 +36,18 @@ query($slug: String!) {
   }
 }
 `)
-	req.Var("slug", slug)
-	ctx = ctxWithAction(ctx, "get_logged_certificates")
-
-	data, err := c.RunWithContext(ctx, req)
-	if err != nil {
-		return nil, err
-	}
-
-	return data.Organization.LoggedCertificates.Nodes, nil
+		req.Var("slug", slug)
+		req.Var("first", pageSize)
+		if after != nil {
+			req.Var("after", *after)
+		}
+		return req
+	}, func(data Query) ([]LoggedCertificate, PageInfo) {
+		if data.Organization == nil || data.Organization.LoggedCertificates == nil {
+			return nil, PageInfo{}
+		}
+		return data.Organization.LoggedCertificates.Nodes, data.Organization.LoggedCertificates.PageInfo
+	})
 }
 
 func (c *Client) IssueSSHCertificate(ctx context.Context, orgID string, principals []string, appNames []string, valid_hours *int, publicKey ed25519.PublicKey) (*IssuedCertificate, error) {
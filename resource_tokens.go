@@ -2,13 +2,26 @@ package fly
 
 import (
 	"context"
+	"iter"
+
+	"github.com/superfly/graphql"
 )
 
 func (c *Client) GetOrgLimitedAccessTokens(ctx context.Context, orgSlug string) ([]LimitedAccessToken, error) {
+	return collect(c.AllOrgLimitedAccessTokens(ctx, orgSlug))
+}
+
+// AllOrgLimitedAccessTokens iterates over an organization's limited access
+// tokens, a page at a time.
+func (c *Client) AllOrgLimitedAccessTokens(ctx context.Context, orgSlug string) iter.Seq2[LimitedAccessToken, error] {
 	query := `
-		query ($slug: String!) {
+		query ($slug: String!, $first: Int, $after: String) {
 			orgLimitedAccessTokens: organization(slug: $slug) {
-				limitedAccessTokens {
+				limitedAccessTokens(first: $first, after: $after) {
+					pageInfo {
+						hasNextPage
+						endCursor
+					}
 					nodes {
 						id
 						name
@@ -23,20 +36,22 @@ func (c *Client) GetOrgLimitedAccessTokens(ctx context.Context, orgSlug string)
 		}
 	`
 
-	req := c.NewRequest(query)
-	req.Var("slug", orgSlug)
 	ctx = ctxWithAction(ctx, "get_org_limited_access_tokens")
 
-	data, err := c.RunWithContext(ctx, req)
-	if err != nil {
-		return nil, err
-	}
-
-	if data.OrgLimitedAccessTokens == nil {
-		return nil, nil
-	}
-
-	return data.OrgLimitedAccessTokens.LimitedAccessTokens.Nodes, nil
+	return Paginate(ctx, c, func(after *string) *graphql.Request {
+		req := c.NewRequest(query)
+		req.Var("slug", orgSlug)
+		req.Var("first", pageSize)
+		if after != nil {
+			req.Var("after", *after)
+		}
+		return req
+	}, func(data Query) ([]LimitedAccessToken, PageInfo) {
+		if data.OrgLimitedAccessTokens == nil {
+			return nil, PageInfo{}
+		}
+		return data.OrgLimitedAccessTokens.LimitedAccessTokens.Nodes, data.OrgLimitedAccessTokens.LimitedAccessTokens.PageInfo
+	})
 }
 
 func (c *Client) GetAppLimitedAccessTokens(ctx context.Context, appName string) ([]LimitedAccessToken, error) {
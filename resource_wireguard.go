@@ -3,7 +3,10 @@ package fly
 import (
 	"context"
 	"fmt"
+	"iter"
 	"os"
+
+	"github.com/superfly/graphql"
 )
 
 func (c *Client) GetWireGuardPeer(ctx context.Context, slug, name string) (*WireGuardPeer, error) {
@@ -34,10 +37,23 @@ query($slug: String!, $name: String!) {
 }
 
 func (c *Client) GetWireGuardPeers(ctx context.Context, slug string) ([]*WireGuardPeer, error) {
-	req := c.NewRequest(`
-query($slug: String!) {
+	return collect(c.AllWireGuardPeers(ctx, slug))
+}
+
+// AllWireGuardPeers iterates over an organization's WireGuard peers, a page at
+// a time.
+func (c *Client) AllWireGuardPeers(ctx context.Context, slug string) iter.Seq2[*WireGuardPeer, error] {
+	ctx = ctxWithAction(ctx, "get_wg_peers")
+
+	return Paginate(ctx, c, func(after *string) *graphql.Request {
+		req := c.NewRequest(`
+query($slug: String!, $first: Int, $after: String) {
   organization(slug: $slug) {
-    wireGuardPeers {
+    wireGuardPeers(first: $first, after: $after) {
+      pageInfo {
+        hasNextPage
+        endCursor
+      }
       nodes {
         id
         name
@@ -49,15 +65,18 @@ query($slug: String!) {
   }
 }
 `)
-	req.Var("slug", slug)
-	ctx = ctxWithAction(ctx, "get_wg_peers")
-
-	data, err := c.RunWithContext(ctx, req)
-	if err != nil {
-		return nil, err
-	}
-
-	return *data.Organization.WireGuardPeers.Nodes, nil
+		req.Var("slug", slug)
+		req.Var("first", pageSize)
+		if after != nil {
+			req.Var("after", *after)
+		}
+		return req
+	}, func(data Query) ([]*WireGuardPeer, PageInfo) {
+		if data.Organization == nil || data.Organization.WireGuardPeers.Nodes == nil {
+			return nil, PageInfo{}
+		}
+		return *data.Organization.WireGuardPeers.Nodes, data.Organization.WireGuardPeers.PageInfo
+	})
 }
 
 func (c *Client) CreateWireGuardPeer(ctx context.Context, orgID string, region, name, pubkey, network string) (*CreatedWireGuardPeer, error) {
@@ -0,0 +1,211 @@
+package fly
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/superfly/fly-go/internal/retryafter"
+)
+
+const contextKeyIdempotent = contextKey("idempotent")
+
+// WithIdempotent returns a context that marks the requests made with it as
+// safe to repeat, so that a RetryPolicy retries them whatever their method.
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyIdempotent, true)
+}
+
+// RetryPolicy controls how a client retries requests that fail.
+//
+// Only idempotent requests are retried: GET and HEAD requests, requests with
+// an Idempotency-Key header, and requests made with a context from
+// WithIdempotent. The clients mark the POSTs they know to be safe to repeat,
+// such as GraphQL queries.
+type RetryPolicy struct {
+	// MaxRetries is the most times a request is retried.
+	MaxRetries int
+
+	// InitialInterval and MaxInterval bound the exponential, jittered
+	// delay between attempts.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+
+	// Budget is the most time spent on a request, counting every attempt
+	// and the delays between them. A retry that couldn't start before the
+	// budget runs out isn't made. Zero means no limit beyond the context's.
+	Budget time.Duration
+
+	// RetryStatus reports whether a response with the status code should be
+	// retried. If nil, DefaultRetryStatus is used.
+	RetryStatus func(statusCode int) bool
+
+	// RetryError reports whether a request that failed without a response
+	// should be retried. If nil, DefaultRetryError is used.
+	RetryError func(err error) bool
+}
+
+// DefaultRetryPolicy returns a starting point for a policy: about as many
+// retries, as far apart, as the clients make without one. It isn't what
+// they use when none is set, which remains the retries they've always made.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries:      3,
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     1 * time.Second,
+		Budget:          1 * time.Minute,
+	}
+}
+
+// DefaultRetryStatus retries rate limited requests and the responses the
+// proxy returns when it couldn't reach the API.
+func DefaultRetryStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return true
+	}
+
+	return false
+}
+
+// DefaultRetryError retries connection resets and temporary network errors.
+func DefaultRetryError(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var temporary interface{ Temporary() bool }
+
+	return errors.As(err, &temporary) && temporary.Temporary()
+}
+
+// Transport returns a transport that makes requests with inner, retrying
+// them according to the policy.
+func (p *RetryPolicy) Transport(inner http.RoundTripper) http.RoundTripper {
+	return &retryTransport{inner: inner, policy: p}
+}
+
+// Retries reports whether the policy will retry req at all.
+func (p *RetryPolicy) Retries(req *http.Request) bool {
+	if p.MaxRetries <= 0 {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	switch {
+	case req.Method == http.MethodGet, req.Method == http.MethodHead:
+		return true
+	case req.Header.Get("Idempotency-Key") != "":
+		return true
+	}
+	idempotent, _ := req.Context().Value(contextKeyIdempotent).(bool)
+
+	return idempotent
+}
+
+func (p *RetryPolicy) retryStatus(statusCode int) bool {
+	if p.RetryStatus != nil {
+		return p.RetryStatus(statusCode)
+	}
+
+	return DefaultRetryStatus(statusCode)
+}
+
+func (p *RetryPolicy) retryError(err error) bool {
+	if p.RetryError != nil {
+		return p.RetryError(err)
+	}
+
+	return DefaultRetryError(err)
+}
+
+// delay returns how long to wait before retry number n, counting from zero.
+func (p *RetryPolicy) delay(n int) time.Duration {
+	d := p.InitialInterval
+	for range n {
+		d *= 2
+		if p.MaxInterval > 0 && d >= p.MaxInterval {
+			d = p.MaxInterval
+			break
+		}
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	// Full jitter around the middle of the interval, so that clients
+	// retrying together spread out.
+	return d/2 + rand.N(d/2+1)
+}
+
+type retryTransport struct {
+	inner  http.RoundTripper
+	policy *RetryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.policy.Retries(req) {
+		return t.inner.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	var deadline time.Time
+	if t.policy.Budget > 0 {
+		deadline = time.Now().Add(t.policy.Budget)
+	}
+
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		resp, err := t.inner.RoundTrip(attemptReq)
+
+		var retry bool
+		var wait time.Duration
+		switch {
+		case err != nil:
+			retry = ctx.Err() == nil && t.policy.retryError(err)
+			wait = t.policy.delay(attempt)
+		case t.policy.retryStatus(resp.StatusCode):
+			retry = true
+			wait = t.policy.delay(attempt)
+			if after, ok := retryafter.Parse(resp.Header.Get("Retry-After")); ok {
+				wait = after
+			}
+		}
+
+		if !retry || attempt >= t.policy.MaxRetries {
+			return resp, err
+		}
+		if !deadline.IsZero() && time.Now().Add(wait).After(deadline) {
+			return resp, err
+		}
+
+		if resp != nil {
+			// Drain a little of the body so the connection can be reused.
+			_, _ = io.CopyN(io.Discard, resp.Body, 4<<10)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package fly
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyServer fails the first failures requests with status, and counts the
+// requests it gets.
+func flakyServer(t *testing.T, failures int, status int, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(calls.Add(1)) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &calls
+}
+
+func fastRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries:      3,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     5 * time.Millisecond,
+	}
+}
+
+func TestRetryPolicyRetriesIdempotentRequests(t *testing.T) {
+	cases := []struct {
+		name      string
+		method    string
+		ctx       func(context.Context) context.Context
+		header    http.Header
+		wantCalls int32
+	}{
+		{name: "GET", method: http.MethodGet, wantCalls: 3},
+		{name: "POST", method: http.MethodPost, wantCalls: 1},
+		{name: "POST marked idempotent", method: http.MethodPost, ctx: WithIdempotent, wantCalls: 3},
+		{name: "POST with an idempotency key", method: http.MethodPost, header: http.Header{"Idempotency-Key": {"abc"}}, wantCalls: 3},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, calls := flakyServer(t, 2, http.StatusServiceUnavailable, "")
+			client := &http.Client{Transport: fastRetryPolicy().Transport(http.DefaultTransport)}
+
+			ctx := context.Background()
+			if tc.ctx != nil {
+				ctx = tc.ctx(ctx)
+			}
+			req, _ := http.NewRequestWithContext(ctx, tc.method, srv.URL, strings.NewReader(`{"cmd":"ls"}`))
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			resp.Body.Close()
+			if got := calls.Load(); got != tc.wantCalls {
+				t.Errorf("server got %d requests, want %d", got, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyHonoursRetryAfter(t *testing.T) {
+	srv, calls := flakyServer(t, 1, http.StatusTooManyRequests, "1")
+	client := &http.Client{Transport: fastRetryPolicy().Transport(http.DefaultTransport)}
+
+	start := time.Now()
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || calls.Load() != 2 {
+		t.Fatalf("Get() = %d after %d requests, want 200 after 2", resp.StatusCode, calls.Load())
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least the 1s Retry-After", elapsed)
+	}
+}
+
+func TestRetryPolicyBudget(t *testing.T) {
+	srv, calls := flakyServer(t, 10, http.StatusTooManyRequests, "5")
+	policy := fastRetryPolicy()
+	policy.Budget = time.Second
+	client := &http.Client{Transport: policy.Transport(http.DefaultTransport)}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+
+	// A Retry-After past the budget gives up straight away, with the
+	// response that asked for it.
+	if resp.StatusCode != http.StatusTooManyRequests || calls.Load() != 1 {
+		t.Fatalf("Get() = %d after %d requests, want 429 after 1", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestRetryPolicyClassifiers(t *testing.T) {
+	srv, calls := flakyServer(t, 1, http.StatusInternalServerError, "")
+	policy := fastRetryPolicy()
+	policy.RetryStatus = func(statusCode int) bool { return statusCode >= 500 }
+	client := &http.Client{Transport: policy.Transport(http.DefaultTransport)}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 2 {
+		t.Fatalf("Get() = %d after %d requests, want 200 after 2", resp.StatusCode, calls.Load())
+	}
+
+	errNope := errors.New("nope")
+	tripper := &scriptedTripper{steps: []step{{err: errNope}, {err: connReset()}, {body: "{}"}}}
+	policy.RetryError = func(err error) bool { return errors.Is(err, errNope) }
+	client = &http.Client{Transport: policy.Transport(tripper)}
+
+	if _, err := client.Get("http://example.test"); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("Get() error = %v, want the connection reset the classifier doesn't retry", err)
+	}
+	if got := tripper.callCount(); got != 2 {
+		t.Errorf("transport got %d requests, want 2", got)
+	}
+}
+
+func TestClientRetryPolicyRetriesQueries(t *testing.T) {
+	tripper := &scriptedTripper{steps: []step{
+		{err: connReset()},
+		{err: connReset()},
+		{body: `{"data":{"app":{"name":"my-app"}}}`},
+	}}
+	client := NewClientFromOptions(ClientOptions{
+		BaseURL:     "http://example.test",
+		Transport:   &Transport{UnderlyingTransport: tripper},
+		RetryPolicy: fastRetryPolicy(),
+	})
+
+	if _, err := client.GetAppBasic(context.Background(), "my-app"); err != nil {
+		t.Fatalf("GetAppBasic() error = %v", err)
+	}
+	if got := tripper.callCount(); got != 3 {
+		t.Errorf("transport got %d requests, want 3", got)
+	}
+}
@@ -0,0 +1,12 @@
+package fly
+
+import _ "embed"
+
+//go:embed schema.graphql
+var graphQLSchema string
+
+// GraphQLSchema returns the schema of the GraphQL API, in SDL, that the
+// client's queries are written against.
+func GraphQLSchema() string {
+	return graphQLSchema
+}
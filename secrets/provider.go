@@ -0,0 +1,198 @@
+package secrets
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Provider is where the secrets an app should have come from, by name.
+type Provider interface {
+	Secrets(ctx context.Context) (map[string]string, error)
+}
+
+// ProviderFunc adapts a function to a Provider.
+type ProviderFunc func(ctx context.Context) (map[string]string, error)
+
+func (f ProviderFunc) Secrets(ctx context.Context) (map[string]string, error) {
+	return f(ctx)
+}
+
+// Static is a Provider of a fixed set of secrets.
+type Static map[string]string
+
+func (s Static) Secrets(ctx context.Context) (map[string]string, error) {
+	return s, nil
+}
+
+type Format string
+
+const (
+	FormatDotenv Format = "dotenv"
+	FormatJSON   Format = "json"
+)
+
+// File is a Provider that reads the secrets from a local file, in dotenv
+// format, or as a JSON object of strings.
+type File struct {
+	Path string
+	// Format is the format of the file. Without one, a file ending in .json
+	// is JSON, and any other is dotenv.
+	Format Format
+}
+
+func (f File) Secrets(ctx context.Context) (map[string]string, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	format := f.Format
+	if format == "" {
+		format = FormatDotenv
+		if strings.EqualFold(filepath.Ext(f.Path), ".json") {
+			format = FormatJSON
+		}
+	}
+
+	var out map[string]string
+	switch format {
+	case FormatDotenv:
+		out, err = ParseDotenv(file)
+	case FormatJSON:
+		out, err = ParseJSON(file)
+	default:
+		return nil, fmt.Errorf("unknown secrets file format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets from %s: %w", f.Path, err)
+	}
+
+	return out, nil
+}
+
+// ParseJSON reads secrets from a JSON object of strings.
+func ParseJSON(r io.Reader) (map[string]string, error) {
+	var out map[string]string
+	if err := json.NewDecoder(r).Decode(&out); err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = map[string]string{}
+	}
+
+	return out, nil
+}
+
+var dotenvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// ParseDotenv reads secrets from lines of NAME=value. Blank lines, lines
+// starting with # and a leading "export " are ignored. A value may be:
+//
+//   - unquoted, ending at the line or at a # after whitespace, and trimmed;
+//   - single-quoted, and taken literally;
+//   - double-quoted, with \n, \r, \t, \" and \\ escapes, and may span lines.
+//
+// A name that's set more than once takes the last value.
+func ParseDotenv(r io.Reader) (map[string]string, error) {
+	out := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !dotenvName.MatchString(name) {
+			return nil, fmt.Errorf("line %d: expected NAME=value", lineNo)
+		}
+		value = strings.TrimLeft(value, " \t")
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			start := lineNo
+			value = value[1:]
+			var b strings.Builder
+			for {
+				rest, closed := unescapeDoubleQuoted(value, &b)
+				if closed {
+					if tail := strings.TrimSpace(rest); tail != "" && !strings.HasPrefix(tail, "#") {
+						return nil, fmt.Errorf("line %d: unexpected %q after closing quote", lineNo, tail)
+					}
+					break
+				}
+				if !scanner.Scan() {
+					return nil, fmt.Errorf("line %d: unterminated double-quoted value", start)
+				}
+				lineNo++
+				b.WriteByte('\n')
+				value = scanner.Text()
+			}
+			out[name] = b.String()
+
+		case strings.HasPrefix(value, "'"):
+			end := strings.IndexByte(value[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated single-quoted value", lineNo)
+			}
+			if tail := strings.TrimSpace(value[end+2:]); tail != "" && !strings.HasPrefix(tail, "#") {
+				return nil, fmt.Errorf("line %d: unexpected %q after closing quote", lineNo, tail)
+			}
+			out[name] = value[1 : end+1]
+
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = value[:i]
+			}
+			if i := strings.Index(value, "\t#"); i >= 0 {
+				value = value[:i]
+			}
+			out[name] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// unescapeDoubleQuoted writes s to b up to an unescaped double quote,
+// unescaping it as it goes. It returns what follows the quote, and whether
+// there was one.
+func unescapeDoubleQuoted(s string, b *strings.Builder) (string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return s[i+1:], true
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,198 @@
+// Package secrets brings an app's secrets in line with a desired set of them,
+// read from a dotenv file, JSON or any other Provider.
+//
+// The API doesn't say how it digests secrets, so their current values can't be
+// compared with the desired ones. Instead, each Sync returns a State recording
+// the digests the app's secrets had once it was done, for the next Sync to
+// tell which of them are unchanged since. Without one, every desired secret is
+// set again.
+//
+// Every difference is applied in a single update. The update's version can be
+// passed to deploys as LaunchMachineInput.MinSecretsVersion so that machines
+// only start once they can see it.
+package secrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/flaps"
+)
+
+type Options struct {
+	// Prune unsets the app's secrets that aren't in the desired set. Without
+	// it, they're left alone.
+	Prune bool
+
+	// DryRun only works out the changes, without making them.
+	DryRun bool
+
+	// State is the Result.State of the last Sync of the app's secrets.
+	State State
+}
+
+// State records the secrets a Sync left an app with, by name, for the next
+// Sync to compare with. It holds hashes of their values, never the values, and
+// can be saved as JSON.
+type State map[string]SecretState
+
+type SecretState struct {
+	// Digest is the digest the API reported for the secret.
+	Digest string `json:"digest"`
+	// Hash is the SHA-256 of the value the secret was set to, in hex.
+	Hash string `json:"hash"`
+}
+
+// unchanged reports whether the app's secret, with its current digest, still
+// has the value it had when the state was recorded, and that value is the
+// desired one.
+func (s State) unchanged(name, digest, value string) bool {
+	st, ok := s[name]
+	return ok && st.Digest == digest && st.Hash == hash(value)
+}
+
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+type ChangeKind string
+
+const (
+	Added   ChangeKind = "added"
+	Changed ChangeKind = "changed"
+	Removed ChangeKind = "removed"
+)
+
+// Change is a difference between a secret the app has and the one it should.
+type Change struct {
+	Name string
+	Kind ChangeKind
+}
+
+// Result is what Sync changed, or would change with Options.DryRun.
+type Result struct {
+	// Changes are in order of the secrets' names.
+	Changes []Change
+	// Version is the app's secrets version after the update, or zero if
+	// nothing was updated.
+	Version uint64
+	// State is what to pass as Options.State to the next Sync. It's nil
+	// after a dry run.
+	State State
+}
+
+// MinSecretsVersion is the version to pass as
+// LaunchMachineInput.MinSecretsVersion, or nil if there's no need to.
+func (r *Result) MinSecretsVersion() *uint64 {
+	if r.Version == 0 {
+		return nil
+	}
+
+	return fly.Pointer(r.Version)
+}
+
+// Sync reads the secrets from p and updates the app's to match them.
+func Sync(ctx context.Context, client *flaps.Client, appName string, p Provider, opts Options) (*Result, error) {
+	desired, err := p.Secrets(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read desired secrets: %w", err)
+	}
+
+	return Apply(ctx, client, appName, desired, opts)
+}
+
+// Apply updates the app's secrets to match desired, in a single update of
+// only the secrets that may differ.
+func Apply(ctx context.Context, client *flaps.Client, appName string, desired map[string]string, opts Options) (*Result, error) {
+	current, err := client.ListAppSecrets(ctx, appName, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := Diff(current, desired, opts)
+	result := &Result{Changes: changes}
+	if opts.DryRun {
+		return result, nil
+	}
+	if len(changes) == 0 {
+		result.State = newState(current, desired)
+		return result, nil
+	}
+
+	values := make(map[string]*string, len(changes))
+	for _, c := range changes {
+		if c.Kind == Removed {
+			values[c.Name] = nil
+		} else {
+			values[c.Name] = fly.Pointer(desired[c.Name])
+		}
+	}
+	resp, err := client.UpdateAppSecrets(ctx, appName, values)
+	if err != nil {
+		return nil, err
+	}
+	result.Version = resp.Version
+
+	// The new digests are only known by listing the secrets again.
+	current, err = client.ListAppSecrets(ctx, appName, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	result.State = newState(current, desired)
+
+	return result, nil
+}
+
+// newState records the digests of the app's secrets that have the desired
+// values.
+func newState(current []fly.AppSecret, desired map[string]string) State {
+	state := make(State, len(desired))
+	for _, s := range current {
+		if value, ok := desired[s.Name]; ok {
+			state[s.Name] = SecretState{Digest: s.Digest, Hash: hash(value)}
+		}
+	}
+
+	return state
+}
+
+// Diff compares the app's current secrets with the desired ones, and returns
+// the changes that would make them match, in order of name. A secret the app
+// has is only taken to be unchanged if opts.State shows it hasn't changed
+// since the last Sync, which set it to the desired value.
+func Diff(current []fly.AppSecret, desired map[string]string, opts Options) []Change {
+	digests := make(map[string]string, len(current))
+	for _, s := range current {
+		digests[s.Name] = s.Digest
+	}
+
+	var changes []Change
+	for _, name := range slices.Sorted(maps.Keys(desired)) {
+		d, ok := digests[name]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Name: name, Kind: Added})
+		case !opts.State.unchanged(name, d, desired[name]):
+			changes = append(changes, Change{Name: name, Kind: Changed})
+		}
+	}
+	if opts.Prune {
+		for _, name := range slices.Sorted(maps.Keys(digests)) {
+			if _, ok := desired[name]; !ok {
+				changes = append(changes, Change{Name: name, Kind: Removed})
+			}
+		}
+		slices.SortStableFunc(changes, func(a, b Change) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+
+	return changes
+}
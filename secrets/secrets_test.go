@@ -0,0 +1,146 @@
+package secrets
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/superfly/fly-go/flaps/flapstest"
+)
+
+func TestParseDotenv(t *testing.T) {
+	in := `# database
+export DATABASE_URL=postgres://db:5432/app # inline comment
+API_KEY = 'it\n # literal'
+EMPTY=
+GREETING="hello\n\"world\""
+PEM="-----BEGIN KEY-----
+abc
+-----END KEY-----"
+DUP=first
+DUP=last
+`
+	got, err := ParseDotenv(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ParseDotenv() error = %v", err)
+	}
+	want := map[string]string{
+		"DATABASE_URL": "postgres://db:5432/app",
+		"API_KEY":      `it\n # literal`,
+		"DUP":          "last",
+		"EMPTY":        "",
+		"GREETING":     "hello\n\"world\"",
+		"PEM":          "-----BEGIN KEY-----\nabc\n-----END KEY-----",
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("ParseDotenv() = %q, want %q", got, want)
+	}
+
+	for _, bad := range []string{"NOEQUALS", "1BAD=x", `OPEN="never closed`, "SINGLE='open", `TRAILING="x" y`} {
+		if _, err := ParseDotenv(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseDotenv(%q) error = nil", bad)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "secrets.json")
+	if err := os.WriteFile(jsonPath, []byte(`{"A": "1", "B": "two"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := File{Path: jsonPath}.Secrets(context.Background())
+	if err != nil {
+		t.Fatalf("Secrets() error = %v", err)
+	}
+	if want := map[string]string{"A": "1", "B": "two"}; !maps.Equal(got, want) {
+		t.Fatalf("Secrets() = %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(jsonPath, []byte(`{"A": 1}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (File{Path: jsonPath}).Secrets(context.Background()); err == nil {
+		t.Fatal("Secrets() of a non-string JSON value error = nil")
+	}
+}
+
+func TestSync(t *testing.T) {
+	srv := flapstest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddApp("my-app", "personal")
+	srv.SetSecret("my-app", "SAME", "unchanged")
+	srv.SetSecret("my-app", "ROTATED", "old")
+	srv.SetSecret("my-app", "STALE", "gone")
+
+	ctx := context.Background()
+	client, err := srv.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	desired := Static{"SAME": "unchanged", "ROTATED": "new", "FRESH": "hi"}
+
+	// Without a state from an earlier sync, there's no telling the secrets
+	// the app has are already as desired.
+	result, err := Sync(ctx, client, "my-app", desired, Options{Prune: true, DryRun: true})
+	if err != nil {
+		t.Fatalf("Sync(DryRun) error = %v", err)
+	}
+	wantChanges := []Change{{"FRESH", Added}, {"ROTATED", Changed}, {"SAME", Changed}, {"STALE", Removed}}
+	if !slices.Equal(result.Changes, wantChanges) {
+		t.Fatalf("Sync(DryRun) changes = %+v, want %+v", result.Changes, wantChanges)
+	}
+	if result.MinSecretsVersion() != nil || result.State != nil {
+		t.Errorf("Sync(DryRun) = %+v, want no version or state", result)
+	}
+	if _, version := srv.Secrets("my-app"); version != 3 {
+		t.Fatalf("a dry run changed the secrets to version %d", version)
+	}
+
+	result, err = Sync(ctx, client, "my-app", desired, Options{})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if !slices.Equal(result.Changes, wantChanges[:3]) {
+		t.Fatalf("Sync() without Prune changes = %+v, want %+v", result.Changes, wantChanges[:3])
+	}
+	got, version := srv.Secrets("my-app")
+	if version != 4 || result.Version != 4 || *result.MinSecretsVersion() != 4 {
+		t.Errorf("Sync() version = %d, server at %d, want a single update to 4", result.Version, version)
+	}
+	want := map[string]string{"SAME": "unchanged", "ROTATED": "new", "FRESH": "hi", "STALE": "gone"}
+	if !maps.Equal(got, want) {
+		t.Errorf("secrets = %q, want %q", got, want)
+	}
+	if names := slices.Sorted(maps.Keys(result.State)); !slices.Equal(names, []string{"FRESH", "ROTATED", "SAME"}) {
+		t.Fatalf("Sync() state of %v, want the desired secrets", names)
+	}
+
+	state := result.State
+	result, err = Sync(ctx, client, "my-app", desired, Options{State: state})
+	if err != nil || len(result.Changes) != 0 || result.Version != 0 {
+		t.Errorf("Sync() of synced secrets = %+v, %v, want no changes", result, err)
+	}
+	if !maps.Equal(result.State, state) {
+		t.Errorf("Sync() of synced secrets state = %+v, want %+v", result.State, state)
+	}
+
+	// A secret set since, elsewhere, and one whose desired value changed,
+	// are both set again.
+	srv.SetSecret("my-app", "SAME", "tampered")
+	desired["FRESH"] = "hello"
+	result, err = Sync(ctx, client, "my-app", desired, Options{State: state})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if want := []Change{{"FRESH", Changed}, {"SAME", Changed}}; !slices.Equal(result.Changes, want) {
+		t.Errorf("Sync() changes = %+v, want %+v", result.Changes, want)
+	}
+	if got, _ := srv.Secrets("my-app"); got["SAME"] != "unchanged" || got["FRESH"] != "hello" {
+		t.Errorf("secrets = %q, want SAME and FRESH set again", got)
+	}
+}
@@ -10,11 +10,8 @@ type Query struct {
 	Errors Errors
 
 	Apps struct {
-		PageInfo struct {
-			HasNextPage bool
-			EndCursor   string
-		}
-		Nodes []App
+		PageInfo PageInfo
+		Nodes    []App
 	}
 	App                    App
 	AppLimitedAccessTokens *struct {
@@ -28,13 +25,15 @@ type Query struct {
 	Viewer          User
 	GqlMachine      GqlMachine
 	Organizations   struct {
-		Nodes []Organization
+		PageInfo PageInfo
+		Nodes    []Organization
 	}
 
 	Organization           *Organization
 	OrgLimitedAccessTokens *struct {
 		LimitedAccessTokens struct {
-			Nodes []LimitedAccessToken
+			PageInfo PageInfo
+			Nodes    []LimitedAccessToken
 		}
 	}
 	OrganizationDetails OrganizationDetails
@@ -256,7 +255,8 @@ type App struct {
 	Secrets        []Secret
 	CurrentRelease *Release
 	Releases       struct {
-		Nodes []Release
+		PageInfo PageInfo
+		Nodes    []Release
 	}
 	IPAddresses struct {
 		Nodes []IPAddress
@@ -390,8 +390,9 @@ type Organization struct {
 	WireGuardPeer *WireGuardPeer
 
 	WireGuardPeers struct {
-		Nodes *[]*WireGuardPeer
-		Edges *[]*struct {
+		PageInfo PageInfo
+		Nodes    *[]*WireGuardPeer
+		Edges    *[]*struct {
 			Cursor *string
 			Node   *WireGuardPeer
 		}
@@ -406,7 +407,8 @@ type Organization struct {
 	}
 
 	LoggedCertificates *struct {
-		Nodes []LoggedCertificate
+		PageInfo PageInfo
+		Nodes    []LoggedCertificate
 	}
 }
 
@@ -557,30 +559,47 @@ type LogEntry struct {
 	Level     string
 	Instance  string
 	Region    string
-	Meta      struct {
-		Instance string
-		Region   string
-		Event    struct {
-			Provider string
-		}
-		HTTP struct {
-			Request struct {
-				ID      string
-				Method  string
-				Version string
-			}
-			Response struct {
-				StatusCode int `json:"status_code"`
-			}
-		}
-		Error struct {
-			Code    int
-			Message string
-		}
-		URL struct {
-			Full string
-		}
-	}
+	Meta      LogMeta
+}
+
+// LogMeta is what the platform knows about where a log entry came from.
+type LogMeta struct {
+	Instance     string   `json:"instance,omitempty"`
+	Region       string   `json:"region,omitempty"`
+	ProcessGroup string   `json:"process_group,omitempty"`
+	Event        LogEvent `json:"event"`
+	HTTP         LogHTTP  `json:"http"`
+	Error        LogError `json:"error"`
+	URL          LogURL   `json:"url"`
+}
+
+type LogEvent struct {
+	Provider string `json:"provider,omitempty"`
+}
+
+// LogHTTP describes the request a log entry of the proxy is about.
+type LogHTTP struct {
+	Request  LogHTTPRequest  `json:"request"`
+	Response LogHTTPResponse `json:"response"`
+}
+
+type LogHTTPRequest struct {
+	ID      string `json:"id,omitempty"`
+	Method  string `json:"method,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type LogHTTPResponse struct {
+	StatusCode int `json:"status_code,omitempty"`
+}
+
+type LogError struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type LogURL struct {
+	Full string `json:"full,omitempty"`
 }
 
 type GeoRegion string
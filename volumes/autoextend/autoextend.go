@@ -0,0 +1,301 @@
+// Package autoextend grows the volumes of an app's machines as they fill up,
+// following the ExtendThresholdPercent, AddSizeGb and SizeGbLimit of each
+// machine's mounts.
+//
+// How full a volume is comes from a Source, such as ExecDF, which runs df in
+// the machine, or one backed by metrics. A volume at or past its threshold is
+// extended by AddSizeGb, up to SizeGbLimit, and if the API reports that the
+// machine needs a restart to see the new size, the machine is restarted while
+// holding a lease on it.
+//
+// An Extender remembers the extensions whose restart it left to the caller,
+// along with the instance the machine was running, and doesn't extend those
+// volumes again until the machine is running a new instance.
+package autoextend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/flaps"
+)
+
+const defaultInterval = time.Minute
+
+// Usage is how much of a mounted volume is in use.
+type Usage struct {
+	// Path is where the volume is mounted in the machine.
+	Path       string
+	UsedBytes  uint64
+	TotalBytes uint64
+}
+
+// Percent is how full the volume is, from 0 to 100.
+func (u Usage) Percent() float64 {
+	if u.TotalBytes == 0 {
+		return 0
+	}
+
+	return 100 * float64(u.UsedBytes) / float64(u.TotalBytes)
+}
+
+// Source reports the usage of the volumes mounted in a machine, each by the
+// path it's mounted at. Mounts it doesn't report on are left alone.
+type Source interface {
+	Usage(ctx context.Context, m *fly.Machine) ([]Usage, error)
+}
+
+// SourceFunc adapts a function to a Source.
+type SourceFunc func(ctx context.Context, m *fly.Machine) ([]Usage, error)
+
+func (f SourceFunc) Usage(ctx context.Context, m *fly.Machine) ([]Usage, error) {
+	return f(ctx, m)
+}
+
+type Options struct {
+	Source Source
+
+	// Interval is how often Run checks the volumes. Defaults to a minute.
+	Interval time.Duration
+
+	// NoRestart leaves machines that need a restart to see their extended
+	// volume alone, for the caller to restart when it suits them.
+	NoRestart bool
+
+	// LeaseTTL is the TTL, in seconds, of the lease held on a machine while
+	// it's restarted. Zero uses the API's default.
+	LeaseTTL int
+}
+
+// Extension is a volume Check extended, or found it couldn't.
+type Extension struct {
+	MachineID string
+	VolumeID  string
+	Path      string
+	Usage     Usage
+
+	// FromGb and ToGb are the size of the volume before and after. They're
+	// the same for a volume that's already as large as its SizeGbLimit.
+	FromGb int
+	ToGb   int
+
+	// NeedsRestart is set if the machine needed a restart to see the new
+	// size, and Restarted if it was restarted.
+	NeedsRestart bool
+	Restarted    bool
+
+	// Pending is set if the volume was already extended, but the machine
+	// hasn't been restarted onto a new instance to see the new size yet, so
+	// it was left as it is.
+	Pending bool
+}
+
+// AtLimit reports whether the volume is full enough to extend but already as
+// large as it's allowed to get.
+func (e Extension) AtLimit() bool {
+	return !e.Pending && e.ToGb == e.FromGb
+}
+
+// Extender checks an app's volumes, remembering between checks the
+// extensions that are waiting for their machine to be restarted.
+type Extender struct {
+	client  *flaps.Client
+	appName string
+	opts    Options
+
+	mu sync.Mutex
+	// pending holds the volumes extended without the restart they needed,
+	// each with the instance its machine was running at the time.
+	pending map[string]string
+}
+
+// NewExtender returns an Extender for the app's volumes.
+func NewExtender(client *flaps.Client, appName string, opts Options) *Extender {
+	return &Extender{
+		client:  client,
+		appName: appName,
+		opts:    opts,
+		pending: make(map[string]string),
+	}
+}
+
+// Run checks the app's volumes every opts.Interval until ctx is done, and
+// passes what each check did to report, which may be nil. A check that fails
+// doesn't stop Run; its error is passed to report too.
+func Run(ctx context.Context, client *flaps.Client, appName string, opts Options, report func([]Extension, error)) error {
+	return NewExtender(client, appName, opts).Run(ctx, report)
+}
+
+// Run checks the volumes every Interval until ctx is done, as the package's
+// Run does.
+func (e *Extender) Run(ctx context.Context, report func([]Extension, error)) error {
+	interval := e.opts.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		extensions, err := e.Check(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if report != nil {
+			report(extensions, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check extends each volume of the app's started machines that's at or past
+// its mount's threshold, and returns what it did. It carries on past the
+// machines it can't check or change, and returns their errors joined
+// together.
+func (e *Extender) Check(ctx context.Context) ([]Extension, error) {
+	if e.opts.Source == nil {
+		return nil, errors.New("autoextend: no usage source")
+	}
+
+	machines, err := e.client.ListWithOptions(ctx, e.appName, flaps.ListMachinesOptions{
+		States: []string{fly.MachineStateStarted},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var extensions []Extension
+	var errs []error
+	for _, m := range machines {
+		if len(extendable(m)) == 0 {
+			continue
+		}
+
+		ext, err := e.checkMachine(ctx, m)
+		extensions = append(extensions, ext...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("machine %s: %w", m.ID, err))
+		}
+	}
+
+	return extensions, errors.Join(errs...)
+}
+
+// extendable returns the mounts of the machine that grow automatically.
+func extendable(m *fly.Machine) []fly.MachineMount {
+	var mounts []fly.MachineMount
+	for _, mount := range m.GetConfig().Mounts {
+		if mount.ExtendThresholdPercent > 0 && mount.AddSizeGb > 0 && mount.Volume != "" {
+			mounts = append(mounts, mount)
+		}
+	}
+
+	return mounts
+}
+
+func (e *Extender) checkMachine(ctx context.Context, m *fly.Machine) ([]Extension, error) {
+	usages, err := e.opts.Source.Usage(ctx, m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get volume usage: %w", err)
+	}
+	byPath := make(map[string]Usage, len(usages))
+	for _, u := range usages {
+		byPath[u.Path] = u
+	}
+
+	var extensions []Extension
+	needsRestart := false
+	for _, mount := range extendable(m) {
+		pending := e.isPending(mount.Volume, m)
+		u, ok := byPath[mount.Path]
+		if !ok || u.Percent() < float64(mount.ExtendThresholdPercent) {
+			continue
+		}
+
+		ext, err := extend(ctx, e.client, e.appName, m, mount, u, pending)
+		if err != nil {
+			return extensions, err
+		}
+		extensions = append(extensions, ext)
+		needsRestart = needsRestart || ext.NeedsRestart
+	}
+
+	if needsRestart && !e.opts.NoRestart {
+		err = e.client.WithLease(ctx, e.appName, m.ID, e.opts.LeaseTTL, func(ctx context.Context, nonce string) error {
+			return e.client.Restart(ctx, e.appName, fly.RestartMachineInput{ID: m.ID}, nonce)
+		})
+		if err == nil {
+			for i := range extensions {
+				extensions[i].Restarted = extensions[i].NeedsRestart
+			}
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ext := range extensions {
+		if ext.NeedsRestart && !ext.Restarted {
+			e.pending[ext.VolumeID] = m.InstanceID
+		}
+	}
+
+	return extensions, err
+}
+
+// isPending reports whether the volume was extended without a restart while
+// the machine was running its current instance. Once the machine is running
+// another instance, the volume is no longer pending.
+func (e *Extender) isPending(volumeID string, m *fly.Machine) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	instanceID, ok := e.pending[volumeID]
+	if ok && instanceID != m.InstanceID {
+		delete(e.pending, volumeID)
+		return false
+	}
+
+	return ok
+}
+
+func extend(ctx context.Context, client *flaps.Client, appName string, m *fly.Machine, mount fly.MachineMount, u Usage, pending bool) (Extension, error) {
+	ext := Extension{MachineID: m.ID, VolumeID: mount.Volume, Path: mount.Path, Usage: u}
+
+	v, err := client.GetVolume(ctx, appName, mount.Volume)
+	if err != nil {
+		return ext, err
+	}
+	ext.FromGb, ext.ToGb = v.SizeGb, v.SizeGb
+	if pending {
+		ext.Pending = true
+		return ext, nil
+	}
+
+	size := v.SizeGb + mount.AddSizeGb
+	if mount.SizeGbLimit > 0 {
+		size = min(size, mount.SizeGbLimit)
+	}
+	if size <= v.SizeGb {
+		return ext, nil
+	}
+
+	v, ext.NeedsRestart, err = client.ExtendVolume(ctx, appName, mount.Volume, size)
+	if err != nil {
+		return ext, err
+	}
+	ext.ToGb = size
+	if v != nil {
+		ext.ToGb = v.SizeGb
+	}
+
+	return ext, nil
+}
@@ -0,0 +1,153 @@
+package autoextend
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/flaps"
+	"github.com/superfly/fly-go/flaps/flapstest"
+)
+
+func TestParseDF(t *testing.T) {
+	out := `Filesystem     1024-blocks    Used Available Capacity Mounted on
+/dev/vdc           1011672  808604    134724      86% /data
+/dev/vdd           2047208   20480   1921832       2% /my files
+`
+	usages, err := parseDF(out)
+	if err != nil {
+		t.Fatalf("parseDF() error = %v", err)
+	}
+	want := []Usage{
+		{Path: "/data", UsedBytes: 808604 << 10, TotalBytes: 1011672 << 10},
+		{Path: "/my files", UsedBytes: 20480 << 10, TotalBytes: 2047208 << 10},
+	}
+	if !slices.Equal(usages, want) {
+		t.Fatalf("parseDF() = %+v, want %+v", usages, want)
+	}
+
+	if _, err := parseDF("header\n/dev/vdc 1011672 lots 0 100% /data\n"); err == nil {
+		t.Fatal("parseDF() of a malformed line error = nil")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	srv := flapstest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddApp("my-app", "personal")
+	srv.ExtendNeedsRestart = true
+
+	full := srv.AddVolume("my-app", &fly.Volume{Name: "data", SizeGb: 3})
+	capped := srv.AddVolume("my-app", &fly.Volume{Name: "logs", SizeGb: 10})
+	roomy := srv.AddVolume("my-app", &fly.Volume{Name: "cache", SizeGb: 1})
+	m := srv.AddMachine("my-app", &fly.Machine{
+		Config: &fly.MachineConfig{Mounts: []fly.MachineMount{
+			{Volume: full.ID, Path: "/data", ExtendThresholdPercent: 80, AddSizeGb: 2, SizeGbLimit: 4},
+			{Volume: capped.ID, Path: "/logs", ExtendThresholdPercent: 80, AddSizeGb: 2, SizeGbLimit: 10},
+			{Volume: roomy.ID, Path: "/cache", ExtendThresholdPercent: 80, AddSizeGb: 2},
+		}},
+	})
+
+	source := SourceFunc(func(ctx context.Context, got *fly.Machine) ([]Usage, error) {
+		return []Usage{
+			{Path: "/data", UsedBytes: 2800 << 20, TotalBytes: 3000 << 20},
+			{Path: "/logs", UsedBytes: 9500 << 20, TotalBytes: 10000 << 20},
+			{Path: "/cache", UsedBytes: 100 << 20, TotalBytes: 1000 << 20},
+		}, nil
+	})
+
+	ctx := context.Background()
+	client, err := srv.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	extensions, err := NewExtender(client, "my-app", Options{Source: source}).Check(ctx)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(extensions) != 2 {
+		t.Fatalf("Check() = %+v, want the data and logs volumes", extensions)
+	}
+
+	data, logs := extensions[0], extensions[1]
+	if data.VolumeID != full.ID || data.FromGb != 3 || data.ToGb != 4 || !data.NeedsRestart || !data.Restarted {
+		t.Errorf("data extension = %+v, want 3GB to 4GB and a restart", data)
+	}
+	if v := srv.Volume("my-app", full.ID); v.SizeGb != 4 {
+		t.Errorf("data volume is %dGB, want the 4GB limit", v.SizeGb)
+	}
+	if logs.VolumeID != capped.ID || !logs.AtLimit() || logs.NeedsRestart {
+		t.Errorf("logs extension = %+v, want one at its limit", logs)
+	}
+	if v := srv.Volume("my-app", roomy.ID); v.SizeGb != 1 {
+		t.Errorf("cache volume is %dGB, want it left at 1GB", v.SizeGb)
+	}
+
+	got := srv.Machine("my-app", m.ID)
+	if len(got.Events) == 0 || got.Events[0].Type != "restart" {
+		t.Errorf("machine events = %+v, want a restart", got.Events)
+	}
+	if lease := srv.Lease("my-app", m.ID); lease != nil {
+		t.Errorf("Lease() = %+v after the restart, want nil", lease)
+	}
+}
+
+func TestCheckPendingRestart(t *testing.T) {
+	srv := flapstest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddApp("my-app", "personal")
+	srv.ExtendNeedsRestart = true
+
+	vol := srv.AddVolume("my-app", &fly.Volume{Name: "data", SizeGb: 3})
+	config := &fly.MachineConfig{Mounts: []fly.MachineMount{
+		{Volume: vol.ID, Path: "/data", ExtendThresholdPercent: 80, AddSizeGb: 1},
+	}}
+	m := srv.AddMachine("my-app", &fly.Machine{Config: config})
+
+	// Without a restart, the filesystem stays the size of the 3GB volume.
+	source := SourceFunc(func(ctx context.Context, got *fly.Machine) ([]Usage, error) {
+		return []Usage{{Path: "/data", UsedBytes: 2900 << 20, TotalBytes: 3000 << 20}}, nil
+	})
+
+	ctx := context.Background()
+	client, err := srv.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	e := NewExtender(client, "my-app", Options{Source: source, NoRestart: true})
+	for i := range 3 {
+		extensions, err := e.Check(ctx)
+		if err != nil || len(extensions) != 1 {
+			t.Fatalf("Check() #%d = %+v, %v, want one extension", i, extensions, err)
+		}
+		ext := extensions[0]
+		switch {
+		case i == 0 && (ext.FromGb != 3 || ext.ToGb != 4 || !ext.NeedsRestart || ext.Restarted || ext.Pending):
+			t.Errorf("Check() #%d = %+v, want 3GB to 4GB, left for a restart", i, ext)
+		case i > 0 && (ext.ToGb != 4 || !ext.Pending || ext.AtLimit()):
+			t.Errorf("Check() #%d = %+v, want a pending 4GB extension", i, ext)
+		}
+	}
+	if v := srv.Volume("my-app", vol.ID); v.SizeGb != 4 {
+		t.Fatalf("volume is %dGB, want it extended once to 4GB", v.SizeGb)
+	}
+
+	// Once the machine runs a new instance, a volume that's still full is
+	// extended again.
+	if _, err := client.Update(ctx, "my-app", fly.LaunchMachineInput{ID: m.ID, Config: config}, ""); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := client.Wait(ctx, "my-app", m.ID, flaps.WithWaitStates(fly.MachineStateStarted)); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	extensions, err := e.Check(ctx)
+	if err != nil || len(extensions) != 1 {
+		t.Fatalf("Check() after the update = %+v, %v, want one extension", extensions, err)
+	}
+	if ext := extensions[0]; ext.Pending || ext.FromGb != 4 || ext.ToGb != 5 {
+		t.Errorf("Check() after the update = %+v, want 4GB to 5GB", ext)
+	}
+}
@@ -0,0 +1,89 @@
+package autoextend
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/flaps"
+)
+
+// ExecDF is a Source that runs df in each machine, through the Machines API's
+// exec, for the usage of the paths its volumes are mounted at.
+type ExecDF struct {
+	Client  *flaps.Client
+	AppName string
+
+	// Timeout is how long df may run for, in seconds. Zero uses the API's
+	// default.
+	Timeout int
+}
+
+func (d ExecDF) Usage(ctx context.Context, m *fly.Machine) ([]Usage, error) {
+	var paths []string
+	for _, mount := range m.GetConfig().Mounts {
+		paths = append(paths, mount.Path)
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	out, err := d.Client.Exec(ctx, d.AppName, m.ID, &fly.MachineExecRequest{
+		Cmd:     "df -P -k " + strings.Join(paths, " "),
+		Timeout: d.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.ExitCode != 0 {
+		return nil, fmt.Errorf("df exited with code %d: %s", out.ExitCode, strings.TrimSpace(out.StdErr))
+	}
+
+	return parseDF(out.StdOut)
+}
+
+// parseDF parses the output of df -P -k: a header line, then a line for each
+// filesystem of its name, size, used and available 1024-byte blocks, capacity
+// and the path it's mounted at.
+func parseDF(out string) ([]Usage, error) {
+	var usages []Usage
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	header := true
+	for scanner.Scan() {
+		if header {
+			header = false
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("failed to parse df output line %q", scanner.Text())
+		}
+
+		total, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse df output line %q: %w", scanner.Text(), err)
+		}
+		used, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse df output line %q: %w", scanner.Text(), err)
+		}
+
+		usages = append(usages, Usage{
+			// The mount point is last, and may itself contain spaces.
+			Path:       strings.Join(fields[5:], " "),
+			UsedBytes:  used << 10,
+			TotalBytes: total << 10,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return usages, nil
+}
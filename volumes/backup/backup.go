@@ -0,0 +1,232 @@
+// Package backup checks the snapshots of an app's volumes against a retention
+// policy, and brings the volumes that fall short of it back in line.
+//
+// The Machines API takes a snapshot of each volume with automatic backups
+// every day, and keeps them for the volume's snapshot retention, so a policy
+// is enforced by turning automatic backups on, keeping snapshots for long
+// enough, and taking a snapshot of a volume that's gone too long without one.
+// Nothing is changed unless Options.Apply is set.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/flaps"
+)
+
+// day is how far apart the daily snapshots of a policy are.
+const day = 24 * time.Hour
+
+// now is the time the snapshots are checked at.
+var now = time.Now
+
+// Policy is how many snapshots each volume must have.
+type Policy struct {
+	// Daily is how many of the last days, counting back from now, must each
+	// have a snapshot.
+	Daily int
+	// Weekly is how many of the last weeks must each have a snapshot.
+	Weekly int
+	// MinCount is the fewest snapshots a volume may have.
+	MinCount int
+}
+
+// retentionDays is how long snapshots must be kept for the policy to be met.
+func (p Policy) retentionDays() int {
+	return max(p.Daily, 7*p.Weekly)
+}
+
+type Options struct {
+	Policy Policy
+
+	// Apply makes the changes that bring the volumes in line with the
+	// policy. Without it, Run only reports what it would change.
+	Apply bool
+}
+
+type ActionKind string
+
+const (
+	// EnableAutoBackup turns on a volume's daily snapshots.
+	EnableAutoBackup ActionKind = "enable_auto_backup"
+	// SetRetention keeps a volume's snapshots for longer.
+	SetRetention ActionKind = "set_retention"
+	// TakeSnapshot snapshots a volume now.
+	TakeSnapshot ActionKind = "take_snapshot"
+)
+
+// Action is a change to a volume that brings it closer to the policy.
+type Action struct {
+	Kind ActionKind
+	// RetentionDays is the snapshot retention SetRetention sets.
+	RetentionDays int
+}
+
+// VolumeReport is how a volume measures up to the policy.
+type VolumeReport struct {
+	Volume fly.Volume
+	// Snapshots is how many complete snapshots the volume has.
+	Snapshots int
+	// Violations describe each way the volume falls short of the policy.
+	Violations []string
+	// Actions are the changes Run made, or would make without
+	// Options.Apply, to bring the volume in line.
+	Actions []Action
+	// Err is why the volume couldn't be checked, or changed.
+	Err error
+}
+
+// Compliant reports whether the volume met the policy when it was checked.
+func (r *VolumeReport) Compliant() bool {
+	return r.Err == nil && len(r.Violations) == 0
+}
+
+// Report is how every volume of an app measures up to the policy.
+type Report struct {
+	DryRun  bool
+	Volumes []*VolumeReport
+}
+
+// Noncompliant returns the reports of the volumes that fall short of the
+// policy, or that couldn't be checked.
+func (r *Report) Noncompliant() []*VolumeReport {
+	var out []*VolumeReport
+	for _, v := range r.Volumes {
+		if !v.Compliant() {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
+
+// Run checks each of the app's volumes against the policy and, with
+// opts.Apply, makes the changes that bring them in line. It carries on past
+// volumes it can't check or change, and returns their errors joined together
+// along with the report.
+func Run(ctx context.Context, client *flaps.Client, appName string, opts Options) (*Report, error) {
+	volumes, err := client.GetVolumes(ctx, appName)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(volumes, func(a, b fly.Volume) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	report := &Report{DryRun: !opts.Apply}
+	var errs []error
+	for _, v := range volumes {
+		vr := &VolumeReport{Volume: v}
+		report.Volumes = append(report.Volumes, vr)
+
+		snapshots, err := client.GetVolumeSnapshots(ctx, appName, v.ID)
+		if err != nil {
+			vr.Err = err
+			errs = append(errs, err)
+			continue
+		}
+		check(vr, snapshots, opts.Policy)
+
+		if opts.Apply && len(vr.Actions) > 0 {
+			if err := apply(ctx, client, appName, vr); err != nil {
+				vr.Err = err
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return report, errors.Join(errs...)
+}
+
+// check fills in the report's violations, and the actions that fix them.
+func check(vr *VolumeReport, snapshots []fly.VolumeSnapshot, policy Policy) {
+	v := vr.Volume
+	var times []time.Time
+	for _, s := range snapshots {
+		if s.Status == flaps.SnapshotStatusCreated {
+			times = append(times, s.CreatedAt)
+		}
+	}
+	vr.Snapshots = len(times)
+	t := now()
+
+	// A snapshot is only taken for the violations one goes towards fixing.
+	snapshotDue := false
+	if days := missing(times, v.CreatedAt, t, day, policy.Daily); days > 0 {
+		vr.Violations = append(vr.Violations, fmt.Sprintf("%d of the last %d days have no snapshot", days, policy.Daily))
+		snapshotDue = true
+	}
+	if weeks := missing(times, v.CreatedAt, t, 7*day, policy.Weekly); weeks > 0 {
+		vr.Violations = append(vr.Violations, fmt.Sprintf("%d of the last %d weeks have no snapshot", weeks, policy.Weekly))
+		snapshotDue = true
+	}
+	if len(times) < policy.MinCount {
+		vr.Violations = append(vr.Violations, fmt.Sprintf("%d complete snapshots, fewer than the minimum of %d", len(times), policy.MinCount))
+		snapshotDue = true
+	}
+
+	scheduled := policy.Daily > 0 || policy.Weekly > 0
+	if scheduled && !v.AutoBackupEnabled {
+		vr.Violations = append(vr.Violations, "automatic backups are disabled")
+		vr.Actions = append(vr.Actions, Action{Kind: EnableAutoBackup})
+	}
+	if days := policy.retentionDays(); v.SnapshotRetention < days {
+		vr.Violations = append(vr.Violations, fmt.Sprintf("snapshots are kept for %d days, fewer than %d", v.SnapshotRetention, days))
+		vr.Actions = append(vr.Actions, Action{Kind: SetRetention, RetentionDays: days})
+	}
+
+	if snapshotDue {
+		vr.Actions = append(vr.Actions, Action{Kind: TakeSnapshot})
+	}
+}
+
+// missing counts the last n periods before now, of the volume's lifetime,
+// without a snapshot.
+func missing(times []time.Time, created, now time.Time, period time.Duration, n int) int {
+	count := 0
+	for i := range n {
+		end := now.Add(-time.Duration(i) * period)
+		start := end.Add(-period)
+		if start.Before(created) {
+			break
+		}
+		if !slices.ContainsFunc(times, func(t time.Time) bool { return !t.Before(start) && t.Before(end) }) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func apply(ctx context.Context, client *flaps.Client, appName string, vr *VolumeReport) error {
+	var update fly.UpdateVolumeRequest
+	snapshot := false
+	for _, a := range vr.Actions {
+		switch a.Kind {
+		case EnableAutoBackup:
+			update.AutoBackupEnabled = fly.Pointer(true)
+		case SetRetention:
+			update.SnapshotRetention = fly.Pointer(a.RetentionDays)
+		case TakeSnapshot:
+			snapshot = true
+		}
+	}
+
+	if update.AutoBackupEnabled != nil || update.SnapshotRetention != nil {
+		if _, err := client.UpdateVolume(ctx, appName, vr.Volume.ID, update); err != nil {
+			return err
+		}
+	}
+	if snapshot {
+		if err := client.CreateVolumeSnapshot(ctx, appName, vr.Volume.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	fly "github.com/superfly/fly-go"
+	"github.com/superfly/fly-go/flaps/flapstest"
+)
+
+func TestRun(t *testing.T) {
+	t0 := time.Date(2024, 5, 20, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return t0 }
+	t.Cleanup(func() { now = time.Now })
+
+	srv := flapstest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddApp("my-app", "personal")
+
+	good := srv.AddVolume("my-app", &fly.Volume{
+		Name:              "good",
+		CreatedAt:         t0.Add(-20 * day),
+		AutoBackupEnabled: true,
+		SnapshotRetention: 14,
+	})
+	for i := range 14 {
+		srv.AddVolumeSnapshot("my-app", good.ID, &fly.VolumeSnapshot{CreatedAt: t0.Add(-time.Duration(i)*day - time.Hour)})
+	}
+	bad := srv.AddVolume("my-app", &fly.Volume{
+		Name:              "bad",
+		CreatedAt:         t0.Add(-10 * day),
+		SnapshotRetention: 5,
+	})
+	srv.AddVolumeSnapshot("my-app", bad.ID, &fly.VolumeSnapshot{CreatedAt: t0.Add(-3 * day)})
+	srv.AddVolumeSnapshot("my-app", bad.ID, &fly.VolumeSnapshot{CreatedAt: t0.Add(-2 * day), Status: "failed"})
+
+	ctx := context.Background()
+	client, err := srv.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	opts := Options{Policy: Policy{Daily: 7, Weekly: 2, MinCount: 3}}
+
+	report, err := Run(ctx, client, "my-app", opts)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !report.DryRun {
+		t.Error("Run() without Apply isn't a dry run")
+	}
+	noncompliant := report.Noncompliant()
+	if len(noncompliant) != 1 || noncompliant[0].Volume.ID != bad.ID {
+		t.Fatalf("Noncompliant() = %+v, want only %s", noncompliant, bad.ID)
+	}
+
+	vr := noncompliant[0]
+	if vr.Snapshots != 1 {
+		t.Errorf("Snapshots = %d, want 1 complete snapshot", vr.Snapshots)
+	}
+	wantViolations := []string{
+		"6 of the last 7 days have no snapshot",
+		"1 complete snapshots, fewer than the minimum of 3",
+		"automatic backups are disabled",
+		"snapshots are kept for 5 days, fewer than 14",
+	}
+	if !slices.Equal(vr.Violations, wantViolations) {
+		t.Errorf("Violations = %q, want %q", vr.Violations, wantViolations)
+	}
+	wantActions := []Action{{Kind: EnableAutoBackup}, {Kind: SetRetention, RetentionDays: 14}, {Kind: TakeSnapshot}}
+	if !slices.Equal(vr.Actions, wantActions) {
+		t.Errorf("Actions = %+v, want %+v", vr.Actions, wantActions)
+	}
+	if v := srv.Volume("my-app", bad.ID); v.AutoBackupEnabled || v.SnapshotRetention != 5 {
+		t.Fatalf("a dry run changed the volume: %+v", v)
+	}
+
+	opts.Apply = true
+	if _, err := Run(ctx, client, "my-app", opts); err != nil {
+		t.Fatalf("Run(Apply) error = %v", err)
+	}
+	if v := srv.Volume("my-app", bad.ID); !v.AutoBackupEnabled || v.SnapshotRetention != 14 {
+		t.Errorf("Run(Apply) left the volume at %+v", v)
+	}
+	snapshots, err := client.GetVolumeSnapshots(ctx, "my-app", bad.ID)
+	if err != nil || len(snapshots) != 3 {
+		t.Errorf("GetVolumeSnapshots() = %d snapshots, %v, want a new one", len(snapshots), err)
+	}
+	// The good volume's snapshots have the status the API gives finished
+	// ones, so they count towards the policy and no more are taken.
+	snapshots, err = client.GetVolumeSnapshots(ctx, "my-app", good.ID)
+	if err != nil || len(snapshots) != 14 || snapshots[0].Status != "created" {
+		t.Errorf("GetVolumeSnapshots() of the good volume = %+v, %v, want its 14 created snapshots", snapshots, err)
+	}
+}
+
+func TestCheckCompliantTakesNoSnapshot(t *testing.T) {
+	t0 := time.Date(2024, 5, 20, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return t0 }
+	t.Cleanup(func() { now = time.Now })
+
+	// Weekly snapshots are kept, but the newest is days old, which the
+	// policy allows.
+	vr := &VolumeReport{Volume: fly.Volume{
+		CreatedAt:         t0.Add(-30 * day),
+		AutoBackupEnabled: true,
+		SnapshotRetention: 14,
+	}}
+	snapshots := []fly.VolumeSnapshot{
+		{CreatedAt: t0.Add(-3 * day), Status: "created"},
+		{CreatedAt: t0.Add(-10 * day), Status: "created"},
+	}
+	check(vr, snapshots, Policy{Weekly: 2, MinCount: 2})
+
+	if !vr.Compliant() || len(vr.Actions) != 0 {
+		t.Fatalf("check() = %q violations, %+v actions, want neither", vr.Violations, vr.Actions)
+	}
+}